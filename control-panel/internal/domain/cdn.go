@@ -2,10 +2,93 @@ package domain
 
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
+// TTL policies deciding how origin caching headers affect the cache TTL
+const (
+	TTLPolicyRespect  = "respect"  // use origin freshness, CacheTTL when the origin sets none
+	TTLPolicyOverride = "override" // always use CacheTTL
+	TTLPolicyClamp    = "clamp"    // use origin freshness clamped to MinTTL and MaxTTL
+)
+
+// Query key modes deciding which query parameters are part of the cache key
+const (
+	QueryKeyInclude   = "include"   // the query string as sent, the default
+	QueryKeyIgnore    = "ignore"    // no query parameters
+	QueryKeySorted    = "sorted"    // all parameters, sorted by name
+	QueryKeyAllowlist = "allowlist" // only the parameters in QueryKeyParams, sorted
+	QueryKeyDenylist  = "denylist"  // all but the parameters in QueryKeyParams, sorted
+)
+
 type CDN struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Origin   string             `bson:"origin" json:"origin"`
-	Domain   string             `bson:"domain" json:"domain"`
-	IsActive bool               `bson:"is_active" json:"is_active"`
-	CacheTTL uint               `bson:"cache_ttl" json:"cache_ttl"`
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Origin    string             `bson:"origin" json:"origin"` // the primary origin of Origins
+	Domain    string             `bson:"domain" json:"domain"`
+	IsActive  bool               `bson:"is_active" json:"is_active"`
+	CacheTTL  uint               `bson:"cache_ttl" json:"cache_ttl"`
+	TTLPolicy string             `bson:"ttl_policy" json:"ttl_policy"`
+	MinTTL    uint               `bson:"min_ttl" json:"min_ttl"`
+	MaxTTL    uint               `bson:"max_ttl" json:"max_ttl"`       // 0 means no upper bound
+	SliceSize uint               `bson:"slice_size" json:"slice_size"` // bytes, 0 caches whole objects
+
+	// Grace windows in seconds during which expired items are served stale
+	StaleWhileRevalidate uint `bson:"stale_while_revalidate" json:"stale_while_revalidate"`
+	StaleIfError         uint `bson:"stale_if_error" json:"stale_if_error"`
+
+	QueryKeyMode   string   `bson:"query_key_mode" json:"query_key_mode"`
+	QueryKeyParams []string `bson:"query_key_params" json:"query_key_params"` // for the allowlist and denylist modes
+
+	// TTLs in seconds of cached redirect and error statuses (301, 302, 404 and
+	// 410) by status code. Statuses without one are not cached.
+	StatusTTLs map[string]uint `bson:"status_ttls" json:"status_ttls"`
+
+	// Rules deciding what is cached, evaluated in order by the mids and edges
+	// before their default rule, which caches static assets
+	CacheRules []CacheRule `bson:"cache_rules" json:"cache_rules"`
+
+	// Leaves the cache debug headers out of responses to requests without a
+	// valid signed debug header
+	HideDebugHeaders bool `bson:"hide_debug_headers" json:"hide_debug_headers"`
+
+	// Pool of origins the mids balance cache fills over with OriginBalance,
+	// failing over to the next origin on connection errors and 5xx responses
+	Origins       []OriginServer `bson:"origins" json:"origins"`
+	OriginBalance string         `bson:"origin_balance" json:"origin_balance"`
+}
+
+// Origin balancing strategies deciding which origin of a pool a fill goes to
+const (
+	OriginBalanceRoundRobin       = "round_robin"       // weighted round-robin, the default
+	OriginBalanceLeastConnections = "least_connections" // fewest requests in flight relative to weight
+	OriginBalanceIPHash           = "ip_hash"           // weighted hashing of the client IP
+)
+
+// OriginServer is an origin of a CDN's pool. Origins of the lowest priority
+// share the fills by weight, the others are backups used when they fail.
+type OriginServer struct {
+	URL      string `bson:"url" json:"url"`
+	Weight   uint   `bson:"weight" json:"weight"`     // relative share of the fills, 1 when 0
+	Priority uint   `bson:"priority" json:"priority"` // lower is preferred, 0 for primaries
+}
+
+// Cache rule actions
+const (
+	CacheRuleCache  = "cache"  // store matching responses
+	CacheRuleBypass = "bypass" // neither serve matching requests from cache nor store them
+)
+
+// CacheRule decides whether the requests and responses it matches are cached.
+// A rule matches when all of its conditions do; empty conditions match
+// anything.
+type CacheRule struct {
+	Name         string            `bson:"name" json:"name"`
+	PathGlob     string            `bson:"path_glob" json:"path_glob"`         // "*" matches within a path segment, "**" across segments
+	PathRegex    string            `bson:"path_regex" json:"path_regex"`       // RE2 syntax, unanchored
+	Extensions   []string          `bson:"extensions" json:"extensions"`       // of the last path segment, without the dot
+	Methods      []string          `bson:"methods" json:"methods"`             // request methods
+	ContentTypes []string          `bson:"content_types" json:"content_types"` // prefixes of the response Content-Type
+	Headers      map[string]string `bson:"headers" json:"headers"`             // request headers by name, with this value or any when empty
+	Cookies      map[string]string `bson:"cookies" json:"cookies"`             // request cookies by name, with this value or any when empty
+
+	Action    string `bson:"action" json:"action"`
+	TTL       uint   `bson:"ttl" json:"ttl"`               // seconds, in place of the CDN's CacheTTL when not 0
+	TTLPolicy string `bson:"ttl_policy" json:"ttl_policy"` // in place of the CDN's TTLPolicy when set
 }
@@ -0,0 +1,54 @@
+package domain
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Prefetch statuses of a single node
+const (
+	PrefetchStatusPending = "pending"
+	PrefetchStatusRunning = "running"
+	PrefetchStatusDone    = "done"
+)
+
+// DefaultPrefetchConcurrency is how many URLs each node fetches at once for
+// jobs that do not set it
+const DefaultPrefetchConcurrency = 4
+
+type PrefetchJob struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Domain      string             `bson:"domain" json:"domain"`
+	Paths       []string           `bson:"paths" json:"paths"`                         // request URIs, from the submitted URLs and the sitemap
+	Sitemap     string             `bson:"sitemap,omitempty" json:"sitemap,omitempty"` // URL of the sitemap crawled for Paths
+	Concurrency int                `bson:"concurrency" json:"concurrency"`             // URLs fetched at once by each node
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	Nodes       []PrefetchNode     `bson:"nodes" json:"nodes"`
+}
+
+// PrefetchNode is the progress of a prefetch job on one mid or edge.
+type PrefetchNode struct {
+	Service   string    `bson:"service" json:"service"`
+	Instance  string    `bson:"instance" json:"instance"`
+	Status    string    `bson:"status" json:"status"`
+	Total     int       `bson:"total" json:"total"`
+	Fetched   int       `bson:"fetched" json:"fetched"`
+	Failed    int       `bson:"failed" json:"failed"`
+	Errors    []string  `bson:"errors,omitempty" json:"errors,omitempty"` // the first failures
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// PrefetchResult is published by mids for themselves and the edges they relay
+// a prefetch job to.
+type PrefetchResult struct {
+	JobID     string    `json:"job_id"`
+	Service   string    `json:"service"`
+	Instance  string    `json:"instance"`
+	Status    string    `json:"status"`
+	Total     int       `json:"total"`
+	Fetched   int       `json:"fetched"`
+	Failed    int       `json:"failed"`
+	Errors    []string  `json:"errors,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
@@ -0,0 +1,54 @@
+package domain
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Purge types deciding what a purge job invalidates
+const (
+	PurgeTypeURL    = "url"    // the exact URLs in Paths
+	PurgeTypePrefix = "prefix" // every URL starting with one of Paths
+	PurgeTypeDomain = "domain" // everything cached for Domain
+	PurgeTypeTag    = "tag"    // everything cached for Domain tagged with one of Tags
+)
+
+// Purge statuses of a single node
+const (
+	PurgeStatusPending = "pending"
+	PurgeStatusDone    = "done"
+	PurgeStatusFailed  = "failed"
+)
+
+type PurgeJob struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Domain    string             `bson:"domain" json:"domain"`
+	Type      string             `bson:"type" json:"type"`
+	Paths     []string           `bson:"paths" json:"paths"`
+	Tags      []string           `bson:"tags,omitempty" json:"tags,omitempty"` // Surrogate-Key or Cache-Tag values
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	Nodes     []PurgeNode        `bson:"nodes" json:"nodes"`
+}
+
+// PurgeNode is the progress of a purge job on one mid or edge.
+type PurgeNode struct {
+	Service   string    `bson:"service" json:"service"`
+	Instance  string    `bson:"instance" json:"instance"`
+	Status    string    `bson:"status" json:"status"`
+	Purged    int       `bson:"purged" json:"purged"`
+	Error     string    `bson:"error,omitempty" json:"error,omitempty"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// PurgeResult is published by mids for themselves and the edges they relay a
+// purge job to.
+type PurgeResult struct {
+	JobID     string    `json:"job_id"`
+	Service   string    `json:"service"`
+	Instance  string    `json:"instance"`
+	Status    string    `json:"status"`
+	Purged    int       `json:"purged"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
@@ -5,12 +5,118 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/domain"
 	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/helper"
 	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/service"
 
 	"github.com/gin-gonic/gin"
 )
 
+type cdnBody struct {
+	Origin    string `json:"origin" binding:"required_without=Origins,omitempty,url"`
+	Domain    string `json:"domain" binding:"required"`
+	IsActive  bool   `json:"is_active"`
+	CacheTTL  uint   `json:"cache_ttl"`
+	TTLPolicy string `json:"ttl_policy" binding:"omitempty,oneof=respect override clamp"`
+	MinTTL    uint   `json:"min_ttl"`
+	MaxTTL    uint   `json:"max_ttl" binding:"omitempty,gtefield=MinTTL"`
+	SliceSize uint   `json:"slice_size" binding:"omitempty,min=65536"`
+
+	StaleWhileRevalidate uint `json:"stale_while_revalidate"`
+	StaleIfError         uint `json:"stale_if_error"`
+
+	QueryKeyMode   string   `json:"query_key_mode" binding:"omitempty,oneof=include ignore sorted allowlist denylist"`
+	QueryKeyParams []string `json:"query_key_params"`
+
+	StatusTTLs map[string]uint `json:"status_ttls" binding:"omitempty,dive,keys,oneof=301 302 404 410,endkeys"`
+
+	CacheRules []cacheRuleBody `json:"cache_rules" binding:"omitempty,dive"`
+
+	HideDebugHeaders bool `json:"hide_debug_headers"`
+
+	Origins       []originBody `json:"origins" binding:"omitempty,dive"`
+	OriginBalance string       `json:"origin_balance" binding:"omitempty,oneof=round_robin least_connections ip_hash"`
+}
+
+type originBody struct {
+	URL      string `json:"url" binding:"required,url"`
+	Weight   uint   `json:"weight"`
+	Priority uint   `json:"priority"`
+}
+
+type cacheRuleBody struct {
+	Name         string            `json:"name"`
+	PathGlob     string            `json:"path_glob" binding:"omitempty,startswith=/"`
+	PathRegex    string            `json:"path_regex"`
+	Extensions   []string          `json:"extensions" binding:"omitempty,dive,required"`
+	Methods      []string          `json:"methods" binding:"omitempty,dive,required"`
+	ContentTypes []string          `json:"content_types" binding:"omitempty,dive,required"`
+	Headers      map[string]string `json:"headers" binding:"omitempty,dive,keys,required,endkeys"`
+	Cookies      map[string]string `json:"cookies" binding:"omitempty,dive,keys,required,endkeys"`
+
+	Action    string `json:"action" binding:"required,oneof=cache bypass"`
+	TTL       uint   `json:"ttl"`
+	TTLPolicy string `json:"ttl_policy" binding:"omitempty,oneof=respect override clamp"`
+}
+
+func (b *cacheRuleBody) toDomain() domain.CacheRule {
+	return domain.CacheRule{
+		Name:         b.Name,
+		PathGlob:     b.PathGlob,
+		PathRegex:    b.PathRegex,
+		Extensions:   b.Extensions,
+		Methods:      b.Methods,
+		ContentTypes: b.ContentTypes,
+		Headers:      b.Headers,
+		Cookies:      b.Cookies,
+
+		Action:    b.Action,
+		TTL:       b.TTL,
+		TTLPolicy: b.TTLPolicy,
+	}
+}
+
+func (b *cdnBody) toDomain() *domain.CDN {
+	var rules []domain.CacheRule
+	for _, rule := range b.CacheRules {
+		rules = append(rules, rule.toDomain())
+	}
+
+	var origins []domain.OriginServer
+	for _, origin := range b.Origins {
+		origins = append(origins, domain.OriginServer{
+			URL:      origin.URL,
+			Weight:   origin.Weight,
+			Priority: origin.Priority,
+		})
+	}
+
+	return &domain.CDN{
+		Origin:    b.Origin,
+		Domain:    b.Domain,
+		IsActive:  b.IsActive,
+		CacheTTL:  b.CacheTTL,
+		TTLPolicy: b.TTLPolicy,
+		MinTTL:    b.MinTTL,
+		MaxTTL:    b.MaxTTL,
+		SliceSize: b.SliceSize,
+
+		StaleWhileRevalidate: b.StaleWhileRevalidate,
+		StaleIfError:         b.StaleIfError,
+
+		QueryKeyMode:   b.QueryKeyMode,
+		QueryKeyParams: b.QueryKeyParams,
+
+		StatusTTLs: b.StatusTTLs,
+		CacheRules: rules,
+
+		HideDebugHeaders: b.HideDebugHeaders,
+
+		Origins:       origins,
+		OriginBalance: b.OriginBalance,
+	}
+}
+
 type CdnHandler struct {
 	cdnService service.CdnServiceInterface
 }
@@ -28,19 +134,14 @@ func (h *CdnHandler) Register(protected *gin.RouterGroup) {
 }
 
 func (h *CdnHandler) createCDN(c *gin.Context) {
-	var body struct {
-		Origin   string `json:"origin" binding:"required,url"`
-		Domain   string `json:"domain" binding:"required"`
-		IsActive bool   `json:"is_active"`
-		CacheTTL uint   `json:"cache_ttl"`
-	}
+	var body cdnBody
 	if err := c.ShouldBindJSON(&body); err != nil {
 		sErr := helper.ErrInvalidInput()
 		c.JSON(sErr.Code, gin.H{"error": sErr.Message})
 		return
 	}
 
-	if err := h.cdnService.Create(context.Background(), body.Origin, body.Domain, body.IsActive, body.CacheTTL); err != nil {
+	if err := h.cdnService.Create(context.Background(), body.toDomain()); err != nil {
 		var sErr *helper.ServiceError
 		if errors.As(err, &sErr) {
 			c.JSON(sErr.Code, gin.H{"error": sErr.Message})
@@ -71,17 +172,17 @@ func (h *CdnHandler) getCDN(c *gin.Context) {
 
 func (h *CdnHandler) updateCDN(c *gin.Context) {
 	id := c.Param("id")
-	var body struct {
-		Origin   string `json:"origin" binding:"required,url"`
-		Domain   string `json:"domain" binding:"required"`
-		IsActive bool   `json:"is_active"`
-		CacheTTL uint   `json:"cache_ttl"`
-	}
+	var body cdnBody
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.cdnService.Update(context.Background(), id, body.Origin, body.Domain, body.IsActive); err != nil {
+	if err := h.cdnService.Update(context.Background(), id, body.toDomain()); err != nil {
+		var sErr *helper.ServiceError
+		if errors.As(err, &sErr) {
+			c.JSON(sErr.Code, gin.H{"error": sErr.Message})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -0,0 +1,38 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/helper"
+
+	"github.com/gin-gonic/gin"
+)
+
+// createJob submits job, a purge or prefetch, and answers with it once it is
+// accepted.
+func createJob[J any](c *gin.Context, job *J, create func(context.Context, *J) error) {
+	if err := create(c.Request.Context(), job); err != nil {
+		var sErr *helper.ServiceError
+		if errors.As(err, &sErr) {
+			c.JSON(sErr.Code, gin.H{"error": sErr.Message})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// getJob answers with the job named by the id path parameter, along with the
+// progress of every node.
+func getJob[J any](c *gin.Context, get func(context.Context, string) (*J, error)) {
+	job, err := get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
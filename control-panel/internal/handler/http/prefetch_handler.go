@@ -0,0 +1,50 @@
+package http
+
+import (
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/helper"
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type prefetchBody struct {
+	Domain      string   `json:"domain" binding:"required"`
+	URLs        []string `json:"urls" binding:"required_without=Sitemap,dive,required"`
+	Sitemap     string   `json:"sitemap" binding:"omitempty,url"`
+	Concurrency int      `json:"concurrency" binding:"omitempty,min=1,max=32"`
+}
+
+type PrefetchHandler struct {
+	prefetchService service.PrefetchServiceInterface
+}
+
+func NewPrefetchHandler(prefetchService service.PrefetchServiceInterface) *PrefetchHandler {
+	return &PrefetchHandler{prefetchService: prefetchService}
+}
+
+func (h *PrefetchHandler) Register(protected *gin.RouterGroup) {
+	protected.POST("/prefetches", h.createPrefetch)
+	protected.GET("/prefetches/:id", h.getPrefetch)
+}
+
+func (h *PrefetchHandler) createPrefetch(c *gin.Context) {
+	var body prefetchBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		sErr := helper.ErrInvalidInput()
+		c.JSON(sErr.Code, gin.H{"error": sErr.Message})
+		return
+	}
+
+	job := &domain.PrefetchJob{
+		Domain:      body.Domain,
+		Paths:       body.URLs,
+		Sitemap:     body.Sitemap,
+		Concurrency: body.Concurrency,
+	}
+	createJob(c, job, h.prefetchService.Create)
+}
+
+func (h *PrefetchHandler) getPrefetch(c *gin.Context) {
+	getJob(c, h.prefetchService.Get)
+}
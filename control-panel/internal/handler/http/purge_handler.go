@@ -0,0 +1,50 @@
+package http
+
+import (
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/helper"
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type purgeBody struct {
+	Domain string   `json:"domain" binding:"required"`
+	Type   string   `json:"type" binding:"required,oneof=url prefix domain tag"`
+	Paths  []string `json:"paths" binding:"dive,startswith=/"`
+	Tags   []string `json:"tags" binding:"dive,required"`
+}
+
+type PurgeHandler struct {
+	purgeService service.PurgeServiceInterface
+}
+
+func NewPurgeHandler(purgeService service.PurgeServiceInterface) *PurgeHandler {
+	return &PurgeHandler{purgeService: purgeService}
+}
+
+func (h *PurgeHandler) Register(protected *gin.RouterGroup) {
+	protected.POST("/purges", h.createPurge)
+	protected.GET("/purges/:id", h.getPurge)
+}
+
+func (h *PurgeHandler) createPurge(c *gin.Context) {
+	var body purgeBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		sErr := helper.ErrInvalidInput()
+		c.JSON(sErr.Code, gin.H{"error": sErr.Message})
+		return
+	}
+
+	job := &domain.PurgeJob{
+		Domain: body.Domain,
+		Type:   body.Type,
+		Paths:  body.Paths,
+		Tags:   body.Tags,
+	}
+	createJob(c, job, h.purgeService.Create)
+}
+
+func (h *PurgeHandler) getPurge(c *gin.Context) {
+	getJob(c, h.purgeService.Get)
+}
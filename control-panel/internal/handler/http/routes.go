@@ -13,6 +13,8 @@ func RegisterRoutes(
 	g *gin.Engine,
 	cdnSvc service.CdnServiceInterface,
 	userSvc service.UserServiceInterface,
+	purgeSvc service.PurgeServiceInterface,
+	prefetchSvc service.PrefetchServiceInterface,
 	natsPub messaging.MessageBrokerInterface,
 	jwtManager *jwt.Manager,
 ) {
@@ -27,4 +29,6 @@ func RegisterRoutes(
 	NewUserHandler(userSvc).Register(g, protected)
 	NewCdnHandler(cdnSvc).Register(protected)
 	NewSnapshotHandler(natsPub).Register(protected)
+	NewPurgeHandler(purgeSvc).Register(protected)
+	NewPrefetchHandler(prefetchSvc).Register(protected)
 }
@@ -38,3 +38,38 @@ func ErrCdnExists() *ServiceError {
 		Message: "cdn already exists",
 	}
 }
+
+func ErrCdnNotFound() *ServiceError {
+	return &ServiceError{
+		Code:    http.StatusNotFound,
+		Message: "cdn not found",
+	}
+}
+
+func ErrInvalidCacheRule() *ServiceError {
+	return &ServiceError{
+		Code:    http.StatusBadRequest,
+		Message: "invalid cache rule path_regex",
+	}
+}
+
+func ErrDuplicateOrigin() *ServiceError {
+	return &ServiceError{
+		Code:    http.StatusBadRequest,
+		Message: "origin listed twice in the origin pool",
+	}
+}
+
+func ErrInvalidSitemap() *ServiceError {
+	return &ServiceError{
+		Code:    http.StatusBadRequest,
+		Message: "sitemap could not be fetched",
+	}
+}
+
+func ErrTooManyPrefetchURLs() *ServiceError {
+	return &ServiceError{
+		Code:    http.StatusBadRequest,
+		Message: "too many prefetch urls",
+	}
+}
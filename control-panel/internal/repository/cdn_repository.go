@@ -17,7 +17,7 @@ type CdnRepositoryInterface interface {
 	GetCDN(ctx context.Context, id string) (*domain.CDN, error)
 	UpdateCDN(ctx context.Context, id string, c *domain.CDN) error
 	DeleteCDN(ctx context.Context, id string) error
-	GetCDNByOrigin(ctx context.Context, origin string) (*domain.CDN, error)
+	GetCDNByDomain(ctx context.Context, domainName string) (*domain.CDN, error)
 }
 
 type CdnRepository struct {
@@ -75,10 +75,28 @@ func (m *CdnRepository) UpdateCDN(ctx context.Context, id string, c *domain.CDN)
 		ctx,
 		bson.M{"_id": oid},
 		bson.M{"$set": bson.M{
-			"origin":    c.Origin,
-			"domain":    c.Domain,
-			"is_active": c.IsActive,
-			"cache_ttl": c.CacheTTL,
+			"origin":     c.Origin,
+			"domain":     c.Domain,
+			"is_active":  c.IsActive,
+			"cache_ttl":  c.CacheTTL,
+			"ttl_policy": c.TTLPolicy,
+			"min_ttl":    c.MinTTL,
+			"max_ttl":    c.MaxTTL,
+			"slice_size": c.SliceSize,
+
+			"stale_while_revalidate": c.StaleWhileRevalidate,
+			"stale_if_error":         c.StaleIfError,
+
+			"query_key_mode":   c.QueryKeyMode,
+			"query_key_params": c.QueryKeyParams,
+
+			"status_ttls": c.StatusTTLs,
+			"cache_rules": c.CacheRules,
+
+			"hide_debug_headers": c.HideDebugHeaders,
+
+			"origins":        c.Origins,
+			"origin_balance": c.OriginBalance,
 		}},
 	)
 	return err
@@ -99,9 +117,9 @@ func (m *CdnRepository) DeleteCDN(ctx context.Context, id string) error {
 	return nil
 }
 
-func (m *CdnRepository) GetCDNByOrigin(ctx context.Context, origin string) (*domain.CDN, error) {
+func (m *CdnRepository) GetCDNByDomain(ctx context.Context, domainName string) (*domain.CDN, error) {
 	var cdn domain.CDN
-	err := m.db.Collection("cdns").FindOne(ctx, bson.M{"origin": origin}).Decode(&cdn)
+	err := m.db.Collection("cdns").FindOne(ctx, bson.M{"domain": domainName}).Decode(&cdn)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// jobCollection stores jobs of one kind, J, such as purges or prefetches,
+// along with the progress, N, every mid and edge reports for them.
+type jobCollection[J, N any] struct {
+	collection *mongo.Collection
+}
+
+func newJobCollection[J, N any](client *mongo.Client, dbName, name string) jobCollection[J, N] {
+	return jobCollection[J, N]{collection: client.Database(dbName).Collection(name)}
+}
+
+// create stores job and returns its ID.
+func (c jobCollection[J, N]) create(ctx context.Context, job *J) (primitive.ObjectID, error) {
+	res, err := c.collection.InsertOne(ctx, job)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	oid, _ := res.InsertedID.(primitive.ObjectID)
+	return oid, nil
+}
+
+func (c jobCollection[J, N]) get(ctx context.Context, id string) (*J, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var job J
+	err = c.collection.FindOne(ctx, bson.M{"_id": oid}).Decode(&job)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// setNode replaces the progress of the node service/instance in the job,
+// adding the node on its first report.
+func (c jobCollection[J, N]) setNode(ctx context.Context, id, service, instance string, node N) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": oid, "nodes": bson.M{"$elemMatch": bson.M{"service": service, "instance": instance}}},
+		bson.M{"$set": bson.M{"nodes.$": node}},
+	)
+	if err != nil || res.MatchedCount > 0 {
+		return err
+	}
+
+	_, err = c.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": oid},
+		bson.M{"$push": bson.M{"nodes": node}},
+	)
+	return err
+}
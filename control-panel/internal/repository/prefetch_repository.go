@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/domain"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type PrefetchRepositoryInterface interface {
+	CreatePrefetch(ctx context.Context, job *domain.PrefetchJob) error
+	GetPrefetch(ctx context.Context, id string) (*domain.PrefetchJob, error)
+	SetPrefetchNode(ctx context.Context, id string, node domain.PrefetchNode) error
+}
+
+type PrefetchRepository struct {
+	jobs jobCollection[domain.PrefetchJob, domain.PrefetchNode]
+}
+
+func NewPrefetchRepository(client *mongo.Client, dbName string) PrefetchRepositoryInterface {
+	return &PrefetchRepository{
+		jobs: newJobCollection[domain.PrefetchJob, domain.PrefetchNode](client, dbName, "prefetches"),
+	}
+}
+
+func (m *PrefetchRepository) CreatePrefetch(ctx context.Context, job *domain.PrefetchJob) error {
+	id, err := m.jobs.create(ctx, job)
+	if err != nil {
+		return err
+	}
+	job.ID = id
+	return nil
+}
+
+func (m *PrefetchRepository) GetPrefetch(ctx context.Context, id string) (*domain.PrefetchJob, error) {
+	return m.jobs.get(ctx, id)
+}
+
+// SetPrefetchNode replaces the progress of node in the job, adding the node on
+// its first report.
+func (m *PrefetchRepository) SetPrefetchNode(ctx context.Context, id string, node domain.PrefetchNode) error {
+	return m.jobs.setNode(ctx, id, node.Service, node.Instance, node)
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/domain"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type PurgeRepositoryInterface interface {
+	CreatePurge(ctx context.Context, job *domain.PurgeJob) error
+	GetPurge(ctx context.Context, id string) (*domain.PurgeJob, error)
+	SetPurgeNode(ctx context.Context, id string, node domain.PurgeNode) error
+}
+
+type PurgeRepository struct {
+	jobs jobCollection[domain.PurgeJob, domain.PurgeNode]
+}
+
+func NewPurgeRepository(client *mongo.Client, dbName string) PurgeRepositoryInterface {
+	return &PurgeRepository{
+		jobs: newJobCollection[domain.PurgeJob, domain.PurgeNode](client, dbName, "purges"),
+	}
+}
+
+func (m *PurgeRepository) CreatePurge(ctx context.Context, job *domain.PurgeJob) error {
+	id, err := m.jobs.create(ctx, job)
+	if err != nil {
+		return err
+	}
+	job.ID = id
+	return nil
+}
+
+func (m *PurgeRepository) GetPurge(ctx context.Context, id string) (*domain.PurgeJob, error) {
+	return m.jobs.get(ctx, id)
+}
+
+// SetPurgeNode replaces the progress of node in the job, adding the node on
+// its first report.
+func (m *PurgeRepository) SetPurgeNode(ctx context.Context, id string, node domain.PurgeNode) error {
+	return m.jobs.setNode(ctx, id, node.Service, node.Instance, node)
+}
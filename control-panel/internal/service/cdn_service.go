@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"regexp"
+	"strings"
 
 	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/domain"
 	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/helper"
@@ -9,10 +11,10 @@ import (
 )
 
 type CdnServiceInterface interface {
-	Create(ctx context.Context, origin, domain string, isActive bool, CacheTTL uint) error
+	Create(ctx context.Context, cdn *domain.CDN) error
 	List(ctx context.Context) ([]*domain.CDN, error)
 	Get(ctx context.Context, id string) (*domain.CDN, error)
-	Update(ctx context.Context, id, origin, domain string, isActive bool) error
+	Update(ctx context.Context, id string, cdn *domain.CDN) error
 	Delete(ctx context.Context, id string) error
 }
 
@@ -27,12 +29,17 @@ func NewCdnService(r repository.CdnRepositoryInterface) *CdnService {
 	}
 }
 
-func (c *CdnService) Create(ctx context.Context, origin string, domainName string, isActive bool, CacheTTL uint) error {
-	_, err := c.repo.GetCDNByOrigin(ctx, origin)
+func (c *CdnService) Create(ctx context.Context, cdn *domain.CDN) error {
+	if err := validateCacheRules(cdn.CacheRules); err != nil {
+		return err
+	}
+	if err := normalizeOrigins(cdn); err != nil {
+		return err
+	}
+	_, err := c.repo.GetCDNByDomain(ctx, cdn.Domain)
 	if err == nil {
 		return helper.ErrCdnExists()
 	}
-	cdn := &domain.CDN{Origin: origin, Domain: domainName, IsActive: isActive, CacheTTL: CacheTTL}
 	return c.repo.CreateCDN(ctx, cdn)
 }
 
@@ -44,11 +51,59 @@ func (c *CdnService) Get(ctx context.Context, id string) (*domain.CDN, error) {
 	return c.repo.GetCDN(ctx, id)
 }
 
-func (c *CdnService) Update(ctx context.Context, id, origin, domainName string, isActive bool) error {
-	cdn := &domain.CDN{Origin: origin, Domain: domainName, IsActive: isActive}
+func (c *CdnService) Update(ctx context.Context, id string, cdn *domain.CDN) error {
+	if err := validateCacheRules(cdn.CacheRules); err != nil {
+		return err
+	}
+	if err := normalizeOrigins(cdn); err != nil {
+		return err
+	}
 	return c.repo.UpdateCDN(ctx, id, cdn)
 }
 
 func (c *CdnService) Delete(ctx context.Context, id string) error {
 	return c.repo.DeleteCDN(ctx, id)
 }
+
+// validateCacheRules rejects rules whose path regex would never match on the
+// mids and edges since it does not compile.
+func validateCacheRules(rules []domain.CacheRule) error {
+	for _, rule := range rules {
+		if _, err := regexp.Compile(rule.PathRegex); err != nil {
+			return helper.ErrInvalidCacheRule()
+		}
+	}
+	return nil
+}
+
+// normalizeOrigins turns a single origin into a pool of one, fills in the
+// pool defaults and sets Origin to the primary origin of the pool, which the
+// edges fall back to when no mid is available.
+func normalizeOrigins(cdn *domain.CDN) error {
+	if len(cdn.Origins) == 0 {
+		cdn.Origins = []domain.OriginServer{{URL: cdn.Origin}}
+	}
+
+	seen := make(map[string]bool, len(cdn.Origins))
+	primary := 0
+	for i := range cdn.Origins {
+		origin := &cdn.Origins[i]
+		origin.URL = strings.TrimSuffix(origin.URL, "/")
+		if seen[origin.URL] {
+			return helper.ErrDuplicateOrigin()
+		}
+		seen[origin.URL] = true
+		if origin.Weight == 0 {
+			origin.Weight = 1
+		}
+		if origin.Priority < cdn.Origins[primary].Priority {
+			primary = i
+		}
+	}
+	cdn.Origin = cdn.Origins[primary].URL
+
+	if cdn.OriginBalance == "" {
+		cdn.OriginBalance = domain.OriginBalanceRoundRobin
+	}
+	return nil
+}
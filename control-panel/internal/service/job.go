@@ -0,0 +1,17 @@
+package service
+
+import (
+	"encoding/json"
+
+	"github.com/AmirAghaee/go-cdn-stack/pkg/messaging"
+)
+
+// publishJob hands a stored purge or prefetch job to the mids, which run it
+// and relay it to their edges.
+func publishJob(broker messaging.MessageBrokerInterface, subject string, job any) error {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return broker.Publish(subject, string(payload))
+}
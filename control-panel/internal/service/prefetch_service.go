@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/helper"
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/repository"
+	"github.com/AmirAghaee/go-cdn-stack/pkg/messaging"
+)
+
+// maxPrefetchPaths bounds the URLs of a single prefetch job, sitemap
+// included
+const maxPrefetchPaths = 10000
+
+type PrefetchServiceInterface interface {
+	Create(ctx context.Context, job *domain.PrefetchJob) error
+	Get(ctx context.Context, id string) (*domain.PrefetchJob, error)
+	RecordResult(ctx context.Context, result domain.PrefetchResult) error
+}
+
+type PrefetchService struct {
+	repo    repository.PrefetchRepositoryInterface
+	cdnRepo repository.CdnRepositoryInterface
+	broker  messaging.MessageBrokerInterface
+}
+
+// NewPrefetchService returns a new PrefetchService
+func NewPrefetchService(r repository.PrefetchRepositoryInterface, cdnRepo repository.CdnRepositoryInterface, broker messaging.MessageBrokerInterface) *PrefetchService {
+	return &PrefetchService{
+		repo:    r,
+		cdnRepo: cdnRepo,
+		broker:  broker,
+	}
+}
+
+// Create resolves the URLs of the prefetch job, crawling its sitemap, stores
+// the job and publishes it to the mids. They warm their own cache first and
+// then relay the job to their edges, which fill from the warmed mids.
+func (p *PrefetchService) Create(ctx context.Context, job *domain.PrefetchJob) error {
+	if _, err := p.cdnRepo.GetCDNByDomain(ctx, job.Domain); err != nil {
+		return helper.ErrCdnNotFound()
+	}
+
+	urls := job.Paths
+	if job.Sitemap != "" {
+		locs, err := crawlSitemap(ctx, job.Sitemap, maxPrefetchPaths)
+		if err != nil {
+			return helper.ErrInvalidSitemap()
+		}
+		urls = append(urls, locs...)
+	}
+
+	paths, err := requestURIs(urls)
+	if err != nil || len(paths) == 0 {
+		return helper.ErrInvalidInput()
+	}
+	if len(paths) > maxPrefetchPaths {
+		return helper.ErrTooManyPrefetchURLs()
+	}
+
+	job.Paths = paths
+	if job.Concurrency == 0 {
+		job.Concurrency = domain.DefaultPrefetchConcurrency
+	}
+	job.CreatedAt = time.Now().UTC()
+	job.Nodes = []domain.PrefetchNode{}
+	if err := p.repo.CreatePrefetch(ctx, job); err != nil {
+		return err
+	}
+
+	return publishJob(p.broker, "cdn.prefetch", job)
+}
+
+func (p *PrefetchService) Get(ctx context.Context, id string) (*domain.PrefetchJob, error) {
+	return p.repo.GetPrefetch(ctx, id)
+}
+
+func (p *PrefetchService) RecordResult(ctx context.Context, result domain.PrefetchResult) error {
+	return p.repo.SetPrefetchNode(ctx, result.JobID, domain.PrefetchNode{
+		Service:   result.Service,
+		Instance:  result.Instance,
+		Status:    result.Status,
+		Total:     result.Total,
+		Fetched:   result.Fetched,
+		Failed:    result.Failed,
+		Errors:    result.Errors,
+		UpdatedAt: result.Timestamp,
+	})
+}
+
+// requestURIs returns the path and query of every URL, which may be absolute
+// or a path already, once each and in order. Nodes request them under the
+// CDN's domain whatever host the URLs name.
+func requestURIs(urls []string) ([]string, error) {
+	seen := make(map[string]bool, len(urls))
+	paths := make([]string, 0, len(urls))
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		if u.Host == "" && !strings.HasPrefix(u.Path, "/") {
+			return nil, fmt.Errorf("invalid prefetch url %q", raw)
+		}
+
+		path := u.RequestURI()
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
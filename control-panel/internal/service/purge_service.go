@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/helper"
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/repository"
+	"github.com/AmirAghaee/go-cdn-stack/pkg/messaging"
+)
+
+type PurgeServiceInterface interface {
+	Create(ctx context.Context, job *domain.PurgeJob) error
+	Get(ctx context.Context, id string) (*domain.PurgeJob, error)
+	RecordResult(ctx context.Context, result domain.PurgeResult) error
+}
+
+type PurgeService struct {
+	repo    repository.PurgeRepositoryInterface
+	cdnRepo repository.CdnRepositoryInterface
+	broker  messaging.MessageBrokerInterface
+}
+
+// NewPurgeService returns a new PurgeService
+func NewPurgeService(r repository.PurgeRepositoryInterface, cdnRepo repository.CdnRepositoryInterface, broker messaging.MessageBrokerInterface) *PurgeService {
+	return &PurgeService{
+		repo:    r,
+		cdnRepo: cdnRepo,
+		broker:  broker,
+	}
+}
+
+// Create stores the purge job and publishes it to the mids, which purge
+// their own cache and relay the job to their edges.
+func (p *PurgeService) Create(ctx context.Context, job *domain.PurgeJob) error {
+	switch job.Type {
+	case domain.PurgeTypeURL, domain.PurgeTypePrefix:
+		if len(job.Paths) == 0 {
+			return helper.ErrInvalidInput()
+		}
+	case domain.PurgeTypeTag:
+		if len(job.Tags) == 0 {
+			return helper.ErrInvalidInput()
+		}
+	}
+	if _, err := p.cdnRepo.GetCDNByDomain(ctx, job.Domain); err != nil {
+		return helper.ErrCdnNotFound()
+	}
+
+	job.CreatedAt = time.Now().UTC()
+	job.Nodes = []domain.PurgeNode{}
+	if err := p.repo.CreatePurge(ctx, job); err != nil {
+		return err
+	}
+
+	return publishJob(p.broker, "cdn.purge", job)
+}
+
+func (p *PurgeService) Get(ctx context.Context, id string) (*domain.PurgeJob, error) {
+	return p.repo.GetPurge(ctx, id)
+}
+
+func (p *PurgeService) RecordResult(ctx context.Context, result domain.PurgeResult) error {
+	return p.repo.SetPurgeNode(ctx, result.JobID, domain.PurgeNode{
+		Service:   result.Service,
+		Instance:  result.Instance,
+		Status:    result.Status,
+		Purged:    result.Purged,
+		Error:     result.Error,
+		UpdatedAt: result.Timestamp,
+	})
+}
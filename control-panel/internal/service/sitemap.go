@@ -0,0 +1,100 @@
+package service
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	maxSitemapDepth = 2                // levels of sitemap indexes followed
+	maxSitemapSize  = 50 * 1024 * 1024 // bytes, uncompressed, per the sitemap protocol
+)
+
+var sitemapClient = &http.Client{Timeout: 30 * time.Second}
+
+// sitemap is either a urlset listing pages or a sitemapindex listing further
+// sitemaps.
+type sitemap struct {
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// crawlSitemap returns the page URLs the sitemap at sitemapURL lists, following
+// sitemap indexes, and stops once more than limit are found.
+func crawlSitemap(ctx context.Context, sitemapURL string, limit int) ([]string, error) {
+	var urls []string
+	err := crawlSitemapLevel(ctx, sitemapURL, limit, 0, &urls)
+	return urls, err
+}
+
+func crawlSitemapLevel(ctx context.Context, sitemapURL string, limit int, depth int, urls *[]string) error {
+	sm, err := fetchSitemap(ctx, sitemapURL)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range sm.URLs {
+		if len(*urls) > limit {
+			return nil
+		}
+		*urls = append(*urls, u.Loc)
+	}
+
+	if depth >= maxSitemapDepth {
+		return nil
+	}
+	for _, child := range sm.Sitemaps {
+		if len(*urls) > limit {
+			return nil
+		}
+		if err := crawlSitemapLevel(ctx, child.Loc, limit, depth+1, urls); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchSitemap downloads and parses a sitemap, plain or gzipped.
+func fetchSitemap(ctx context.Context, sitemapURL string) (*sitemap, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sitemapURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := sitemapClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("sitemap %s returned status %d", sitemapURL, resp.StatusCode)
+	}
+
+	br := bufio.NewReader(resp.Body)
+	var body io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		body = zr
+	}
+
+	var sm sitemap
+	if err := xml.NewDecoder(io.LimitReader(body, maxSitemapSize)).Decode(&sm); err != nil {
+		return nil, fmt.Errorf("failed to parse sitemap %s: %w", sitemapURL, err)
+	}
+	return &sm, nil
+}
@@ -0,0 +1,31 @@
+package subscriber
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/pkg/messaging"
+)
+
+// subscribeResults calls record with every per-node job result of type R
+// published to subject, logging what describe says of the recorded ones.
+func subscribeResults[R any](broker messaging.MessageBrokerInterface, subject string, record func(context.Context, R) error, describe func(R) string) error {
+	return broker.Subscribe(subject, func(msg string) {
+		var result R
+		if err := json.Unmarshal([]byte(msg), &result); err != nil {
+			log.Printf("failed to unmarshal %s: %v", subject, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := record(ctx, result); err != nil {
+			log.Printf("failed to record %s: %v", subject, err)
+		} else {
+			log.Print(describe(result))
+		}
+	})
+}
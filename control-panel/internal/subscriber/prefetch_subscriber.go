@@ -0,0 +1,32 @@
+package subscriber
+
+import (
+	"fmt"
+
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/service"
+	"github.com/AmirAghaee/go-cdn-stack/pkg/messaging"
+)
+
+type PrefetchSubscriberInterface interface {
+	Register() error
+}
+
+type prefetchSubscriber struct {
+	broker  messaging.MessageBrokerInterface
+	service service.PrefetchServiceInterface
+}
+
+func NewPrefetchSubscriber(broker messaging.MessageBrokerInterface, service service.PrefetchServiceInterface) PrefetchSubscriberInterface {
+	return &prefetchSubscriber{
+		broker:  broker,
+		service: service,
+	}
+}
+
+// Register records the per-node prefetch results reported by mids.
+func (s *prefetchSubscriber) Register() error {
+	return subscribeResults(s.broker, "cdn.prefetch.result", s.service.RecordResult, func(result domain.PrefetchResult) string {
+		return fmt.Sprintf("prefetch %s: %s [%s] -> %s (%d/%d fetched, %d failed)", result.JobID, result.Service, result.Instance, result.Status, result.Fetched, result.Total, result.Failed)
+	})
+}
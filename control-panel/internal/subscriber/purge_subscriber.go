@@ -0,0 +1,32 @@
+package subscriber
+
+import (
+	"fmt"
+
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/control-panel/internal/service"
+	"github.com/AmirAghaee/go-cdn-stack/pkg/messaging"
+)
+
+type PurgeSubscriberInterface interface {
+	Register() error
+}
+
+type purgeSubscriber struct {
+	broker  messaging.MessageBrokerInterface
+	service service.PurgeServiceInterface
+}
+
+func NewPurgeSubscriber(broker messaging.MessageBrokerInterface, service service.PurgeServiceInterface) PurgeSubscriberInterface {
+	return &purgeSubscriber{
+		broker:  broker,
+		service: service,
+	}
+}
+
+// Register records the per-node purge results reported by mids.
+func (s *purgeSubscriber) Register() error {
+	return subscribeResults(s.broker, "cdn.purge.result", s.service.RecordResult, func(result domain.PurgeResult) string {
+		return fmt.Sprintf("purge %s: %s [%s] -> %s", result.JobID, result.Service, result.Instance, result.Status)
+	})
+}
@@ -47,10 +47,14 @@ func main() {
 	userRepo := repository.NewUserRepository(client, cfg.DB)
 	cdnRepo := repository.NewCdnRepository(client, cfg.DB)
 	healthRepo := repository.NewHealthRepository(client, cfg.DB)
+	purgeRepo := repository.NewPurgeRepository(client, cfg.DB)
+	prefetchRepo := repository.NewPrefetchRepository(client, cfg.DB)
 
 	// services
 	userService := service.NewUserService(userRepo, jwtManager)
 	cdnService := service.NewCdnService(cdnRepo)
+	purgeService := service.NewPurgeService(purgeRepo, cdnRepo, natsBroker)
+	prefetchService := service.NewPrefetchService(prefetchRepo, cdnRepo, natsBroker)
 
 	// subscribe to health events
 	healthSub := subscriber.NewHealthSubscriber(natsBroker, healthRepo)
@@ -58,9 +62,21 @@ func main() {
 		log.Fatalf("failed to register health subscriber: %v", err)
 	}
 
+	// subscribe to purge results
+	purgeSub := subscriber.NewPurgeSubscriber(natsBroker, purgeService)
+	if err := purgeSub.Register(); err != nil {
+		log.Fatalf("failed to register purge subscriber: %v", err)
+	}
+
+	// subscribe to prefetch progress
+	prefetchSub := subscriber.NewPrefetchSubscriber(natsBroker, prefetchService)
+	if err := prefetchSub.Register(); err != nil {
+		log.Fatalf("failed to register prefetch subscriber: %v", err)
+	}
+
 	// http handler
 	r := gin.Default()
-	http.RegisterRoutes(r, cdnService, userService, natsBroker, jwtManager)
+	http.RegisterRoutes(r, cdnService, userService, purgeService, prefetchService, natsBroker, jwtManager)
 
 	fmt.Printf("Server running on %s\n", cfg.AppURL)
 	_ = r.Run(cfg.AppURL)
@@ -5,66 +5,101 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
 )
 
 type MidClientInterface interface {
-	Submit(edge domain.Edge) (string, error)
+	Submit(edge domain.Edge) (*domain.HeartbeatResponse, error)
 	GetCdns() ([]domain.CDN, error)
+	ReportPurge(result domain.PurgeResult) error
+	ReportPrefetch(result domain.PrefetchResult) error
 }
 
 type midClient struct {
-	baseURL    string
+	baseURLs   []string
+	current    atomic.Int64 // index of the mid that last answered
 	httpClient *http.Client
 }
 
-func NewMidClient(baseURL string) MidClientInterface {
+func NewMidClient(baseURLs []string) MidClientInterface {
 	return &midClient{
-		baseURL: baseURL,
+		baseURLs: baseURLs,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
 	}
 }
 
-func (c *midClient) Submit(edge domain.Edge) (string, error) {
-	url := fmt.Sprintf("http://%s/edge/submit", c.baseURL)
+// do sends the request built by newRequest to the mids in turn, starting with
+// the one that last answered, until one of them answers.
+func (c *midClient) do(newRequest func(baseURL string) (*http.Request, error)) (*http.Response, error) {
+	if len(c.baseURLs) == 0 {
+		return nil, fmt.Errorf("no mid configured")
+	}
+
+	start := int(c.current.Load())
+	var err error
+	for i := range c.baseURLs {
+		index := (start + i) % len(c.baseURLs)
+		var req *http.Request
+		req, err = newRequest(c.baseURLs[index])
+		if err != nil {
+			return nil, err
+		}
+		var resp *http.Response
+		resp, err = c.httpClient.Do(req)
+		if err == nil {
+			c.current.Store(int64(index))
+			return resp, nil
+		}
+	}
+	return nil, err
+}
 
+// post sends body as JSON to path on the mids.
+func (c *midClient) post(path string, body []byte) (*http.Response, error) {
+	return c.do(func(baseURL string) (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s%s", baseURL, path), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+}
+
+func (c *midClient) Submit(edge domain.Edge) (*domain.HeartbeatResponse, error) {
 	body, err := json.Marshal(edge)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal edge: %w", err)
+		return nil, fmt.Errorf("failed to marshal edge: %w", err)
 	}
 
-	resp, err := c.httpClient.Post(url, "application/json", bytes.NewReader(body))
+	resp, err := c.post("/edge/submit", body)
 	if err != nil {
-		return "", fmt.Errorf("failed to make request to mid: %w", err)
+		return nil, fmt.Errorf("failed to make request to mid: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("mid service returned status %d", resp.StatusCode)
+		return nil, fmt.Errorf("mid service returned status %d", resp.StatusCode)
 	}
 
 	// Parse JSON response
-	var response struct {
-		Status         string `json:"status"`
-		Instance       string `json:"instance"`
-		CdnListVersion string `json:"cdn_list_version"`
-	}
-
+	var response domain.HeartbeatResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", fmt.Errorf("failed to decode mid response: %w", err)
+		return nil, fmt.Errorf("failed to decode mid response: %w", err)
 	}
 
-	return response.CdnListVersion, nil
+	return &response, nil
 }
 
 func (c *midClient) GetCdns() ([]domain.CDN, error) {
-	url := fmt.Sprintf("http://%s/edge/cdns", c.baseURL)
-
-	resp, err := c.httpClient.Get(url)
+	resp, err := c.do(func(baseURL string) (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/edge/cdns", baseURL), nil)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch cdns from mid: %w", err)
 	}
@@ -81,3 +116,39 @@ func (c *midClient) GetCdns() ([]domain.CDN, error) {
 
 	return cdns, nil
 }
+
+func (c *midClient) ReportPurge(result domain.PurgeResult) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal purge result: %w", err)
+	}
+
+	resp, err := c.post("/edge/purge", body)
+	if err != nil {
+		return fmt.Errorf("failed to report purge to mid: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("mid service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *midClient) ReportPrefetch(result domain.PrefetchResult) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal prefetch result: %w", err)
+	}
+
+	resp, err := c.post("/edge/prefetch", body)
+	if err != nil {
+		return fmt.Errorf("failed to report prefetch to mid: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("mid service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -9,21 +9,55 @@ import (
 )
 
 type Config struct {
-	AppName         string            `mapstructure:"APP_NAME"`
-	GinMode         string            `mapstructure:"APP_MODE"`
-	CacheTTL        int               `mapstructure:"CACHE_TTL"` // seconds
-	CacheDir        string            `mapstructure:"CACHE_DIR"`
-	MetadataExt     string            `mapstructure:"METADATA_EXT"`
-	CleanerInterval int               `mapstructure:"CACHE_CLEANER_TTL"` // seconds
-	AppCacheURL     string            `mapstructure:"APP_CACHE_URL"`
-	AppInternalURL  string            `mapstructure:"APP_INTERNAL_URL"`
-	MidCacheURL     string            `mapstructure:"MID_CACHE_URL"`
-	MidInternalURL  string            `mapstructure:"MID_INTERNAL_URL"`
-	Origins         map[string]string `mapstructure:"ORIGINS"`
+	AppName                string            `mapstructure:"APP_NAME"`
+	GinMode                string            `mapstructure:"APP_MODE"`
+	CacheTTL               int               `mapstructure:"CACHE_TTL"` // seconds
+	CacheDir               string            `mapstructure:"CACHE_DIR"`
+	StorageBackend         string            `mapstructure:"STORAGE_BACKEND"` // fs, memory or s3
+	S3Endpoint             string            `mapstructure:"S3_ENDPOINT"`
+	S3Region               string            `mapstructure:"S3_REGION"`
+	S3Bucket               string            `mapstructure:"S3_BUCKET"`
+	S3Prefix               string            `mapstructure:"S3_PREFIX"` // prepended to object keys
+	S3AccessKey            string            `mapstructure:"S3_ACCESS_KEY"`
+	S3SecretKey            string            `mapstructure:"S3_SECRET_KEY"`
+	S3ConnectTimeout       int               `mapstructure:"S3_CONNECT_TIMEOUT"` // seconds
+	S3Timeout              int               `mapstructure:"S3_TIMEOUT"`         // seconds a request, body included, may take
+	MetadataExt            string            `mapstructure:"METADATA_EXT"`
+	CleanerInterval        int               `mapstructure:"CACHE_CLEANER_TTL"`            // seconds
+	CacheLockTimeout       int               `mapstructure:"CACHE_LOCK_TIMEOUT"`           // seconds
+	CacheRetention         int               `mapstructure:"CACHE_RETENTION"`              // seconds expired items are kept for revalidation
+	CacheMaxSize           int64             `mapstructure:"CACHE_MAX_SIZE"`               // bytes on disk, 0 means unlimited
+	CacheHighWatermark     int               `mapstructure:"CACHE_HIGH_WATERMARK"`         // percent of CACHE_MAX_SIZE that starts eviction
+	CacheLowWatermark      int               `mapstructure:"CACHE_LOW_WATERMARK"`          // percent of CACHE_MAX_SIZE eviction frees down to
+	CacheEvictionPolicy    string            `mapstructure:"CACHE_EVICTION_POLICY"`        // lru or lfu
+	MemoryCacheMaxSize     int64             `mapstructure:"MEMORY_CACHE_MAX_SIZE"`        // bytes of bodies held in memory, 0 disables the memory tier
+	MemoryCacheMaxObject   int64             `mapstructure:"MEMORY_CACHE_MAX_OBJECT_SIZE"` // bytes, larger bodies are only served from disk
+	MemoryCacheMinHits     int64             `mapstructure:"MEMORY_CACHE_MIN_HITS"`        // hits an item needs before its body is promoted to memory
+	CompressionEnabled     bool              `mapstructure:"COMPRESSION_ENABLED"`
+	CompressionMinSize     int64             `mapstructure:"COMPRESSION_MIN_SIZE"`     // bytes, smaller bodies are not compressed
+	PrefetchMaxConcurrency int               `mapstructure:"PREFETCH_MAX_CONCURRENCY"` // URLs fetched at once per prefetch job, whatever the job asks for
+	DebugHeaderSecret      string            `mapstructure:"DEBUG_HEADER_SECRET"`      // key of signed X-CDN-Debug headers, none are valid when empty
+	AppCacheURL            string            `mapstructure:"APP_CACHE_URL"`
+	AppInternalURL         string            `mapstructure:"APP_INTERNAL_URL"`
+	MidCacheURL            string            `mapstructure:"MID_CACHE_URL"`                   // comma-separated, cache keys are spread over them
+	MidInternalURL         string            `mapstructure:"MID_INTERNAL_URL"`                // comma-separated, heartbeats go to the first one answering
+	MidFailureCooldown     int               `mapstructure:"MID_FAILURE_COOLDOWN"`            // seconds a mid that failed is skipped for
+	OriginFallback         bool              `mapstructure:"ORIGIN_FALLBACK"`                 // fill from the CDN origin while no mid is available
+	OriginFallbackMaxConns int               `mapstructure:"ORIGIN_FALLBACK_MAX_CONCURRENCY"` // origin requests in flight at once in degraded mode, 0 means unlimited
+	Origins                map[string]string `mapstructure:"ORIGINS"`
 
 	// Derived values
-	CacheTTLDuration        time.Duration `mapstructure:"-"`
-	CleanerIntervalDuration time.Duration `mapstructure:"-"`
+	CacheTTLDuration           time.Duration `mapstructure:"-"`
+	CleanerIntervalDuration    time.Duration `mapstructure:"-"`
+	CacheLockTimeoutDuration   time.Duration `mapstructure:"-"`
+	CacheRetentionDuration     time.Duration `mapstructure:"-"`
+	CacheHighWatermarkBytes    int64         `mapstructure:"-"`
+	CacheLowWatermarkBytes     int64         `mapstructure:"-"`
+	MidCacheURLs               []string      `mapstructure:"-"`
+	MidInternalURLs            []string      `mapstructure:"-"`
+	MidFailureCooldownDuration time.Duration `mapstructure:"-"`
+	S3ConnectTimeoutDuration   time.Duration `mapstructure:"-"`
+	S3TimeoutDuration          time.Duration `mapstructure:"-"`
 }
 
 func Load() *Config {
@@ -37,12 +71,37 @@ func Load() *Config {
 	v.SetDefault("APP_MODE", "debug")
 	v.SetDefault("CACHE_TTL", 10)
 	v.SetDefault("CACHE_DIR", "./cache")
+	v.SetDefault("STORAGE_BACKEND", "fs")
+	v.SetDefault("S3_ENDPOINT", "http://127.0.0.1:9000")
+	v.SetDefault("S3_REGION", "us-east-1")
+	v.SetDefault("S3_BUCKET", "")
+	v.SetDefault("S3_PREFIX", "")
+	v.SetDefault("S3_ACCESS_KEY", "")
+	v.SetDefault("S3_SECRET_KEY", "")
+	v.SetDefault("S3_CONNECT_TIMEOUT", 5)
+	v.SetDefault("S3_TIMEOUT", 60)
 	v.SetDefault("METADATA_EXT", ".meta")
 	v.SetDefault("CACHE_CLEANER_TTL", 60)
+	v.SetDefault("CACHE_LOCK_TIMEOUT", 10)
+	v.SetDefault("CACHE_RETENTION", 86400)
+	v.SetDefault("CACHE_MAX_SIZE", 0)
+	v.SetDefault("CACHE_HIGH_WATERMARK", 90)
+	v.SetDefault("CACHE_LOW_WATERMARK", 80)
+	v.SetDefault("CACHE_EVICTION_POLICY", "lru")
+	v.SetDefault("MEMORY_CACHE_MAX_SIZE", 64<<20)
+	v.SetDefault("MEMORY_CACHE_MAX_OBJECT_SIZE", 64<<10)
+	v.SetDefault("MEMORY_CACHE_MIN_HITS", 2)
+	v.SetDefault("COMPRESSION_ENABLED", true)
+	v.SetDefault("COMPRESSION_MIN_SIZE", 1024)
+	v.SetDefault("PREFETCH_MAX_CONCURRENCY", 8)
+	v.SetDefault("DEBUG_HEADER_SECRET", "")
 	v.SetDefault("APP_CACHE_URL", "127.0.0.1:8080")
 	v.SetDefault("APP_INTERNAL_URL", "127.0.0.1:8090")
 	v.SetDefault("MID_CACHE_URL", "127.0.0.1:9050")
 	v.SetDefault("MID_INTERNAL_URL", "127.0.0.1:9060")
+	v.SetDefault("MID_FAILURE_COOLDOWN", 10)
+	v.SetDefault("ORIGIN_FALLBACK", false)
+	v.SetDefault("ORIGIN_FALLBACK_MAX_CONCURRENCY", 16)
 	v.SetDefault("ORIGINS", map[string]string{})
 
 	// Load .env if exists
@@ -60,6 +119,30 @@ func Load() *Config {
 	// Convert seconds → time.Duration
 	cfg.CacheTTLDuration = time.Duration(cfg.CacheTTL) * time.Second
 	cfg.CleanerIntervalDuration = time.Duration(cfg.CleanerInterval) * time.Second
+	cfg.CacheLockTimeoutDuration = time.Duration(cfg.CacheLockTimeout) * time.Second
+	cfg.CacheRetentionDuration = time.Duration(cfg.CacheRetention) * time.Second
+	cfg.MidFailureCooldownDuration = time.Duration(cfg.MidFailureCooldown) * time.Second
+	cfg.S3ConnectTimeoutDuration = time.Duration(cfg.S3ConnectTimeout) * time.Second
+	cfg.S3TimeoutDuration = time.Duration(cfg.S3Timeout) * time.Second
+
+	// Convert watermark percentages → bytes
+	cfg.CacheHighWatermarkBytes = cfg.CacheMaxSize * int64(cfg.CacheHighWatermark) / 100
+	cfg.CacheLowWatermarkBytes = cfg.CacheMaxSize * int64(cfg.CacheLowWatermark) / 100
+
+	// Split mid lists
+	cfg.MidCacheURLs = splitList(cfg.MidCacheURL)
+	cfg.MidInternalURLs = splitList(cfg.MidInternalURL)
 
 	return &cfg
 }
+
+// splitList returns the non-empty entries of a comma-separated list.
+func splitList(value string) []string {
+	var out []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -5,10 +5,51 @@ import (
 	"time"
 )
 
+// TTL policies deciding how origin caching headers affect the cache TTL
+const (
+	TTLPolicyRespect  = "respect"  // use origin freshness, CacheTTL when the origin sets none
+	TTLPolicyOverride = "override" // always use CacheTTL
+	TTLPolicyClamp    = "clamp"    // use origin freshness clamped to MinTTL and MaxTTL
+)
+
+// Query key modes deciding which query parameters are part of the cache key
+const (
+	QueryKeyInclude   = "include"   // the query string as sent, the default
+	QueryKeyIgnore    = "ignore"    // no query parameters
+	QueryKeySorted    = "sorted"    // all parameters, sorted by name
+	QueryKeyAllowlist = "allowlist" // only the parameters in QueryKeyParams, sorted
+	QueryKeyDenylist  = "denylist"  // all but the parameters in QueryKeyParams, sorted
+)
+
 type CacheItem struct {
+	Key       string      `json:"key"`
 	FilePath  string      `json:"file_path"`
+	Status    int         `json:"status,omitempty"`
 	Header    http.Header `json:"header"`
 	ExpiresAt time.Time   `json:"expires_at"`
+	TTLSource string      `json:"ttl_source"`     // what the TTL was derived from, e.g. "max-age"
+	Vary      []string    `json:"vary,omitempty"` // request headers the response varies on
+	Tags      []string    `json:"tags,omitempty"` // from Surrogate-Key and Cache-Tag
+
+	// Once expired, the item may still be served while it is refreshed in the
+	// background until StaleUntil, and when upstream fails until StaleIfErrorUntil
+	StaleUntil        time.Time `json:"stale_until"`
+	StaleIfErrorUntil time.Time `json:"stale_if_error_until"`
+}
+
+// StatusCode returns the status of the cached response. Items cached before
+// statuses were recorded are 200 responses.
+func (i *CacheItem) StatusCode() int {
+	if i.Status == 0 {
+		return http.StatusOK
+	}
+	return i.Status
+}
+
+// HasValidators reports whether the item can be revalidated upstream with a
+// conditional request once it expires.
+func (i *CacheItem) HasValidators() bool {
+	return i.Header.Get("ETag") != "" || i.Header.Get("Last-Modified") != ""
 }
 
 type Edge struct {
@@ -19,10 +60,176 @@ type Edge struct {
 	Version   string    `json:"version"`
 }
 
+// HeartbeatResponse is the answer of the mid to an edge heartbeat.
+type HeartbeatResponse struct {
+	Status         string        `json:"status"`
+	Instance       string        `json:"instance"`
+	CdnListVersion string        `json:"cdn_list_version"`
+	Purges         []PurgeJob    `json:"purges"`         // purge jobs the edge has not completed yet
+	Prefetches     []PrefetchJob `json:"prefetches"`     // prefetch jobs the edge has not completed yet
+	Mids           []string      `json:"mids,omitempty"` // mid cache addresses to spread cache fills over
+}
+
 type CDN struct {
-	ID       string `json:"id"`
-	Domain   string `json:"domain"`
-	Origin   string `json:"origin"`
-	IsActive bool   `json:"is_active"`
-	CacheTTL uint   `json:"cache_ttl"`
+	ID        string `json:"id"`
+	Domain    string `json:"domain"`
+	Origin    string `json:"origin"` // the primary origin, used when Origins is empty
+	IsActive  bool   `json:"is_active"`
+	CacheTTL  uint   `json:"cache_ttl"`
+	TTLPolicy string `json:"ttl_policy"`
+	MinTTL    uint   `json:"min_ttl"`
+	MaxTTL    uint   `json:"max_ttl"`    // 0 means no upper bound
+	SliceSize uint   `json:"slice_size"` // bytes, 0 caches whole objects
+
+	// Grace windows in seconds during which expired items are served stale
+	StaleWhileRevalidate uint `json:"stale_while_revalidate"`
+	StaleIfError         uint `json:"stale_if_error"`
+
+	QueryKeyMode   string   `json:"query_key_mode"`
+	QueryKeyParams []string `json:"query_key_params"` // for the allowlist and denylist modes
+
+	// TTLs in seconds of the redirect and error statuses in CacheableStatuses,
+	// by status code. Statuses without one are not cached.
+	StatusTTLs map[string]uint `json:"status_ttls"`
+
+	// Rules deciding what is cached, evaluated in order before DefaultCacheRules.
+	// The first rule matching a request and its response applies.
+	CacheRules []CacheRule `json:"cache_rules"`
+
+	// Leaves X-Cache, Cache-Status, Via and X-Served-By out of responses to
+	// requests without a valid signed debug header
+	HideDebugHeaders bool `json:"hide_debug_headers"`
+
+	// Pool of origins cache fills are balanced over with OriginBalance
+	Origins       []OriginServer `json:"origins"`
+	OriginBalance string         `json:"origin_balance"`
+}
+
+// OriginServers returns the origin pool of the CDN, or its only origin for
+// CDNs without a pool.
+func (c CDN) OriginServers() []OriginServer {
+	if len(c.Origins) == 0 {
+		return []OriginServer{{URL: c.Origin, Weight: 1}}
+	}
+	return c.Origins
+}
+
+// Origin balancing strategies deciding which origin of a pool a fill goes to
+const (
+	OriginBalanceRoundRobin       = "round_robin"       // weighted round-robin, the default
+	OriginBalanceLeastConnections = "least_connections" // fewest requests in flight relative to weight
+	OriginBalanceIPHash           = "ip_hash"           // weighted hashing of the client IP
+)
+
+// OriginServer is an origin of a CDN's pool. Origins of the lowest priority
+// share the fills by weight, the others are backups used when they fail.
+type OriginServer struct {
+	URL      string `json:"url"`
+	Weight   uint   `json:"weight"`   // relative share of the fills, 1 when 0
+	Priority uint   `json:"priority"` // lower is preferred, 0 for primaries
+}
+
+// CacheableStatuses are the statuses besides 200 a CDN may cache
+var CacheableStatuses = []int{301, 302, 404, 410}
+
+// Cache rule actions
+const (
+	CacheRuleCache  = "cache"  // store matching responses
+	CacheRuleBypass = "bypass" // neither serve matching requests from cache nor store them
+)
+
+// CacheRule decides whether the requests and responses it matches are cached.
+// A rule matches when all of its conditions do; empty conditions match
+// anything.
+type CacheRule struct {
+	Name         string            `json:"name"`
+	PathGlob     string            `json:"path_glob"`     // "*" matches within a path segment, "**" across segments
+	PathRegex    string            `json:"path_regex"`    // RE2 syntax, unanchored
+	Extensions   []string          `json:"extensions"`    // of the last path segment, without the dot
+	Methods      []string          `json:"methods"`       // request methods
+	ContentTypes []string          `json:"content_types"` // prefixes of the response Content-Type
+	Headers      map[string]string `json:"headers"`       // request headers by name, with this value or any when empty
+	Cookies      map[string]string `json:"cookies"`       // request cookies by name, with this value or any when empty
+
+	Action    string `json:"action"`
+	TTL       uint   `json:"ttl"`        // seconds, in place of the CDN's CacheTTL when not 0
+	TTLPolicy string `json:"ttl_policy"` // in place of the CDN's TTLPolicy when set
+}
+
+// DefaultCacheRules apply after the rules of a CDN: static assets are cached.
+// 200 responses no rule matches are not.
+var DefaultCacheRules = []CacheRule{{
+	Name: "static",
+	ContentTypes: []string{
+		"image/",
+		"font/",
+		"text/css",
+		"text/javascript",
+		"application/javascript",
+		"application/x-javascript",
+		"video/",
+		"audio/",
+	},
+	Action: CacheRuleCache,
+}}
+
+// Purge types deciding what a purge job invalidates
+const (
+	PurgeTypeURL    = "url"    // the exact URLs in Paths
+	PurgeTypePrefix = "prefix" // every URL starting with one of Paths
+	PurgeTypeDomain = "domain" // everything cached for Domain
+	PurgeTypeTag    = "tag"    // everything cached for Domain tagged with one of Tags
+)
+
+// Purge statuses of a single node
+const (
+	PurgeStatusPending = "pending"
+	PurgeStatusDone    = "done"
+	PurgeStatusFailed  = "failed"
+)
+
+type PurgeJob struct {
+	ID     string   `json:"id"`
+	Domain string   `json:"domain"`
+	Type   string   `json:"type"`
+	Paths  []string `json:"paths"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+// PurgeResult reports the progress of a purge job on one mid or edge.
+type PurgeResult struct {
+	JobID     string    `json:"job_id"`
+	Service   string    `json:"service"`
+	Instance  string    `json:"instance"`
+	Status    string    `json:"status"`
+	Purged    int       `json:"purged"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Prefetch statuses of a single node
+const (
+	PrefetchStatusPending = "pending"
+	PrefetchStatusRunning = "running"
+	PrefetchStatusDone    = "done"
+)
+
+type PrefetchJob struct {
+	ID          string   `json:"id"`
+	Domain      string   `json:"domain"`
+	Paths       []string `json:"paths"`       // request URIs
+	Concurrency int      `json:"concurrency"` // URLs fetched at once by each node
+}
+
+// PrefetchResult reports the progress of a prefetch job on one mid or edge.
+type PrefetchResult struct {
+	JobID     string    `json:"job_id"`
+	Service   string    `json:"service"`
+	Instance  string    `json:"instance"`
+	Status    string    `json:"status"`
+	Total     int       `json:"total"`
+	Fetched   int       `json:"fetched"`
+	Failed    int       `json:"failed"`
+	Errors    []string  `json:"errors,omitempty"` // the first failures
+	Timestamp time.Time `json:"timestamp"`
 }
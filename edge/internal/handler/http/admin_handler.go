@@ -0,0 +1,25 @@
+package http
+
+import (
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type AdminHandler struct {
+	adminService service.AdminServiceInterface
+}
+
+func NewAdminHandler(adminService service.AdminServiceInterface) *AdminHandler {
+	return &AdminHandler{
+		adminService: adminService,
+	}
+}
+
+// Register adds the cache inspection endpoints. Keys are passed as query
+// parameters since they contain slashes.
+func (h *AdminHandler) Register(r *gin.Engine) {
+	r.GET("/admin/cache/item", h.adminService.GetItem)
+	r.DELETE("/admin/cache/item", h.adminService.DeleteItem)
+	r.GET("/admin/cache/items", h.adminService.ListItems)
+	r.GET("/admin/cache/stats", h.adminService.Stats)
+}
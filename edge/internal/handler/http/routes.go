@@ -8,3 +8,7 @@ import (
 func RegisterCacheRoutes(r *gin.Engine, cacheSvc service.CacheServiceInterface) {
 	NewCacheHandler(cacheSvc).Register(r)
 }
+
+func RegisterAdminRoutes(r *gin.Engine, adminSvc service.AdminServiceInterface) {
+	NewAdminHandler(adminSvc).Register(r)
+}
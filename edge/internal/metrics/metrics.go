@@ -41,6 +41,14 @@ var (
 		[]string{"host"},
 	)
 
+	CacheBypasses = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_cache_bypasses_total",
+			Help: "Total number of requests a cache rule bypassed the cache for",
+		},
+		[]string{"host"},
+	)
+
 	CacheSize = promauto.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "edge_cache_size_bytes",
@@ -55,6 +63,116 @@ var (
 		},
 	)
 
+	// CacheTierHits Storage tier metrics
+	CacheTierHits = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_cache_tier_hits_total",
+			Help: "Total number of cache hits by the storage tier the body was read from",
+		},
+		[]string{"host", "tier"},
+	)
+
+	MemoryTierSize = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "edge_cache_memory_tier_bytes",
+			Help: "Current size in bytes of the bodies held in the memory tier",
+		},
+	)
+
+	MemoryTierItems = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "edge_cache_memory_tier_items",
+			Help: "Current number of bodies held in the memory tier",
+		},
+	)
+
+	MemoryTierMoves = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_cache_memory_tier_moves_total",
+			Help: "Total number of bodies promoted to or demoted from the memory tier",
+		},
+		[]string{"direction"},
+	)
+
+	// CollapsedRequests Request coalescing metrics
+	CollapsedRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_cache_collapsed_requests_total",
+			Help: "Total number of cache misses collapsed into an in-flight fetch",
+		},
+		[]string{"host"},
+	)
+
+	CacheLockTimeouts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_cache_lock_timeouts_total",
+			Help: "Total number of collapsed requests that timed out waiting for the in-flight fetch",
+		},
+		[]string{"host"},
+	)
+
+	// Revalidations Conditional revalidation metrics
+	Revalidations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_cache_revalidations_total",
+			Help: "Total number of expired cache items revalidated upstream",
+		},
+		[]string{"host", "result"},
+	)
+
+	// StaleServed Stale serving metrics
+	StaleServed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_cache_stale_served_total",
+			Help: "Total number of expired cache items served stale",
+		},
+		[]string{"host", "reason"},
+	)
+
+	// PurgedItems Purge metrics
+	PurgedItems = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_cache_purged_items_total",
+			Help: "Total number of cache items removed by purge jobs",
+		},
+		[]string{"host"},
+	)
+
+	// EvictedItems Eviction metrics
+	EvictedItems = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_cache_evicted_items_total",
+			Help: "Total number of cache items evicted before they expired",
+		},
+		[]string{"reason"},
+	)
+
+	EvictedBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_cache_evicted_bytes_total",
+			Help: "Total number of bytes freed on disk by evictions",
+		},
+		[]string{"reason"},
+	)
+
+	// CompressedResponses Compression metrics
+	CompressedResponses = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_compressed_responses_total",
+			Help: "Total number of upstream responses compressed by the cache",
+		},
+		[]string{"host", "encoding"},
+	)
+
+	// PrefetchRequests Prefetch metrics
+	PrefetchRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_prefetch_requests_total",
+			Help: "Total number of URLs fetched by prefetch jobs",
+		},
+		[]string{"host", "result"},
+	)
+
 	// OriginRequestsTotal Origin request metrics
 	OriginRequestsTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -73,6 +191,39 @@ var (
 		[]string{"host", "status"},
 	)
 
+	// MidUp Mid pool metrics
+	MidUp = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "edge_mid_up",
+			Help: "Whether cache fills are sent to a mid (1) or it is skipped after failing (0)",
+		},
+		[]string{"mid"},
+	)
+
+	MidFailovers = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_mid_failovers_total",
+			Help: "Total number of requests to a mid that failed and moved on to the next mid",
+		},
+		[]string{"mid"},
+	)
+
+	// DegradedMode Origin fallback metrics
+	DegradedMode = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "edge_degraded_mode",
+			Help: "Whether cache fills bypass the mid tier for the origin because no mid is available (1) or not (0)",
+		},
+	)
+
+	TierBypasses = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_tier_bypasses_total",
+			Help: "Total number of cache fills sent straight to the origin, bypassing the mid tier",
+		},
+		[]string{"host", "result"},
+	)
+
 	// BytesSent Bandwidth metrics
 	BytesSent = promauto.NewCounterVec(
 		prometheus.CounterOpts{
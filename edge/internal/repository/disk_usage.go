@@ -0,0 +1,255 @@
+package repository
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+)
+
+// Eviction policies deciding which items go first once the cache outgrows
+// its byte budget
+const (
+	EvictionLRU = "lru" // least recently used
+	EvictionLFU = "lfu" // least frequently used, then least recently used
+)
+
+// diskUsage tracks how many bytes every cached item takes on disk and how
+// it is accessed.
+type diskUsage struct {
+	mu      sync.Mutex
+	entries map[string]*diskEntry
+	total   int64
+}
+
+type diskEntry struct {
+	item       *domain.CacheItem
+	size       int64
+	lastAccess time.Time
+	hits       int64
+}
+
+// CacheEntryInfo describes a cached item along with how it is stored and
+// used.
+type CacheEntryInfo struct {
+	Item       *domain.CacheItem
+	Size       int64 // bytes on disk
+	LastAccess time.Time
+	Hits       int64
+}
+
+// CacheUsage is how much of the cache the items of one domain take.
+type CacheUsage struct {
+	Items   int   `json:"items"`
+	Expired int   `json:"expired"` // kept for revalidation or stale serving
+	Bytes   int64 `json:"bytes"`
+}
+
+// victim is an item picked for eviction.
+type victim struct {
+	key  string
+	item *domain.CacheItem
+	size int64
+}
+
+func newDiskUsage() *diskUsage {
+	return &diskUsage{entries: make(map[string]*diskEntry)}
+}
+
+// add records item under key, whose entry takes size bytes.
+func (d *diskUsage) add(key string, item *domain.CacheItem, size int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.entries[key]
+	if !ok {
+		entry = &diskEntry{}
+		d.entries[key] = entry
+	}
+	d.total += size - entry.size
+	entry.item = item
+	entry.size = size
+	entry.lastAccess = time.Now()
+}
+
+func (d *diskUsage) touch(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if entry, ok := d.entries[key]; ok {
+		entry.lastAccess = time.Now()
+		entry.hits++
+	}
+}
+
+func (d *diskUsage) remove(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if entry, ok := d.entries[key]; ok {
+		d.total -= entry.size
+		delete(d.entries, key)
+	}
+}
+
+// removeItem forgets key only while it still records item, so dropping a
+// replaced item does not forget its successor. It returns the bytes the
+// item took and whether it was forgotten.
+func (d *diskUsage) removeItem(key string, item *domain.CacheItem) (int64, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.entries[key]
+	if !ok || entry.item != item {
+		return 0, false
+	}
+	d.total -= entry.size
+	delete(d.entries, key)
+	return entry.size, true
+}
+
+// hits returns how often key was accessed while it records item.
+func (d *diskUsage) hits(key string, item *domain.CacheItem) (int64, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.entries[key]
+	if !ok || entry.item != item {
+		return 0, false
+	}
+	return entry.hits, true
+}
+
+func (d *diskUsage) info(key string) (CacheEntryInfo, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.entries[key]
+	if !ok {
+		return CacheEntryInfo{}, false
+	}
+	return entry.info(), true
+}
+
+// list returns the entries whose key starts with prefix and sorts after
+// after, in key order, at most limit of them.
+func (d *diskUsage) list(prefix, after string, limit int) []CacheEntryInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var keys []string
+	for key := range d.entries {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	out := make([]CacheEntryInfo, 0, min(len(keys), limit))
+	for _, key := range keys[:min(len(keys), limit)] {
+		out = append(out, d.entries[key].info())
+	}
+	return out
+}
+
+// usageByDomain sums the entries by the domain their key starts with.
+func (d *diskUsage) usageByDomain() map[string]CacheUsage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	usage := make(map[string]CacheUsage)
+	for key, entry := range d.entries {
+		host, _, _ := strings.Cut(key, "/")
+		u := usage[host]
+		u.Items++
+		u.Bytes += entry.size
+		if !now.Before(entry.item.ExpiresAt) {
+			u.Expired++
+		}
+		usage[host] = u
+	}
+	return usage
+}
+
+func (e *diskEntry) info() CacheEntryInfo {
+	return CacheEntryInfo{Item: e.item, Size: e.size, LastAccess: e.lastAccess, Hits: e.hits}
+}
+
+func (d *diskUsage) size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.total
+}
+
+// totals returns the bytes all entries take and how many there are.
+func (d *diskUsage) totals() (int64, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.total, len(d.entries)
+}
+
+// expired returns the items retained until before now.
+func (d *diskUsage) expired(now time.Time, retainUntil func(*domain.CacheItem) time.Time) []victim {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var out []victim
+	for key, entry := range d.entries {
+		if now.After(retainUntil(entry.item)) {
+			out = append(out, victim{key: key, item: entry.item, size: entry.size})
+		}
+	}
+	return out
+}
+
+// matching returns the items whose key matches.
+func (d *diskUsage) matching(match func(key string) bool) []victim {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var out []victim
+	for key, entry := range d.entries {
+		if match(key) {
+			out = append(out, victim{key: key, item: entry.item, size: entry.size})
+		}
+	}
+	return out
+}
+
+// victims returns the items to evict, in order, to bring the total down to
+// target.
+func (d *diskUsage) victims(target int64, policy string) []victim {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	excess := d.total - target
+	if excess <= 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(d.entries))
+	for key := range d.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := d.entries[keys[i]], d.entries[keys[j]]
+		if policy == EvictionLFU && a.hits != b.hits {
+			return a.hits < b.hits
+		}
+		return a.lastAccess.Before(b.lastAccess)
+	})
+
+	var out []victim
+	for _, key := range keys {
+		if excess <= 0 {
+			break
+		}
+		entry := d.entries[key]
+		out = append(out, victim{key: key, item: entry.item, size: entry.size})
+		excess -= entry.size
+	}
+	return out
+}
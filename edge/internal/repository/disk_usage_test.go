@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/metrics"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/storage"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestVictims(t *testing.T) {
+	d := newDiskUsage()
+	start := time.Now()
+	// Oldest first, the oldest used most
+	for i, key := range []string{"a", "b", "c"} {
+		d.add(key, &domain.CacheItem{Key: key}, 10)
+		d.entries[key].lastAccess = start.Add(time.Duration(i) * time.Second)
+		d.entries[key].hits = []int64{5, 0, 1}[i]
+	}
+	keys := func(victims []victim) []string {
+		var out []string
+		for _, v := range victims {
+			out = append(out, v.key)
+		}
+		return out
+	}
+
+	for _, tc := range []struct {
+		policy string
+		target int64
+		want   []string
+	}{
+		{EvictionLRU, 15, []string{"a", "b"}},
+		{EvictionLRU, 20, []string{"a"}},
+		{EvictionLFU, 15, []string{"b", "c"}},
+		{EvictionLFU, 30, nil},
+	} {
+		if got := keys(d.victims(tc.target, tc.policy)); !slices.Equal(got, tc.want) {
+			t.Errorf("%s victims down to %d: %q, want %q", tc.policy, tc.target, got, tc.want)
+		}
+	}
+
+	// Equal hits fall back to recency
+	d.entries["c"].hits = 0
+	if got := keys(d.victims(20, EvictionLFU)); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("lfu victims with equal hits %q, want the least recent", got)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	store := storage.NewMemory()
+	cfg := &config.Config{
+		CacheMaxSize:            100,
+		CacheHighWatermarkBytes: 30,
+		CacheLowWatermarkBytes:  15,
+		CacheEvictionPolicy:     EvictionLRU,
+	}
+	r := NewCacheItemRepository(cfg, store).(*cacheItemRepository)
+	defer r.cache.Close()
+
+	// Accesses set in the past stay older than any made by the test
+	start := time.Now().Add(-time.Hour)
+	set := func(i int, key string) *domain.CacheItem {
+		item := &domain.CacheItem{Key: key, FilePath: key + ".cache", ExpiresAt: time.Now().Add(time.Hour)}
+		w, err := store.Create(item.FilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		r.set(key, item, 10)
+		r.usage.mu.Lock()
+		r.usage.entries[key].lastAccess = start.Add(time.Duration(i) * time.Second)
+		r.usage.mu.Unlock()
+		return item
+	}
+	counter := func(tier string) float64 {
+		var m dto.Metric
+		if err := metrics.EvictedItems.WithLabelValues(tier).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetCounter().GetValue()
+	}
+	gone := func(item *domain.CacheItem) bool {
+		_, err := store.Size(item.FilePath)
+		return errors.Is(err, fs.ErrNotExist)
+	}
+	disk, index := counter("disk"), counter("index")
+
+	// Up to the high watermark nothing is evicted
+	var items []*domain.CacheItem
+	for i, key := range []string{"example.com/a", "example.com/b", "example.com/c"} {
+		items = append(items, set(i, key))
+	}
+	if size := r.usage.size(); size != 30 || counter("disk") != disk {
+		t.Fatalf("cache at the high watermark holds %d bytes, evicted %v, want 30 and none", size, counter("disk")-disk)
+	}
+
+	// Past it the least recently used go until the low watermark is reached
+	items = append(items, set(3, "example.com/d"))
+	deadline := time.Now().Add(time.Second)
+	for r.usage.size() > cfg.CacheLowWatermarkBytes || r.evicting.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("cache holds %d bytes, want at most %d", r.usage.size(), cfg.CacheLowWatermarkBytes)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i, item := range items {
+		_, indexed := r.Inspect(item.Key)
+		if evicted := i < 3; indexed == evicted || gone(item) != evicted {
+			t.Errorf("%s indexed %v, removed %v, want evicted %v", item.Key, indexed, gone(item), evicted)
+		}
+	}
+
+	// Evictions over the disk budget are not counted as index drops
+	if n := counter("disk") - disk; n != 3 {
+		t.Errorf("disk evictions %v, want 3", n)
+	}
+	if n := counter("index") - index; n != 0 {
+		t.Errorf("index evictions %v, want none", n)
+	}
+}
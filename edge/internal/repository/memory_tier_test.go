@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/storage"
+)
+
+func TestMemoryTier(t *testing.T) {
+	m := newMemoryTier(10, 6)
+	for size, want := range map[int64]bool{0: false, 6: true, 7: false} {
+		if got := m.admits(size); got != want {
+			t.Errorf("admits(%d) = %v, want %v", size, got, want)
+		}
+	}
+
+	a, b, c := &domain.CacheItem{Key: "a"}, &domain.CacheItem{Key: "b"}, &domain.CacheItem{Key: "c"}
+	m.promote("a", a, []byte("aaaa"))
+	m.promote("b", b, []byte("bbbb"))
+	if _, ok := m.get("a", a); !ok {
+		t.Fatal("promoted body not held")
+	}
+
+	// The least recently used bodies are demoted to stay under the cap
+	m.promote("c", c, []byte("cccc"))
+	if _, ok := m.get("b", b); ok {
+		t.Error("least recently used body still held")
+	}
+	if data, ok := m.get("a", a); !ok || string(data) != "aaaa" {
+		t.Errorf("recently used body %q, %v, want it held", data, ok)
+	}
+	if m.size != 8 || m.lru.Len() != 2 {
+		t.Errorf("tier holds %d bodies of %d bytes, want 2 of 8", m.lru.Len(), m.size)
+	}
+
+	// Bodies of replaced items are dropped
+	if _, ok := m.get("c", &domain.CacheItem{Key: "c"}); ok {
+		t.Error("body of a replaced item served")
+	}
+	if _, ok := m.bodies["c"]; ok || m.size != 4 {
+		t.Errorf("body of a replaced item kept, tier holds %d bytes", m.size)
+	}
+
+	m.remove("a")
+	if m.size != 0 || m.lru.Len() != 0 {
+		t.Errorf("tier holds %d bodies of %d bytes after removal, want none", m.lru.Len(), m.size)
+	}
+}
+
+func TestOpenPromotes(t *testing.T) {
+	r := NewCacheItemRepository(&config.Config{
+		MemoryCacheMaxSize:   100,
+		MemoryCacheMaxObject: 10,
+		MemoryCacheMinHits:   1,
+	}, storage.NewMemory()).(*cacheItemRepository)
+	defer r.cache.Close()
+
+	set := func(key, body string) *domain.CacheItem {
+		item := &domain.CacheItem{Key: key, FilePath: key + ".cache", ExpiresAt: time.Now().Add(time.Hour)}
+		if err := r.WriteEntry(item, strings.NewReader(body)); err != nil {
+			t.Fatal(err)
+		}
+		r.Set(key, item)
+		return item
+	}
+	open := func(item *domain.CacheItem, want string) string {
+		body, tier, err := r.Open(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer body.Close()
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("read %q from %s, want %q", data, tier, want)
+		}
+		return tier
+	}
+
+	// Bodies are promoted once hit often enough, then served from memory
+	small := set("example.com/small", "body")
+	if tier := open(small, "body"); tier != TierDisk {
+		t.Errorf("first read from %s, want disk", tier)
+	}
+	if _, ok := r.memory.get(small.Key, small); ok {
+		t.Error("body promoted before its hits")
+	}
+	r.Get(small.Key)
+	if tier := open(small, "body"); tier != TierDisk {
+		t.Errorf("promoting read from %s, want disk", tier)
+	}
+	if tier := open(small, "body"); tier != TierMemory {
+		t.Errorf("read after promotion from %s, want memory", tier)
+	}
+
+	// Bodies larger than the object cap stay on disk
+	large := set("example.com/large", "large body!")
+	r.Get(large.Key)
+	open(large, "large body!")
+	if _, ok := r.memory.get(large.Key, large); ok {
+		t.Error("body over the object cap promoted")
+	}
+
+	// Purged and deleted items leave the tier
+	r.DeleteMatching(func(key string) bool { return key == small.Key })
+	if _, ok := r.memory.get(small.Key, small); ok {
+		t.Error("purged body still held")
+	}
+	small = set("example.com/small", "body")
+	r.Get(small.Key)
+	open(small, "body")
+	if r.memory.lru.Len() != 1 {
+		t.Fatal("refilled body not promoted")
+	}
+	r.Delete(small.Key)
+	if r.memory.lru.Len() != 0 {
+		t.Errorf("tier holds %d bodies after delete, want none", r.memory.lru.Len())
+	}
+}
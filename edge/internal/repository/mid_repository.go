@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// MidRepositoryInterface holds the mids cache keys are spread over and which
+// of them recently failed.
+type MidRepositoryInterface interface {
+	Set(mids []string)
+	GetAll() []string
+	MarkDown(mid string, until time.Time)
+	MarkUp(mid string)
+	IsDown(mid string) bool
+}
+
+type midRepository struct {
+	mu        sync.RWMutex
+	mids      []string
+	downUntil map[string]time.Time
+}
+
+func NewMidRepository(mids []string) MidRepositoryInterface {
+	return &midRepository{
+		mids:      slices.Clone(mids),
+		downUntil: make(map[string]time.Time),
+	}
+}
+
+// Set replaces the mid list. Mids that remain keep their failure state.
+func (r *midRepository) Set(mids []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.mids = slices.Clone(mids)
+	for mid := range r.downUntil {
+		if !slices.Contains(mids, mid) {
+			delete(r.downUntil, mid)
+		}
+	}
+}
+
+func (r *midRepository) GetAll() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.mids)
+}
+
+// MarkDown skips mid until the given time.
+func (r *midRepository) MarkDown(mid string, until time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.downUntil[mid] = until
+}
+
+func (r *midRepository) MarkUp(mid string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.downUntil, mid)
+}
+
+func (r *midRepository) IsDown(mid string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return time.Now().Before(r.downUntil[mid])
+}
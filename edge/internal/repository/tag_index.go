@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"sync"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+)
+
+// tagIndex maps the cache tags of items to their keys. It is rebuilt from the
+// item metadata by LoadFromDisk like the items themselves.
+type tagIndex struct {
+	mu   sync.RWMutex
+	tags map[string]map[string]*domain.CacheItem // tag -> key -> item
+	keys map[string][]string                     // key -> tags
+}
+
+func newTagIndex() *tagIndex {
+	return &tagIndex{
+		tags: make(map[string]map[string]*domain.CacheItem),
+		keys: make(map[string][]string),
+	}
+}
+
+// set replaces the tags indexed for key with those of item.
+func (t *tagIndex) set(key string, item *domain.CacheItem) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.removeLocked(key)
+	if len(item.Tags) == 0 {
+		return
+	}
+
+	for _, tag := range item.Tags {
+		if t.tags[tag] == nil {
+			t.tags[tag] = make(map[string]*domain.CacheItem)
+		}
+		t.tags[tag][key] = item
+	}
+	t.keys[key] = item.Tags
+}
+
+func (t *tagIndex) remove(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.removeLocked(key)
+}
+
+func (t *tagIndex) removeLocked(key string) {
+	for _, tag := range t.keys[key] {
+		delete(t.tags[tag], key)
+		if len(t.tags[tag]) == 0 {
+			delete(t.tags, tag)
+		}
+	}
+	delete(t.keys, key)
+}
+
+// lookup returns the keys tagged with any of tags and their items.
+func (t *tagIndex) lookup(tags []string) map[string]*domain.CacheItem {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	result := make(map[string]*domain.CacheItem)
+	for _, tag := range tags {
+		for key, item := range t.tags[tag] {
+			result[key] = item
+		}
+	}
+	return result
+}
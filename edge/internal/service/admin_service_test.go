@@ -0,0 +1,113 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/repository"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminListItems(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cacheItemRepo := repository.NewCacheItemRepository(&config.Config{}, storage.NewMemory())
+	for _, key := range []string{"example.com/a.png", "example.com/img/b.png", "example.com.evil/c.png", "example.org/d.png"} {
+		cacheItemRepo.Set(key, &domain.CacheItem{Key: key, ExpiresAt: time.Now().Add(time.Hour)})
+	}
+	s := NewAdminService(&config.Config{}, repository.NewCdnRepository(), cacheItemRepo)
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"domain=example.com", []string{"example.com/a.png", "example.com/img/b.png"}},
+		{"domain=example.com&prefix=/img", []string{"example.com/img/b.png"}},
+		{"domain=example.com&prefix=img", []string{"example.com/img/b.png"}},
+		{"prefix=example.com", []string{"example.com.evil/c.png", "example.com/a.png", "example.com/img/b.png"}},
+		{"limit=2&cursor=example.com/a.png", []string{"example.com/img/b.png", "example.org/d.png"}},
+	} {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodGet, "/admin/cache/items?"+tc.query, nil)
+		s.ListItems(c)
+
+		var body struct {
+			Items []struct {
+				Key string `json:"key"`
+			} `json:"items"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, item := range body.Items {
+			keys = append(keys, item.Key)
+		}
+		if !slices.Equal(keys, tc.want) {
+			t.Errorf("%s listed %q, want %q", tc.query, keys, tc.want)
+		}
+	}
+}
+
+// keptItems is a cache whose deletes leave the items listed.
+type keptItems struct {
+	repository.CacheItemRepositoryInterface
+	deleted []string
+}
+
+func (r *keptItems) Delete(key string) {
+	r.deleted = append(r.deleted, key)
+}
+
+func TestAdminDeleteItem(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cacheItemRepo := repository.NewCacheItemRepository(&config.Config{}, storage.NewMemory())
+	cache := func(key string) {
+		cacheItemRepo.Set(key, &domain.CacheItem{Key: key, ExpiresAt: time.Now().Add(time.Hour)})
+	}
+	cache("example.com/a.png")
+	cache("example.com/a.png.bak")
+	for i := range maxAdminPageSize + 1 {
+		cache(fmt.Sprintf("example.com/a.png#slice=%d", i))
+	}
+	deleteItem := func(s AdminServiceInterface, key string) []string {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodDelete, "/admin/cache/item?key="+url.QueryEscape(key), nil)
+		s.DeleteItem(c)
+
+		var body struct {
+			Deleted []string `json:"deleted"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Deleted
+	}
+
+	// Related items are paged through, whether or not deletes take effect at once
+	kept := &keptItems{CacheItemRepositoryInterface: cacheItemRepo}
+	if n := len(deleteItem(NewAdminService(&config.Config{}, repository.NewCdnRepository(), kept), "example.com/a.png")); n != maxAdminPageSize+2 {
+		t.Errorf("deleted %d keys, want the item and its %d slices", n, maxAdminPageSize+1)
+	}
+	if n := len(kept.deleted); n != maxAdminPageSize+2 {
+		t.Errorf("%d deletes, want one per key", n)
+	}
+
+	s := NewAdminService(&config.Config{}, repository.NewCdnRepository(), cacheItemRepo)
+	if n := len(deleteItem(s, "example.com/a.png")); n != maxAdminPageSize+2 {
+		t.Errorf("deleted %d keys, want %d", n, maxAdminPageSize+2)
+	}
+	if items := cacheItemRepo.List("example.com/", "", maxAdminPageSize); len(items) != 1 || items[0].Item.Key != "example.com/a.png.bak" {
+		t.Errorf("%d items left, want only the unrelated one", len(items))
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+)
+
+func TestRequestCacheKey(t *testing.T) {
+	const target = "/img/a.png?w=100&v=2&utm_source=x&a=1"
+	for _, tc := range []struct {
+		mode   string
+		params []string
+		target string
+		want   string
+	}{
+		{"", nil, target, "example.com/img/a.png?w=100&v=2&utm_source=x&a=1"},
+		{domain.QueryKeyInclude, nil, target, "example.com/img/a.png?w=100&v=2&utm_source=x&a=1"},
+		{domain.QueryKeyIgnore, nil, target, "example.com/img/a.png"},
+		{domain.QueryKeySorted, nil, target, "example.com/img/a.png?a=1&utm_source=x&v=2&w=100"},
+		{domain.QueryKeyAllowlist, []string{"w", "v"}, target, "example.com/img/a.png?v=2&w=100"},
+		{domain.QueryKeyAllowlist, []string{"h"}, target, "example.com/img/a.png"},
+		{domain.QueryKeyDenylist, []string{"utm_source"}, target, "example.com/img/a.png?a=1&v=2&w=100"},
+		{domain.QueryKeySorted, nil, "/img/a.png?b=%zz&a=1", "example.com/img/a.png?a=1"},
+		{domain.QueryKeySorted, nil, "/img/a.png?a=2&a=1", "example.com/img/a.png?a=2&a=1"},
+		{domain.QueryKeySorted, nil, "/img/a.png", "example.com/img/a.png"},
+	} {
+		u, err := url.Parse(tc.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cdn := domain.CDN{QueryKeyMode: tc.mode, QueryKeyParams: tc.params}
+		if got := requestCacheKey(cdn, "example.com", u); got != tc.want {
+			t.Errorf("%q %q: key of %q = %q, want %q", tc.mode, tc.params, tc.target, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+)
+
+func TestGlobExpr(t *testing.T) {
+	for _, tc := range []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"/img/*.png", "/img/a.png", true},
+		{"/img/*.png", "/img/sub/a.png", false},
+		{"/img/**.png", "/img/sub/a.png", true},
+		{"/img/**", "/img/", true},
+		{"/img/?.png", "/img/a.png", true},
+		{"/img/?.png", "/img/ab.png", false},
+		{"/img/a.png", "/img/aXpng", false},
+		{"/img/a.png", "/x/img/a.png", false},
+	} {
+		if got := regexp.MustCompile(globExpr(tc.glob)).MatchString(tc.path); got != tc.match {
+			t.Errorf("glob %q on %q matched %v, want %v", tc.glob, tc.path, got, tc.match)
+		}
+	}
+}
+
+func TestMatchCacheRule(t *testing.T) {
+	rules := []domain.CacheRule{
+		{Name: "admin", PathGlob: "/admin/**", Action: domain.CacheRuleBypass},
+		{Name: "api", PathRegex: `^/api/v\d+/`, Methods: []string{"get"}, ContentTypes: []string{"application/json"}, Action: domain.CacheRuleCache, TTL: 10},
+		{Name: "downloads", Extensions: []string{".ZIP", "tar"}, Action: domain.CacheRuleCache},
+		{Name: "preview", Headers: map[string]string{"X-Preview": ""}, Action: domain.CacheRuleBypass},
+		{Name: "beta", Headers: map[string]string{"X-Channel": "beta"}, Action: domain.CacheRuleBypass},
+		{Name: "session", Cookies: map[string]string{"session": ""}, Action: domain.CacheRuleBypass},
+		{Name: "locale", Cookies: map[string]string{"lang": "fa"}, Action: domain.CacheRuleBypass},
+		{Name: "broken", PathRegex: "(", Action: domain.CacheRuleBypass},
+	}
+	cdn := domain.CDN{CacheRules: rules}
+
+	for _, tc := range []struct {
+		name        string
+		method      string
+		path        string
+		header      map[string]string
+		contentType string
+		want        string
+		bypass      bool
+	}{
+		{name: "path glob", path: "/admin/users/1", contentType: "image/png", want: "admin", bypass: true},
+		{name: "regex, method and content type", path: "/api/v2/items", contentType: "application/json; charset=utf-8", want: "api"},
+		{name: "other content type", path: "/api/v2/items", contentType: "text/html"},
+		{name: "other method", method: http.MethodPost, path: "/api/v2/items", contentType: "application/json"},
+		{name: "extension", path: "/files/a.zip", want: "downloads"},
+		{name: "extension case", path: "/files/a.TAR", want: "downloads"},
+		{name: "any header value", path: "/a.css", header: map[string]string{"X-Preview": "1"}, contentType: "text/css", want: "preview", bypass: true},
+		{name: "header value", path: "/a.css", header: map[string]string{"X-Channel": "Beta"}, contentType: "text/css", want: "beta", bypass: true},
+		{name: "other header value", path: "/a.css", header: map[string]string{"X-Channel": "stable"}, contentType: "text/css", want: "static"},
+		{name: "any cookie value", path: "/a.css", header: map[string]string{"Cookie": "session=abc"}, contentType: "text/css", want: "session", bypass: true},
+		{name: "cookie value", path: "/a.css", header: map[string]string{"Cookie": "lang=fa"}, contentType: "text/css", want: "locale", bypass: true},
+		{name: "other cookie value", path: "/a.css", header: map[string]string{"Cookie": "lang=en"}, contentType: "text/css", want: "static"},
+		{name: "default rules", path: "/a.woff2", contentType: "font/woff2", want: "static"},
+		{name: "no rule", path: "/index.html", contentType: "text/html"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "http://example.com"+tc.path, nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+
+			rule := matchCacheRule(cdn, req, http.Header{"Content-Type": {tc.contentType}})
+			if rule.Name != tc.want {
+				t.Errorf("matched rule %q, want %q", rule.Name, tc.want)
+			}
+			if got := bypassesCache(cdn, req); got != tc.bypass {
+				t.Errorf("bypassesCache = %v, want %v", got, tc.bypass)
+			}
+		})
+	}
+}
+
+func TestRuleOrder(t *testing.T) {
+	cdn := domain.CDN{CacheRules: []domain.CacheRule{
+		{Name: "first", PathGlob: "/img/**", Action: domain.CacheRuleBypass},
+		{Name: "second", Extensions: []string{"png"}, Action: domain.CacheRuleCache},
+	}}
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/img/a.png", nil)
+	if rule := matchCacheRule(cdn, req, http.Header{"Content-Type": {"image/png"}}); rule.Name != "first" {
+		t.Errorf("matched rule %q, want the first matching rule", rule.Name)
+	}
+
+	// A bypass rule that needs the response is not applied before the fetch
+	cdn.CacheRules[0].ContentTypes = []string{"image/"}
+	if bypassesCache(cdn, req) {
+		t.Error("request bypasses the cache on a content type rule")
+	}
+}
+
+func TestCachePolicy(t *testing.T) {
+	cdn := domain.CDN{CacheTTL: 300, TTLPolicy: domain.TTLPolicyRespect, StatusTTLs: map[string]uint{"404": 30}}
+
+	if got := cachePolicy(cdn, domain.CacheRule{}, http.StatusOK); got.CacheTTL != 300 || got.TTLPolicy != domain.TTLPolicyRespect {
+		t.Errorf("policy without rule overrides: %d %q, want the CDN's", got.CacheTTL, got.TTLPolicy)
+	}
+	rule := domain.CacheRule{TTL: 60, TTLPolicy: domain.TTLPolicyOverride}
+	if got := cachePolicy(cdn, rule, http.StatusOK); got.CacheTTL != 60 || got.TTLPolicy != domain.TTLPolicyOverride {
+		t.Errorf("policy with rule overrides: %d %q, want the rule's", got.CacheTTL, got.TTLPolicy)
+	}
+	if got := cachePolicy(cdn, rule, http.StatusNotFound); got.CacheTTL != 30 {
+		t.Errorf("404 TTL %d, want the status TTL", got.CacheTTL)
+	}
+	if got := cachePolicy(cdn, rule, http.StatusGone); got.CacheTTL != 0 {
+		t.Errorf("410 TTL %d, want none", got.CacheTTL)
+	}
+}
@@ -1,14 +1,15 @@
 package service
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
-	"os"
-	"path/filepath"
+	"net/url"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/AmirAghaee/go-cdn-stack/edge/internal/config"
@@ -18,6 +19,15 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+const streamBufferSize = 32 * 1024
+
+var (
+	// errNoUpstream is returned for fills without any upstream node to go to.
+	errNoUpstream = errors.New("no upstream node")
+	// errUpstreamBusy is returned when the nodes left have no capacity.
+	errUpstreamBusy = errors.New("upstream concurrency limit reached")
+)
+
 type CacheServiceInterface interface {
 	CacheRequest(c *gin.Context)
 }
@@ -26,19 +36,27 @@ type cacheService struct {
 	config              *config.Config
 	cdnRepository       repository.CdnRepositoryInterface
 	cacheItemRepository repository.CacheItemRepositoryInterface
+	upstreams           UpstreamPoolInterface
+	fills               *fillGroup
 }
 
-func NewCacheService(config *config.Config, cdnRepo repository.CdnRepositoryInterface, cacheItemRepo repository.CacheItemRepositoryInterface) CacheServiceInterface {
+func NewCacheService(config *config.Config, cdnRepo repository.CdnRepositoryInterface, cacheItemRepo repository.CacheItemRepositoryInterface, upstreams UpstreamPoolInterface) CacheServiceInterface {
 	return &cacheService{
 		config:              config,
 		cdnRepository:       cdnRepo,
 		cacheItemRepository: cacheItemRepo,
+		upstreams:           upstreams,
+		fills:               newFillGroup(),
 	}
 }
 
 func (s *cacheService) CacheRequest(c *gin.Context) {
 	startTime := time.Now()
 
+	// Headers of responses without a body are written once the request is handled
+	d := s.startDiagnostics(c)
+	defer d.WriteHeaderNow()
+
 	host := c.Request.Host
 	cdn, ok := s.cdnRepository.GetByDomain(host)
 	if !ok {
@@ -47,62 +65,148 @@ func (s *cacheService) CacheRequest(c *gin.Context) {
 		s.recordMetrics(c, host, http.StatusBadGateway, startTime, "error")
 		return
 	}
+	d.hideUnlessDebug(cdn, c.Request, s.config.DebugHeaderSecret)
 
 	// Non-GET requests: just proxy
 	if c.Request.Method != http.MethodGet {
+		d.record(cacheBypass, fwdMethod)
 		s.proxyRequest(c, cdn.Origin)
 		s.recordMetrics(c, host, c.Writer.Status(), startTime, "proxy")
 		return
 	}
 
+	// Requests a cache rule bypasses the cache for are fetched without caching
+	if bypassesCache(cdn, c.Request) {
+		metrics.CacheBypasses.WithLabelValues(host).Inc()
+		d.record(cacheBypass, fwdBypass)
+		s.negotiateEncoding(c)
+		s.fetch(c, cdn, "", nil, nil)
+		s.recordMetrics(c, host, c.Writer.Status(), startTime, "bypass")
+		return
+	}
+
 	// Cacheable GET requests
-	cacheKey := host + c.Request.URL.Path
-	if item, found := s.cacheItemRepository.Get(cacheKey); found && time.Now().Before(item.ExpiresAt) {
+	cacheKey := requestCacheKey(cdn, host, c.Request.URL)
+
+	// Slicing CDNs cache objects as fixed-size byte ranges
+	if cdn.SliceSize > 0 {
+		s.serveSliced(c, cdn, cacheKey)
+		s.recordMetrics(c, host, c.Writer.Status(), startTime, "slice")
+		return
+	}
+
+	s.negotiateEncoding(c)
+
+	cacheKey, item, found := s.lookup(cacheKey, c.Request.Header)
+	if found && time.Now().Before(item.ExpiresAt) {
 		metrics.CacheHits.WithLabelValues(host).Inc()
-		s.serveFromFile(c, item)
-		s.recordMetrics(c, host, http.StatusOK, startTime, "hit")
+		d.record(cacheHit, "")
+		s.serveFromFile(c, cdn, item)
+		s.recordMetrics(c, host, c.Writer.Status(), startTime, "hit")
+		return
+	}
+
+	// Within its stale-while-revalidate window the expired item is served
+	// right away and refreshed in the background
+	if found && time.Now().Before(item.StaleUntil) {
+		metrics.StaleServed.WithLabelValues(host, "revalidate").Inc()
+		d.record(cacheStale, "")
+		s.refreshInBackground(c, cdn, cacheKey, item)
+		s.serveStale(c, cdn, item, `110 - "Response is Stale"`)
+		s.recordMetrics(c, host, c.Writer.Status(), startTime, "stale")
 		return
 	}
 
 	metrics.CacheMisses.WithLabelValues(host).Inc()
-	s.fetchAndCache(c, cdn, cacheKey)
+	if found {
+		d.record(cacheExpired, fwdStale)
+	} else {
+		d.record(cacheMiss, fwdURIMiss)
+	}
+	s.fetchAndCache(c, cdn, cacheKey, item)
 	s.recordMetrics(c, host, c.Writer.Status(), startTime, "miss")
 }
 
-func (s *cacheService) fetchAndCache(c *gin.Context, cdn domain.CDN, cacheKey string) {
-	targetURL := "http://" + s.config.MidCacheURL + c.Request.URL.Path
+// fetchAndCache fetches a missed object upstream and caches it. An expired
+// item, when not nil, is revalidated and served if upstream fails. Concurrent
+// misses for the same key are collapsed into a single upstream fetch.
+func (s *cacheService) fetchAndCache(c *gin.Context, cdn domain.CDN, cacheKey string, stale *domain.CacheItem) {
+	f, leader := s.fills.acquire(cacheKey)
+	if !leader {
+		metrics.CollapsedRequests.WithLabelValues(cdn.Domain).Inc()
+		diagnosticsOf(c).collapsed = true
+		if s.followFill(c, cdn, cacheKey, f) {
+			return
+		}
+		// The leader timed out or is not caching the response: fetch without caching
+		diagnosticsOf(c).collapsed = false
+		s.fetch(c, cdn, cacheKey, stale, nil)
+		return
+	}
+	defer s.fills.release(cacheKey, f)
+
+	// Another request may have filled the key while we were acquiring the lock
+	if _, item, found := s.lookup(cacheKey, c.Request.Header); found && time.Now().Before(item.ExpiresAt) {
+		diagnosticsOf(c).record(cacheHit, "")
+		s.serveFromFile(c, cdn, item)
+		return
+	}
+
+	s.fetch(c, cdn, cacheKey, stale, f)
+}
 
-	req, err := http.NewRequest(http.MethodGet, targetURL, nil)
+// fetch forwards the request upstream and streams the response to the client.
+// The response is cached only when f is not nil. A stale item is revalidated
+// with a conditional request and refreshed when upstream answers 304, and is
+// served instead of upstream errors within its stale-if-error window.
+func (s *cacheService) fetch(c *gin.Context, cdn domain.CDN, cacheKey string, stale *domain.CacheItem, f *fill) {
+	req, err := s.newUpstreamRequest(c, cdn)
 	if err != nil {
 		metrics.ErrorsTotal.WithLabelValues(cdn.Domain, "request_creation").Inc()
 		c.String(http.StatusInternalServerError, "Error creating request: %v", err)
 		return
 	}
 
-	// Add headers
-	req.Header.Set("X-Original-Host", cdn.Domain)
-	req.Header.Set("X-Forwarded-Host", c.Request.Host)
-	req.Header.Set("X-Forwarded-For", c.ClientIP())
-
-	originStartTime := time.Now()
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	originDuration := time.Since(originStartTime).Seconds()
+	// Uncached fetches can let upstream answer the client's range directly
+	if f == nil {
+		for _, h := range []string{"Range", "If-Range"} {
+			if v := c.GetHeader(h); v != "" {
+				req.Header.Set(h, v)
+			}
+		}
+	}
+	revalidating := stale != nil && stale.HasValidators()
+	if revalidating {
+		setValidators(req, stale.Header)
+	}
 
+	resp, err := s.doUpstream(cdn, req)
 	if err != nil {
-		metrics.ErrorsTotal.WithLabelValues(cdn.Domain, "origin_request").Inc()
-		metrics.OriginRequestsTotal.WithLabelValues(cdn.Domain, "error").Inc()
-		c.String(http.StatusBadGateway, "Error forwarding request: %v", err)
+		if !s.serveStaleOnError(c, cdn, stale) {
+			c.String(http.StatusBadGateway, "Error forwarding request: %v", err)
+		}
 		return
 	}
-	defer resp.Body.Close()
+	// The body may be replaced by transcode
+	defer func() { _ = resp.Body.Close() }()
 
-	statusCode := strconv.Itoa(resp.StatusCode)
-	metrics.OriginRequestsTotal.WithLabelValues(cdn.Domain, statusCode).Inc()
-	metrics.OriginRequestDuration.WithLabelValues(cdn.Domain, statusCode).Observe(originDuration)
+	diagnosticsOf(c).forwarded(resp.StatusCode)
 
-	body, _ := io.ReadAll(resp.Body)
-	metrics.BytesReceived.WithLabelValues(cdn.Domain).Add(float64(len(body)))
+	if resp.StatusCode >= 500 && s.serveStaleOnError(c, cdn, stale) {
+		return
+	}
+
+	if revalidating {
+		// The cached body is still valid: extend its lifetime without rewriting it
+		if resp.StatusCode == http.StatusNotModified {
+			metrics.Revalidations.WithLabelValues(cdn.Domain, "not_modified").Inc()
+			s.serveFromFile(c, cdn, s.refreshItem(cdn, c.Request, cacheKey, stale, resp.Header))
+			return
+		}
+		metrics.Revalidations.WithLabelValues(cdn.Domain, "modified").Inc()
+	}
+
+	s.transcode(cdn, c.Request.Header, resp)
 
 	// Forward headers to client
 	for k, vals := range resp.Header {
@@ -111,68 +215,415 @@ func (s *cacheService) fetchAndCache(c *gin.Context, cdn domain.CDN, cacheKey st
 		}
 	}
 
-	// Return error responses without caching
-	if resp.StatusCode >= 400 {
-		c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
-		metrics.BytesSent.WithLabelValues(cdn.Domain, "miss").Add(float64(len(body)))
+	// Return uncacheable responses without caching
+	ttl, ttlSource, cacheable := cacheableResponse(cdn, c.Request, resp)
+	if f == nil || !cacheable {
+		cacheStatus := "uncacheable"
+		if f == nil || resp.StatusCode >= 400 {
+			cacheStatus = "miss"
+		}
+		c.Status(resp.StatusCode)
+		n, _ := io.Copy(c.Writer, resp.Body)
+		metrics.BytesReceived.WithLabelValues(cdn.Domain).Add(float64(n))
+		metrics.BytesSent.WithLabelValues(cdn.Domain, cacheStatus).Add(float64(n))
 		return
 	}
 
-	// Check cacheable content type
-	ct := resp.Header.Get("Content-Type")
-	if !isCacheableContentType(ct) {
-		// Just forward, no caching
-		c.Data(resp.StatusCode, ct, body)
-		metrics.BytesSent.WithLabelValues(cdn.Domain, "uncacheable").Add(float64(len(body)))
+	// Cache response while streaming it to the client
+	key := storageKey(cacheKey, resp.Header, c.Request.Header)
+	entry, err := s.createCacheEntry(cdn, key, resp.StatusCode, resp.Header, ttl, ttlSource)
+	if err != nil {
+		metrics.ErrorsTotal.WithLabelValues(cdn.Domain, "cache_write").Inc()
+		c.Status(resp.StatusCode)
+		n, _ := io.Copy(c.Writer, resp.Body)
+		metrics.BytesReceived.WithLabelValues(cdn.Domain).Add(float64(n))
+		metrics.BytesSent.WithLabelValues(cdn.Domain, "miss").Add(float64(n))
 		return
 	}
 
-	// Cache response
-	cacheFile := filepath.Join(s.config.CacheDir, fmt.Sprintf("%x.cache", cacheKey))
-	if err := os.WriteFile(cacheFile, body, 0644); err != nil {
-		metrics.ErrorsTotal.WithLabelValues(cdn.Domain, "cache_write").Inc()
-		c.String(http.StatusInternalServerError, "Error writing cache file: %v", err)
+	diagnosticsOf(c).stored = true
+	f.start(key, resp.StatusCode, resp.Header.Clone(), entry)
+	out := writeStreamHead(c, resp.StatusCode, resp.Header, resp.ContentLength)
+	s.fillFile(cdn, key, resp, entry, ttl, ttlSource, f, out)
+	metrics.BytesSent.WithLabelValues(cdn.Domain, "miss").Add(float64(max(c.Writer.Size(), 0)))
+}
+
+// refreshInBackground revalidates or downloads again an item that is being
+// served stale, unless a fill for the key is already in flight. The upstream
+// request is built before returning since c is not safe to use afterwards.
+func (s *cacheService) refreshInBackground(c *gin.Context, cdn domain.CDN, cacheKey string, stale *domain.CacheItem) {
+	f, leader := s.fills.acquire(cacheKey)
+	if !leader {
 		return
 	}
 
-	// Save metadata
-	item := &domain.CacheItem{
-		FilePath:  cacheFile,
-		Header:    resp.Header.Clone(),
-		ExpiresAt: time.Now().Add(time.Duration(cdn.CacheTTL) * time.Second),
+	req, err := s.newUpstreamRequest(c, cdn)
+	if err != nil {
+		metrics.ErrorsTotal.WithLabelValues(cdn.Domain, "request_creation").Inc()
+		s.fills.release(cacheKey, f)
+		return
+	}
+	revalidating := stale.HasValidators()
+	if revalidating {
+		setValidators(req, stale.Header)
 	}
-	metaFileName := cacheFile + ".json"
-	if metaJSON, err := json.MarshalIndent(item, "", "  "); err == nil {
-		_ = os.WriteFile(metaFileName, metaJSON, 0644)
+	clientReq := c.Request.Clone(context.Background())
+
+	go func() {
+		defer s.fills.release(cacheKey, f)
+
+		resp, err := s.doUpstream(cdn, req)
+		if err != nil {
+			return
+		}
+		// The body may be replaced by transcode
+		defer func() { _ = resp.Body.Close() }()
+
+		if revalidating {
+			if resp.StatusCode == http.StatusNotModified {
+				metrics.Revalidations.WithLabelValues(cdn.Domain, "not_modified").Inc()
+				s.refreshItem(cdn, clientReq, cacheKey, stale, resp.Header)
+				return
+			}
+			metrics.Revalidations.WithLabelValues(cdn.Domain, "modified").Inc()
+		}
+
+		s.transcode(cdn, clientReq.Header, resp)
+
+		// Keep serving the stale item until its window runs out
+		ttl, ttlSource, cacheable := cacheableResponse(cdn, clientReq, resp)
+		if !cacheable {
+			return
+		}
+
+		key := storageKey(cacheKey, resp.Header, clientReq.Header)
+		entry, err := s.createCacheEntry(cdn, key, resp.StatusCode, resp.Header, ttl, ttlSource)
+		if err != nil {
+			metrics.ErrorsTotal.WithLabelValues(cdn.Domain, "cache_write").Inc()
+			return
+		}
+
+		f.start(key, resp.StatusCode, resp.Header.Clone(), entry)
+		s.fillFile(cdn, key, resp, entry, ttl, ttlSource, f, io.Discard)
+	}()
+}
+
+// serveStale serves an expired item flagged with the given Warning.
+func (s *cacheService) serveStale(c *gin.Context, cdn domain.CDN, item *domain.CacheItem, warning string) {
+	diagnosticsOf(c).result = cacheStale
+	c.Header("Warning", warning)
+	s.serveFromFile(c, cdn, item)
+}
+
+// serveStaleOnError serves stale in place of a failed upstream response when
+// it is still within its stale-if-error window.
+func (s *cacheService) serveStaleOnError(c *gin.Context, cdn domain.CDN, stale *domain.CacheItem) bool {
+	if stale == nil || !time.Now().Before(stale.StaleIfErrorUntil) {
+		return false
+	}
+
+	metrics.StaleServed.WithLabelValues(cdn.Domain, "error").Inc()
+	s.serveStale(c, cdn, stale, `111 - "Revalidation Failed"`)
+	return true
+}
+
+// createCacheEntry starts writing the entry of cacheKey for a response with
+// status and header. Entries only become visible once
+// complete so that readers of the previous entry, such as stale responses,
+// never see a partial one, and a crash never leaves one behind.
+func (s *cacheService) createCacheEntry(cdn domain.CDN, cacheKey string, status int, header http.Header, ttl time.Duration, ttlSource string) (*repository.EntryWriter, error) {
+	item := newCacheItem(cdn, s.cacheFilePath(cacheKey), status, header.Clone(), ttl, ttlSource)
+	item.Key = cacheKey
+	return s.cacheItemRepository.CreateEntry(item)
+}
+
+func (s *cacheService) cacheFilePath(cacheKey string) string {
+	return s.cacheItemRepository.FilePath(cacheKey)
+}
+
+// fillFile streams resp into entry, which was created by createCacheEntry,
+// while copying it to out, and caches the item once the whole body is written.
+func (s *cacheService) fillFile(cdn domain.CDN, cacheKey string, resp *http.Response, entry *repository.EntryWriter, ttl time.Duration, ttlSource string, f *fill, out io.Writer) {
+	received, err := streamFill(out, f.track(entry), resp.Body)
+	metrics.BytesReceived.WithLabelValues(cdn.Domain).Add(float64(received))
+
+	if err == nil && resp.ContentLength >= 0 && received != resp.ContentLength {
+		err = io.ErrUnexpectedEOF
+	}
+
+	// Freshness counts from when the body is complete
+	item := newCacheItem(cdn, s.cacheFilePath(cacheKey), resp.StatusCode, resp.Header.Clone(), ttl, ttlSource)
+	item.Key = cacheKey
+	if err == nil {
+		err = entry.Commit(item)
+	} else {
+		entry.Abort()
+	}
+	if err != nil {
+		metrics.ErrorsTotal.WithLabelValues(cdn.Domain, "cache_write").Inc()
+		f.finish(err)
+		return
 	}
 
+	s.storeItem(cacheKey, item)
+}
+
+// refreshItem extends the lifetime of a revalidated item without touching its
+// body, updating the stored headers with those of the 304 response to req and
+// recomputing its freshness from them.
+func (s *cacheService) refreshItem(cdn domain.CDN, req *http.Request, cacheKey string, stale *domain.CacheItem, header http.Header) *domain.CacheItem {
+	merged := mergeNotModified(stale.Header, header)
+	rule := matchCacheRule(cdn, req, merged)
+	ttl, ttlSource, _ := freshness(cachePolicy(cdn, rule, stale.StatusCode()), merged)
+
+	item := newCacheItem(cdn, stale.FilePath, stale.StatusCode(), merged, ttl, ttlSource)
+	item.Key = cacheKey
+	if err := s.cacheItemRepository.UpdateEntry(item); err != nil {
+		metrics.ErrorsTotal.WithLabelValues(cdn.Domain, "cache_write").Inc()
+	}
+	s.storeItem(cacheKey, item)
+	return item
+}
+
+// storeItem indexes an item whose entry has been written. Variants also
+// refresh the Vary index of their primary key, an entry without a body.
+func (s *cacheService) storeItem(cacheKey string, item *domain.CacheItem) {
+	item.Key = cacheKey
 	s.cacheItemRepository.Set(cacheKey, item)
 
-	// Return response
-	c.Data(resp.StatusCode, ct, body)
-	metrics.BytesSent.WithLabelValues(cdn.Domain, "miss").Add(float64(len(body)))
+	if primary := primaryKey(cacheKey); primary != cacheKey {
+		index := *item
+		index.Key = primary
+		index.FilePath = s.cacheFilePath(primary)
+		if err := s.cacheItemRepository.WriteEntry(&index, nil); err == nil {
+			s.cacheItemRepository.Set(primary, &index)
+		}
+	}
+}
+
+// newUpstreamRequest builds the GET request used to fill the cache. Its URL
+// is relative, doUpstream resolves it against the upstream node it goes to.
+func (s *cacheService) newUpstreamRequest(c *gin.Context, cdn domain.CDN) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, c.Request.URL.RequestURI(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Forward the client's headers so upstream can select the variant to send,
+	// except for the ones the cache handles itself
+	req.Header = c.Request.Header.Clone()
+	for _, h := range cacheHandledHeaders {
+		req.Header.Del(h)
+	}
+
+	// Add headers
+	req.Header.Set("X-Original-Host", cdn.Domain)
+	req.Header.Set("X-Forwarded-Host", c.Request.Host)
+	req.Header.Set("X-Forwarded-For", c.ClientIP())
+
+	return req, nil
+}
+
+// cacheHandledHeaders are the request headers not forwarded upstream on cache
+// fills: hop-by-hop headers, range and conditional headers which the cache
+// answers from the stored object, and Accept-Encoding since content codings
+// are negotiated by the cache. Without it the HTTP client asks for gzip itself
+// and transparently decodes the response.
+var cacheHandledHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+	"Range", "If-Range", "If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since",
+	"Accept-Encoding",
+}
+
+// doUpstream sends req to the upstream nodes picked for it in turn, until one
+// answers, and records the origin request metrics.
+func (s *cacheService) doUpstream(cdn domain.CDN, req *http.Request) (*http.Response, error) {
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		// Redirects are passed on, and cached, rather than followed
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	err := errNoUpstream
+	nodes := s.upstreams.Nodes(cdn, req)
+	for i, node := range nodes {
+		target, parseErr := url.Parse(node + req.URL.RequestURI())
+		if parseErr != nil {
+			err = parseErr
+			continue
+		}
+		release, ok := s.upstreams.Acquire(cdn, node)
+		if !ok {
+			err = errUpstreamBusy
+			continue
+		}
+		attempt := req.Clone(req.Context())
+		attempt.URL, attempt.Host = target, ""
+
+		originStartTime := time.Now()
+		var resp *http.Response
+		resp, err = client.Do(attempt)
+		originDuration := time.Since(originStartTime).Seconds()
+
+		if err != nil {
+			release()
+			metrics.ErrorsTotal.WithLabelValues(cdn.Domain, "origin_request").Inc()
+			metrics.OriginRequestsTotal.WithLabelValues(cdn.Domain, "error").Inc()
+			s.upstreams.Failed(cdn, node)
+			// A node that was reached may have acted on the request
+			if !isDialError(err) && !resendable(req) {
+				return nil, err
+			}
+			continue
+		}
+
+		statusCode := strconv.Itoa(resp.StatusCode)
+		metrics.OriginRequestsTotal.WithLabelValues(cdn.Domain, statusCode).Inc()
+		metrics.OriginRequestDuration.WithLabelValues(cdn.Domain, statusCode).Observe(originDuration)
+
+		// The last node's answer is used whatever it is, as are the answers
+		// to requests that cannot be sent again
+		if !s.upstreams.Answered(cdn, node, resp.StatusCode) && i < len(nodes)-1 && resendable(req) {
+			_ = resp.Body.Close()
+			release()
+			err = fmt.Errorf("upstream answered %s", resp.Status)
+			continue
+		}
+		resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+
+		return resp, nil
+	}
+	return nil, err
+}
+
+// isDialError reports whether err is a failure to connect, the request not
+// having been sent.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// resendable reports whether req may be sent to another node once a node
+// received it: it has no body to replay and repeating it has no further effect.
+func resendable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// releasingBody releases the upstream request it belongs to once closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// followFill serves the response from another request's in-flight fill. It
+// returns false when the caller has to fetch the object itself.
+func (s *cacheService) followFill(c *gin.Context, cdn domain.CDN, cacheKey string, f *fill) bool {
+	if !f.wait(s.config.CacheLockTimeoutDuration) {
+		metrics.CacheLockTimeouts.WithLabelValues(cdn.Domain).Inc()
+		return false
+	}
+
+	// Followers asking for another variant than the leader's fetch their own
+	if f.cacheable && storageKey(cacheKey, f.header, c.Request.Header) != f.key {
+		return false
+	}
+
+	if f.cacheable {
+		if body, err := f.entry.OpenPartial(); err == nil {
+			defer body.Close()
+
+			for k, vals := range f.header {
+				for _, v := range vals {
+					c.Writer.Header().Add(k, v)
+				}
+			}
+
+			out := writeStreamHead(c, f.status, f.header, contentLength(f.header))
+			_, _ = io.Copy(out, f.reader(body))
+			metrics.BytesSent.WithLabelValues(cdn.Domain, "collapsed").Add(float64(max(c.Writer.Size(), 0)))
+			return true
+		}
+		// The body has already been moved into place: wait for it to be indexed
+		_ = f.result()
+	}
+
+	// The leader did not stream a new body, but it may have revalidated the item
+	_, item, found := s.lookup(cacheKey, c.Request.Header)
+	if !found || !time.Now().Before(item.ExpiresAt) {
+		return false
+	}
+	s.serveFromFile(c, cdn, item)
+	return true
 }
 
-func (s *cacheService) serveFromFile(c *gin.Context, item *domain.CacheItem) {
-	body, err := os.ReadFile(item.FilePath)
+func (s *cacheService) serveFromFile(c *gin.Context, cdn domain.CDN, item *domain.CacheItem) {
+	status := item.StatusCode()
+	diagnosticsOf(c).served(item)
+
+	// Conditional requests are answered from the cached metadata alone
+	if status == http.StatusOK && notModified(c.Request, item.Header) {
+		for k, vals := range item.Header {
+			for _, v := range vals {
+				c.Writer.Header().Add(k, v)
+			}
+		}
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	entry, tier, err := s.cacheItemRepository.Open(item)
 	if err != nil {
 		host := c.Request.Host
 		metrics.ErrorsTotal.WithLabelValues(host, "cache_read").Inc()
 		c.String(http.StatusInternalServerError, "Error reading cache file: %v", err)
 		return
 	}
+	defer entry.Close()
+	if diagnosticsOf(c).result == cacheHit {
+		metrics.CacheTierHits.WithLabelValues(cdn.Domain, tier).Inc()
+	}
 
 	for k, vals := range item.Header {
 		for _, v := range vals {
 			c.Writer.Header().Add(k, v)
 		}
 	}
-	c.Data(http.StatusOK, item.Header.Get("Content-Type"), body)
-	metrics.BytesSent.WithLabelValues(c.Request.Host, "hit").Add(float64(len(body)))
+
+	// Redirects and error pages are replayed as they were received
+	if status != http.StatusOK {
+		c.Status(status)
+		n, _ := io.Copy(c.Writer, entry)
+		metrics.BytesSent.WithLabelValues(c.Request.Host, "hit").Add(float64(n))
+		return
+	}
+
+	// ServeContent sets the length of full and partial responses itself
+	c.Writer.Header().Del("Content-Length")
+
+	// ServeContent answers Range, If-Range and multi-range requests from the cached file
+	modTime, _ := http.ParseTime(item.Header.Get("Last-Modified"))
+	http.ServeContent(c.Writer, c.Request, "", modTime, entry)
+	metrics.BytesSent.WithLabelValues(c.Request.Host, "hit").Add(float64(max(c.Writer.Size(), 0)))
 }
 
 func (s *cacheService) proxyRequest(c *gin.Context, origin string) {
-	targetURL := origin + c.Request.URL.Path
+	targetURL := origin + c.Request.URL.RequestURI()
 
 	req, err := http.NewRequest(c.Request.Method, targetURL, c.Request.Body)
 	if err != nil {
@@ -192,6 +643,7 @@ func (s *cacheService) proxyRequest(c *gin.Context, origin string) {
 		return
 	}
 	defer resp.Body.Close()
+	diagnosticsOf(c).forwarded(resp.StatusCode)
 
 	// Copy headers back
 	for k, vals := range resp.Header {
@@ -200,9 +652,9 @@ func (s *cacheService) proxyRequest(c *gin.Context, origin string) {
 		}
 	}
 
-	body, _ := io.ReadAll(resp.Body)
-	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
-	metrics.BytesSent.WithLabelValues(c.Request.Host, "proxy").Add(float64(len(body)))
+	c.Status(resp.StatusCode)
+	n, _ := io.Copy(c.Writer, resp.Body)
+	metrics.BytesSent.WithLabelValues(c.Request.Host, "proxy").Add(float64(n))
 }
 
 func (s *cacheService) recordMetrics(c *gin.Context, host string, statusCode int, startTime time.Time, cacheStatus string) {
@@ -213,26 +665,33 @@ func (s *cacheService) recordMetrics(c *gin.Context, host string, statusCode int
 	metrics.RequestDuration.WithLabelValues(host, c.Request.Method, status).Observe(duration)
 }
 
-func isCacheableContentType(contentType string) bool {
-	if contentType == "" {
-		return false
-	}
-
-	cacheable := []string{
-		"image/",
-		"font/",
-		"text/css",
-		"text/javascript",
-		"application/javascript",
-		"application/x-javascript",
-		"video/",
-		"audio/",
-	}
-
-	for _, prefix := range cacheable {
-		if strings.HasPrefix(contentType, prefix) {
-			return true
+// streamFill copies src to the client and the cache file at the same time
+// through a fixed-size buffer, so memory stays bounded regardless of object
+// size. A client that goes away does not abort the fill; a failing upstream
+// read or file write does.
+func streamFill(client io.Writer, file io.Writer, src io.Reader) (received int64, err error) {
+	buf := make([]byte, streamBufferSize)
+	clientOK := true
+
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			if _, err := file.Write(buf[:n]); err != nil {
+				return received, err
+			}
+			received += int64(n)
+
+			if clientOK {
+				if _, err := client.Write(buf[:n]); err != nil {
+					clientOK = false
+				}
+			}
+		}
+		if readErr == io.EOF {
+			return received, nil
+		}
+		if readErr != nil {
+			return received, readErr
 		}
 	}
-	return false
 }
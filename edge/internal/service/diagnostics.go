@@ -0,0 +1,237 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+// X-Cache values
+const (
+	cacheHit     = "HIT"     // served from the cache
+	cacheMiss    = "MISS"    // not cached, fetched upstream
+	cacheStale   = "STALE"   // served from the cache after expiring
+	cacheBypass  = "BYPASS"  // not looked up in the cache
+	cacheExpired = "EXPIRED" // expired and revalidated or fetched again upstream
+)
+
+// Cache-Status (RFC 9211) fwd values
+const (
+	fwdURIMiss = "uri-miss" // the cache had no response for the URI
+	fwdStale   = "stale"    // the cached response was expired
+	fwdBypass  = "bypass"   // a cache rule bypasses the cache
+	fwdMethod  = "method"   // the request method is not cached
+)
+
+// debugHeader unlocks the debug headers of CDNs hiding them. Its value is a
+// Unix expiry time and the hex HMAC-SHA256 of "host:expiry" under the
+// DEBUG_HEADER_SECRET, separated by a colon.
+const debugHeader = "X-CDN-Debug"
+
+// tokenChars are the characters besides letters allowed in structured field
+// tokens
+const tokenChars = "0123456789!#$%&'*+-.^_`|~:/"
+
+// debugHeaders are the diagnostic response headers of CDNs that hide them.
+var debugHeaders = []string{"X-Cache", "Cache-Status", "Via", "X-Served-By"}
+
+// diagnostics wraps the response writer of a cache request to add the X-Cache,
+// Cache-Status, Age, Via and X-Served-By headers just before the response
+// header is written, once the way the cache handled the request is known.
+// Upstream entries of Cache-Status, Via and X-Served-By are kept in front of
+// the node's own when the response was fetched for this request.
+type diagnostics struct {
+	gin.ResponseWriter
+	name    string
+	proto   string // received protocol for Via
+	hide    bool
+	written bool
+
+	result    string            // X-Cache value, empty when the cache was not involved
+	fwd       string            // why the request went upstream, if it did
+	fwdStatus int               // status upstream answered with
+	stored    bool              // the response is being stored
+	collapsed bool              // the response came from another request's fill
+	item      *domain.CacheItem // cached item served, if any
+}
+
+// startDiagnostics wraps the response writer of c.
+func (s *cacheService) startDiagnostics(c *gin.Context) *diagnostics {
+	d := &diagnostics{
+		ResponseWriter: c.Writer,
+		name:           s.config.AppName,
+		proto:          strconv.Itoa(c.Request.ProtoMajor) + "." + strconv.Itoa(c.Request.ProtoMinor),
+	}
+	c.Writer = d
+	return d
+}
+
+// diagnosticsOf returns the diagnostics of a cache request. Requests without
+// any get one that is never written.
+func diagnosticsOf(c *gin.Context) *diagnostics {
+	if d, ok := c.Writer.(*diagnostics); ok {
+		return d
+	}
+	return &diagnostics{}
+}
+
+// hideUnlessDebug hides the debug headers of a CDN asking for it unless the
+// request carries a debug header valid for its domain.
+func (d *diagnostics) hideUnlessDebug(cdn domain.CDN, req *http.Request, secret string) {
+	d.hide = cdn.HideDebugHeaders && !validDebugHeader(req.Header.Get(debugHeader), cdn.Domain, secret)
+}
+
+// record sets how the cache handled the request: its X-Cache value and, when
+// it went upstream, why.
+func (d *diagnostics) record(result string, fwd string) {
+	d.result, d.fwd = result, fwd
+	d.fwdStatus, d.stored, d.collapsed, d.item = 0, false, false, nil
+}
+
+// forwarded records the status upstream answered the request with.
+func (d *diagnostics) forwarded(status int) {
+	d.fwdStatus = status
+}
+
+// served records that the response is the cached item.
+func (d *diagnostics) served(item *domain.CacheItem) {
+	d.item = item
+}
+
+func (d *diagnostics) WriteHeaderNow() {
+	d.writeHeaders()
+	d.ResponseWriter.WriteHeaderNow()
+}
+
+func (d *diagnostics) Write(data []byte) (int, error) {
+	d.writeHeaders()
+	return d.ResponseWriter.Write(data)
+}
+
+func (d *diagnostics) WriteString(s string) (int, error) {
+	d.writeHeaders()
+	return d.ResponseWriter.WriteString(s)
+}
+
+func (d *diagnostics) Flush() {
+	d.writeHeaders()
+	d.ResponseWriter.Flush()
+}
+
+func (d *diagnostics) writeHeaders() {
+	if d.written || d.ResponseWriter.Written() {
+		return
+	}
+	d.written = true
+	h := d.Header()
+
+	// Cached items are stored without diagnostics, which only describe the
+	// request that fetched them
+	var cacheStatus, via, servedBy []string
+	if d.item == nil {
+		cacheStatus, via, servedBy = h.Values("Cache-Status"), h.Values("Via"), h.Values("X-Served-By")
+	}
+	for _, name := range debugHeaders {
+		h.Del(name)
+	}
+	if d.item != nil {
+		h.Set("Age", strconv.FormatInt(currentAge(d.item.Header), 10))
+	}
+	if d.hide {
+		return
+	}
+
+	if d.result != "" {
+		h.Set("X-Cache", d.result)
+		h.Set("Cache-Status", strings.Join(append(cacheStatus, d.cacheStatusEntry()), ", "))
+	}
+	h.Set("Via", strings.Join(append(via, d.proto+" "+d.name), ", "))
+	h.Set("X-Served-By", strings.Join(append(servedBy, d.name), ", "))
+}
+
+// cacheStatusEntry returns the Cache-Status list member of this node.
+func (d *diagnostics) cacheStatusEntry() string {
+	entry := []string{cacheStatusName(d.name)}
+	if d.fwd == "" && d.item != nil {
+		entry = append(entry, "hit")
+	}
+	if d.fwd != "" {
+		entry = append(entry, "fwd="+d.fwd)
+	}
+	if d.fwdStatus != 0 {
+		entry = append(entry, "fwd-status="+strconv.Itoa(d.fwdStatus))
+	}
+	if d.item != nil {
+		// Negative for stale responses
+		ttl := time.Until(d.item.ExpiresAt).Truncate(time.Second)
+		entry = append(entry, "ttl="+strconv.Itoa(int(ttl.Seconds())))
+	}
+	if d.stored {
+		entry = append(entry, "stored")
+	}
+	if d.collapsed {
+		entry = append(entry, "collapsed")
+	}
+	return strings.Join(entry, "; ")
+}
+
+// cacheStatusName returns name as a structured field token, or as a string
+// when it is not a valid token.
+func cacheStatusName(name string) string {
+	if name == "" {
+		return `""`
+	}
+	for i, r := range name {
+		alpha := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+		if i == 0 && !alpha && r != '*' || !alpha && !strings.ContainsRune(tokenChars, r) {
+			return strconv.Quote(name)
+		}
+	}
+	return name
+}
+
+// currentAge returns the age in seconds of a cached response with header,
+// from its Date or the Age it was received with, whichever is older.
+func currentAge(header http.Header) int64 {
+	age, _ := strconv.ParseInt(header.Get("Age"), 10, 64)
+	if date, err := http.ParseTime(header.Get("Date")); err == nil {
+		age = max(age, int64(time.Since(date).Seconds()))
+	}
+	return max(age, 0)
+}
+
+// stripDiagnostics removes the diagnostics of the request that fetched a
+// response from the headers it is stored with.
+func stripDiagnostics(header http.Header) {
+	for _, name := range debugHeaders {
+		header.Del(name)
+	}
+}
+
+// validDebugHeader reports whether value is an unexpired debug header signed
+// for host with secret.
+func validDebugHeader(value string, host string, secret string) bool {
+	expiry, signature, ok := strings.Cut(value, ":")
+	if secret == "" || !ok {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return false
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(host + ":" + expiry))
+	return hmac.Equal(got, mac.Sum(nil))
+}
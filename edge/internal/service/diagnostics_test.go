@@ -0,0 +1,200 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidDebugHeader(t *testing.T) {
+	const secret = "s3cret"
+	sign := func(host string, expiry int64, secret string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		fmt.Fprintf(mac, "%s:%d", host, expiry)
+		return fmt.Sprintf("%d:%s", expiry, hex.EncodeToString(mac.Sum(nil)))
+	}
+	later := time.Now().Add(time.Hour).Unix()
+
+	for _, tc := range []struct {
+		name   string
+		value  string
+		secret string
+		want   bool
+	}{
+		{"valid", sign("example.com", later, secret), secret, true},
+		{"expired", sign("example.com", time.Now().Add(-time.Minute).Unix(), secret), secret, false},
+		{"other host", sign("other.example.com", later, secret), secret, false},
+		{"other secret", sign("example.com", later, "other"), secret, false},
+		{"no secret configured", sign("example.com", later, ""), "", false},
+		{"missing", "", secret, false},
+		{"no signature", fmt.Sprint(later), secret, false},
+		{"bad expiry", "soon:" + strings.Repeat("0", 64), secret, false},
+		{"bad signature", fmt.Sprintf("%d:zz", later), secret, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := validDebugHeader(tc.value, "example.com", tc.secret); got != tc.want {
+				t.Errorf("validDebugHeader(%q) = %v, want %v", tc.value, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDiagnosticsHeaders(t *testing.T) {
+	const secret = "s3cret"
+	mac := hmac.New(sha256.New, []byte(secret))
+	expiry := fmt.Sprint(time.Now().Add(time.Hour).Unix())
+	mac.Write([]byte("example.com:" + expiry))
+	debug := expiry + ":" + hex.EncodeToString(mac.Sum(nil))
+
+	upstream := http.Header{
+		"Cache-Status": {"upstream1; fwd=uri-miss; fwd-status=200; stored"},
+		"Via":          {"1.1 upstream1"},
+		"X-Served-By":  {"upstream1"},
+	}
+	cached := func() *domain.CacheItem {
+		return &domain.CacheItem{
+			Header:    http.Header{"Date": {time.Now().Add(-10 * time.Second).Format(http.TimeFormat)}},
+			ExpiresAt: time.Now().Add(time.Minute + time.Second/2),
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		hide   bool
+		debug  string
+		handle func(d *diagnostics)
+		want   http.Header // nil values must be absent
+	}{
+		{
+			name: "fetched upstream",
+			handle: func(d *diagnostics) {
+				d.record(cacheMiss, fwdURIMiss)
+				d.forwarded(http.StatusOK)
+				d.stored = true
+			},
+			want: http.Header{
+				"X-Cache":      {cacheMiss},
+				"Cache-Status": {"upstream1; fwd=uri-miss; fwd-status=200; stored, node1; fwd=uri-miss; fwd-status=200; stored"},
+				"Via":          {"1.1 upstream1, 1.1 node1"},
+				"X-Served-By":  {"upstream1, node1"},
+				"Age":          nil,
+			},
+		},
+		{
+			name: "collapsed",
+			handle: func(d *diagnostics) {
+				d.record(cacheMiss, fwdURIMiss)
+				d.collapsed = true
+			},
+			want: http.Header{"Cache-Status": {"upstream1; fwd=uri-miss; fwd-status=200; stored, node1; fwd=uri-miss; collapsed"}},
+		},
+		{
+			name: "served from cache",
+			handle: func(d *diagnostics) {
+				d.record(cacheHit, "")
+				d.served(cached())
+			},
+			want: http.Header{
+				"X-Cache":      {cacheHit},
+				"Cache-Status": {"node1; hit; ttl=60"},
+				"Via":          {"1.1 node1"},
+				"X-Served-By":  {"node1"},
+				"Age":          {"10"},
+			},
+		},
+		{
+			name: "not cached",
+			handle: func(d *diagnostics) {
+				d.record(cacheBypass, fwdMethod)
+			},
+			want: http.Header{"Cache-Status": {"upstream1; fwd=uri-miss; fwd-status=200; stored, node1; fwd=method"}},
+		},
+		{
+			name: "hidden",
+			hide: true,
+			handle: func(d *diagnostics) {
+				d.record(cacheHit, "")
+				d.served(cached())
+			},
+			want: http.Header{"X-Cache": nil, "Cache-Status": nil, "Via": nil, "X-Served-By": nil, "Age": {"10"}},
+		},
+		{
+			name:  "hidden but debugging",
+			hide:  true,
+			debug: debug,
+			handle: func(d *diagnostics) {
+				d.record(cacheHit, "")
+			},
+			want: http.Header{"X-Cache": {cacheHit}, "Via": {"1.1 upstream1, 1.1 node1"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &cacheService{config: &config.Config{AppName: "node1", DebugHeaderSecret: secret}}
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodGet, "http://example.com/a.png", nil)
+			if tc.debug != "" {
+				c.Request.Header.Set(debugHeader, tc.debug)
+			}
+
+			d := s.startDiagnostics(c)
+			d.hideUnlessDebug(domain.CDN{Domain: "example.com", HideDebugHeaders: tc.hide}, c.Request, secret)
+			tc.handle(d)
+			// Responses fetched upstream carry its headers
+			if d.item == nil {
+				for k, v := range upstream {
+					c.Writer.Header()[k] = v
+				}
+			}
+			c.String(http.StatusOK, "body")
+
+			for name, want := range tc.want {
+				if got := rec.Header().Values(name); strings.Join(got, ", ") != strings.Join(want, ", ") || (want == nil) != (got == nil) {
+					t.Errorf("%s %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheStatusThroughTiers(t *testing.T) {
+	// Upstream answers like a cache in front of the origin
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Status", "upstream1; fwd=uri-miss; fwd-status=200; stored")
+		w.Header().Set("Via", "1.1 upstream1")
+		_, _ = io.WriteString(w, "body")
+	}))
+	t.Cleanup(upstream.Close)
+
+	cdn := domain.CDN{Domain: "example.com", Origin: upstream.URL, CacheTTL: 60, IsActive: true}
+	router := newTestCacheRouter(t, cdn, upstream)
+	get := func() []string {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/a.png", nil))
+		return strings.Split(rec.Header().Get("Cache-Status"), ", ")
+	}
+
+	// The node closest to the origin comes first (RFC 9211)
+	entries := get()
+	if len(entries) != 2 || entries[0] != "upstream1; fwd=uri-miss; fwd-status=200; stored" || !strings.HasSuffix(entries[1], "; fwd=uri-miss; fwd-status=200; stored") {
+		t.Errorf("Cache-Status of a miss %q, want the upstream entry then this node's", entries)
+	}
+
+	// Hits only describe this node, the stored response is kept without them
+	entries = get()
+	if len(entries) != 1 || !strings.Contains(entries[0], "; hit; ttl=") {
+		t.Errorf("Cache-Status of a hit %q, want this node's hit alone", entries)
+	}
+}
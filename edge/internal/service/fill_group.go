@@ -0,0 +1,177 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/repository"
+)
+
+// fill tracks an in-flight upstream fetch for a single cache key. The leader
+// writes the body to the cache entry while followers tail the same entry.
+type fill struct {
+	ready     chan struct{}
+	readyOnce sync.Once
+	cacheable bool
+	key       string // the key the response is cached under
+	status    int
+	header    http.Header
+	entry     *repository.EntryWriter
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	written int64
+	done    bool
+	err     error
+}
+
+type fillGroup struct {
+	mu    sync.Mutex
+	fills map[string]*fill
+}
+
+func newFillGroup() *fillGroup {
+	return &fillGroup{
+		fills: make(map[string]*fill),
+	}
+}
+
+// acquire returns the in-flight fill for key, creating it if needed.
+// leader is true when the caller created the fill and must fetch upstream.
+func (g *fillGroup) acquire(key string) (f *fill, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if f, ok := g.fills[key]; ok {
+		return f, false
+	}
+
+	f = &fill{ready: make(chan struct{})}
+	f.cond = sync.NewCond(&f.mu)
+	g.fills[key] = f
+	return f, true
+}
+
+// release finishes the fill and removes it so the next miss starts a new one.
+func (g *fillGroup) release(key string, f *fill) {
+	f.finish(nil)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.fills[key] == f {
+		delete(g.fills, key)
+	}
+}
+
+// start announces that the body of the response cached under key is being
+// written to entry.
+func (f *fill) start(key string, status int, header http.Header, entry *repository.EntryWriter) {
+	f.readyOnce.Do(func() {
+		f.cacheable = true
+		f.key = key
+		f.status = status
+		f.header = header
+		f.entry = entry
+		close(f.ready)
+	})
+}
+
+// finish marks the fill as complete. Only the first call has an effect.
+func (f *fill) finish(err error) {
+	// Followers still waiting for the response head fall back to their own fetch
+	f.readyOnce.Do(func() { close(f.ready) })
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.done {
+		return
+	}
+	f.done = true
+	f.err = err
+	f.cond.Broadcast()
+}
+
+// wait blocks until the fill is ready or timeout elapses. It returns false
+// on timeout.
+func (f *fill) wait(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-f.ready:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// result blocks until the fill is complete and returns its error.
+func (f *fill) result() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for !f.done {
+		f.cond.Wait()
+	}
+	return f.err
+}
+
+// track wraps the cache entry so every write is published to followers.
+func (f *fill) track(w io.Writer) io.Writer {
+	return &fillWriter{f: f, w: w}
+}
+
+// reader returns a reader over the body of the cache entry that blocks until
+// the leader has written more data or finished.
+func (f *fill) reader(body io.ReaderAt) io.Reader {
+	return &fillReader{f: f, body: body}
+}
+
+type fillWriter struct {
+	f *fill
+	w io.Writer
+}
+
+func (w *fillWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+
+	w.f.mu.Lock()
+	w.f.written += int64(n)
+	w.f.cond.Broadcast()
+	w.f.mu.Unlock()
+
+	return n, err
+}
+
+type fillReader struct {
+	f      *fill
+	body   io.ReaderAt
+	offset int64
+}
+
+func (r *fillReader) Read(p []byte) (int, error) {
+	r.f.mu.Lock()
+	for r.offset >= r.f.written && !r.f.done {
+		r.f.cond.Wait()
+	}
+	written, err := r.f.written, r.f.err
+	r.f.mu.Unlock()
+
+	if r.offset >= written {
+		if err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+
+	if remaining := written - r.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := r.body.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
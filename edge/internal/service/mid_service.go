@@ -2,6 +2,7 @@ package service
 
 import (
 	"log"
+	"slices"
 	"time"
 
 	"github.com/AmirAghaee/go-cdn-stack/edge/internal/client"
@@ -15,27 +16,36 @@ type MidServiceInterface interface {
 }
 
 type midService struct {
-	midClient     client.MidClientInterface
-	config        *config.Config
-	cdnRepository repository.CdnRepositoryInterface
-	service       string
-	instance      string
-	version       string
+	midClient       client.MidClientInterface
+	config          *config.Config
+	cdnRepository   repository.CdnRepositoryInterface
+	midRepository   repository.MidRepositoryInterface
+	purgeService    PurgeServiceInterface
+	prefetchService PrefetchServiceInterface
+	service         string
+	instance        string
+	version         string
 }
 
 func NewMidService(
 	midClient client.MidClientInterface,
 	cdnRepo repository.CdnRepositoryInterface,
+	midRepo repository.MidRepositoryInterface,
+	purgeService PurgeServiceInterface,
+	prefetchService PrefetchServiceInterface,
 	config *config.Config,
 	service, instance, version string,
 ) MidServiceInterface {
 	return &midService{
-		midClient:     midClient,
-		config:        config,
-		cdnRepository: cdnRepo,
-		service:       service,
-		instance:      instance,
-		version:       version,
+		midClient:       midClient,
+		config:          config,
+		cdnRepository:   cdnRepo,
+		midRepository:   midRepo,
+		purgeService:    purgeService,
+		prefetchService: prefetchService,
+		service:         service,
+		instance:        instance,
+		version:         version,
 	}
 }
 
@@ -53,21 +63,52 @@ func (s *midService) StartSubmitHeartbeat() {
 				Version:   s.version,
 			}
 
-			cdnListVersion, err := s.midClient.Submit(edge)
+			resp, err := s.midClient.Submit(edge)
 			if err != nil {
 				log.Printf("failed to submit heartbeat: %v\n", err)
-				return
+				continue
 			}
 
-			if cdnListVersion != s.cdnRepository.GetVersion() {
+			// The mid list follows the one advertised by the mids, if any
+			if len(resp.Mids) > 0 && !slices.Equal(resp.Mids, s.midRepository.GetAll()) {
+				s.midRepository.Set(resp.Mids)
+				log.Printf("mid list updated: %v\n", resp.Mids)
+			}
+
+			if resp.CdnListVersion != s.cdnRepository.GetVersion() {
 				cdns, err := s.midClient.GetCdns()
 				if err != nil {
 					log.Printf("failed to get cdn list: %s\n", err)
 				}
-				s.cdnRepository.Set(cdns, cdnListVersion)
+				s.cdnRepository.Set(cdns, resp.CdnListVersion)
 				log.Print(cdns)
 			}
 
+			// Jobs stay pending on the mid until they are reported
+			for _, job := range resp.Purges {
+				result := domain.PurgeResult{
+					JobID:     job.ID,
+					Service:   s.service,
+					Instance:  s.instance,
+					Status:    domain.PurgeStatusDone,
+					Purged:    s.purgeService.Purge(job),
+					Timestamp: time.Now().UTC(),
+				}
+				if err := s.midClient.ReportPurge(result); err != nil {
+					log.Printf("failed to report purge %s: %v\n", job.ID, err)
+				}
+			}
+
+			// Prefetch jobs run in the background and report their progress as they go
+			s.prefetchService.Retain(resp.Prefetches)
+			for _, job := range resp.Prefetches {
+				s.prefetchService.Start(job, func(result domain.PrefetchResult) {
+					if err := s.midClient.ReportPrefetch(result); err != nil {
+						log.Printf("failed to report prefetch %s: %v\n", result.JobID, err)
+					}
+				})
+			}
+
 		}
 	}()
 }
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/metrics"
+)
+
+const (
+	prefetchReportInterval = time.Second
+	prefetchMaxErrors      = 10 // failures reported per job, the rest are only counted
+)
+
+// prefetcher warms the cache by requesting URLs from the node's own cache
+// listener, so they are looked up, fetched and stored like client requests.
+type prefetcher struct {
+	client    *http.Client
+	cacheURL  string
+	encodings []string // Accept-Encoding of the variants warmed, none sends no header
+}
+
+func newPrefetcher(cacheURL string, encodings []string) *prefetcher {
+	return &prefetcher{
+		client: &http.Client{
+			Timeout: 10 * time.Minute,
+			// Cached redirects are warmed as they are, not their targets
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		cacheURL:  cacheURL,
+		encodings: encodings,
+	}
+}
+
+// run fetches the paths of job, at most concurrency at once, and calls report
+// with the progress about once a second while running and once done.
+func (p *prefetcher) run(job domain.PrefetchJob, concurrency int, report func(domain.PrefetchResult)) {
+	result := domain.PrefetchResult{
+		JobID:  job.ID,
+		Status: domain.PrefetchStatusRunning,
+		Total:  len(job.Paths),
+	}
+	report(result)
+
+	var mu sync.Mutex
+	lastReport := time.Now()
+	paths := make(chan string)
+
+	var wg sync.WaitGroup
+	for range max(concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				err := p.fetch(job.Domain, path)
+
+				// Reports are sent under the lock so they arrive in order
+				mu.Lock()
+				if err != nil {
+					result.Failed++
+					if len(result.Errors) < prefetchMaxErrors {
+						result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", path, err))
+					}
+				} else {
+					result.Fetched++
+				}
+				if time.Since(lastReport) >= prefetchReportInterval {
+					lastReport = time.Now()
+					report(result)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, path := range job.Paths {
+		paths <- path
+	}
+	close(paths)
+	wg.Wait()
+
+	result.Status = domain.PrefetchStatusDone
+	report(result)
+}
+
+// fetch requests path of domain through the cache once for every encoding,
+// reading the whole bodies so the responses are stored before the next path
+// is fetched. Responses not varying on Accept-Encoding have no other variant
+// to warm.
+func (p *prefetcher) fetch(domain string, path string) error {
+	encodings := p.encodings
+	if len(encodings) == 0 {
+		encodings = []string{""}
+	}
+
+	for _, encoding := range encodings {
+		vary, err := p.fetchVariant(domain, path, encoding)
+		if err != nil || !slices.Contains(vary, "Accept-Encoding") {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchVariant requests path of domain accepting encoding and returns the
+// header names the response varies on.
+func (p *prefetcher) fetchVariant(domain string, path string, encoding string) ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, "http://"+p.cacheURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Host = domain
+	if encoding != "" {
+		req.Header.Set("Accept-Encoding", encoding)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		metrics.PrefetchRequests.WithLabelValues(domain, "error").Inc()
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		metrics.PrefetchRequests.WithLabelValues(domain, "error").Inc()
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		metrics.PrefetchRequests.WithLabelValues(domain, "error").Inc()
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	metrics.PrefetchRequests.WithLabelValues(domain, "ok").Inc()
+	return varyNames(resp.Header), nil
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+)
+
+type PrefetchServiceInterface interface {
+	Start(job domain.PrefetchJob, report func(domain.PrefetchResult))
+	Retain(jobs []domain.PrefetchJob)
+}
+
+type prefetchService struct {
+	config     *config.Config
+	prefetcher *prefetcher
+	service    string
+	instance   string
+
+	// Final results by job ID, nil while the job runs. Heartbeats repeat jobs
+	// until they are reported done.
+	mu      sync.Mutex
+	results map[string]*domain.PrefetchResult
+}
+
+func NewPrefetchService(config *config.Config, service, instance string) PrefetchServiceInterface {
+	// Warm every coding the cache serves, identity first: responses that are
+	// not compressed have no other variant
+	encodings := []string{encodingIdentity}
+	if config.CompressionEnabled {
+		encodings = append(encodings, encodingBrotli, encodingGzip)
+	}
+
+	return &prefetchService{
+		config:     config,
+		prefetcher: newPrefetcher(config.AppCacheURL, encodings),
+		service:    service,
+		instance:   instance,
+		results:    make(map[string]*domain.PrefetchResult),
+	}
+}
+
+// Start runs job in the background unless it was started before, in which
+// case its final result, if any, is reported again.
+func (s *prefetchService) Start(job domain.PrefetchJob, report func(domain.PrefetchResult)) {
+	s.mu.Lock()
+	done, started := s.results[job.ID]
+	if !started {
+		s.results[job.ID] = nil
+	}
+	s.mu.Unlock()
+
+	if started {
+		if done != nil {
+			report(*done)
+		}
+		return
+	}
+
+	concurrency := min(job.Concurrency, s.config.PrefetchMaxConcurrency)
+	go s.prefetcher.run(job, concurrency, func(result domain.PrefetchResult) {
+		result.Service = s.service
+		result.Instance = s.instance
+		result.Timestamp = time.Now().UTC()
+		if result.Status == domain.PrefetchStatusDone {
+			s.mu.Lock()
+			s.results[job.ID] = &result
+			s.mu.Unlock()
+		}
+		report(result)
+	})
+}
+
+// Retain forgets the final results of the finished jobs not among jobs: the
+// mid stops handing out jobs once it has recorded them done.
+func (s *prefetchService) Retain(jobs []domain.PrefetchJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, result := range s.results {
+		if result != nil && !slices.ContainsFunc(jobs, func(job domain.PrefetchJob) bool { return job.ID == id }) {
+			delete(s.results, id)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/repository"
+)
+
+// purgeCache removes the items invalidated by job from the cache and returns
+// how many there were.
+func purgeCache(cacheItemRepo repository.CacheItemRepositoryInterface, cdn domain.CDN, job domain.PurgeJob) int {
+	match := purgeMatcher(cdn, job)
+	if job.Type == domain.PurgeTypeTag {
+		return cacheItemRepo.DeleteTagged(job.Tags, match)
+	}
+	return cacheItemRepo.DeleteMatching(match)
+}
+
+// purgeMatcher returns a function reporting whether a cache key is
+// invalidated by job. URLs are keyed with the CDN's query key mode, so purging
+// an exact URL also removes the requests sharing its cache key.
+func purgeMatcher(cdn domain.CDN, job domain.PurgeJob) func(key string) bool {
+	var prefixes, keys []string
+	switch job.Type {
+	case domain.PurgeTypeDomain, domain.PurgeTypeTag:
+		// Tags are only purged within the job's domain
+		prefixes = append(prefixes, job.Domain+"/")
+	case domain.PurgeTypePrefix:
+		for _, path := range job.Paths {
+			prefixes = append(prefixes, job.Domain+path)
+		}
+	case domain.PurgeTypeURL:
+		for _, path := range job.Paths {
+			if u, err := url.Parse(path); err == nil {
+				keys = append(keys, requestCacheKey(cdn, job.Domain, u))
+			}
+		}
+	}
+
+	return func(key string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+
+		// Variants and slices are purged along with their object
+		object, _, _ := strings.Cut(key, "#")
+		return slices.Contains(keys, object)
+	}
+}
+
+// cacheTags returns the tags of a response from its Surrogate-Key header,
+// whose tags are separated by spaces, and its Cache-Tag header, whose tags
+// are separated by commas.
+func cacheTags(header http.Header) []string {
+	var tags []string
+	for _, h := range []string{"Surrogate-Key", "Cache-Tag"} {
+		for _, line := range header.Values(h) {
+			tags = append(tags, strings.FieldsFunc(line, func(r rune) bool {
+				return r == ',' || unicode.IsSpace(r)
+			})...)
+		}
+	}
+
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/metrics"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/repository"
+)
+
+type PurgeServiceInterface interface {
+	Purge(job domain.PurgeJob) int
+}
+
+// purgeMemory is how long a purge job is remembered, longer than the mids
+// hand it out for.
+const purgeMemory = 15 * time.Minute
+
+type purgeService struct {
+	cdnRepository       repository.CdnRepositoryInterface
+	cacheItemRepository repository.CacheItemRepositoryInterface
+
+	mu  sync.Mutex
+	ran map[string]ranPurge // by job ID
+}
+
+type ranPurge struct {
+	purged int
+	at     time.Time
+}
+
+func NewPurgeService(cdnRepo repository.CdnRepositoryInterface, cacheItemRepo repository.CacheItemRepositoryInterface) PurgeServiceInterface {
+	return &purgeService{
+		cdnRepository:       cdnRepo,
+		cacheItemRepository: cacheItemRepo,
+		ran:                 make(map[string]ranPurge),
+	}
+}
+
+// Purge removes the items invalidated by job and returns how many there were.
+// A job run before is not run again, as mids hand it out anew to the edges
+// failing over to them: it would drop what was cached since.
+func (s *purgeService) Purge(job domain.PurgeJob) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, ran := range s.ran {
+		if time.Since(ran.at) >= purgeMemory {
+			delete(s.ran, id)
+		}
+	}
+	if ran, ok := s.ran[job.ID]; ok {
+		return ran.purged
+	}
+
+	// Unknown domains are purged with the default cache key options
+	cdn, _ := s.cdnRepository.GetByDomain(job.Domain)
+
+	purged := purgeCache(s.cacheItemRepository, cdn, job)
+	metrics.PurgedItems.WithLabelValues(job.Domain).Add(float64(purged))
+	// Jobs without an ID cannot be told apart
+	if job.ID != "" {
+		s.ran[job.ID] = ranPurge{purged: purged, at: time.Now()}
+	}
+	return purged
+}
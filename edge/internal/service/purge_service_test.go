@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/repository"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/storage"
+)
+
+func TestPurgeRunsJobsOnce(t *testing.T) {
+	cacheItemRepo := repository.NewCacheItemRepository(&config.Config{}, storage.NewMemory())
+	cache := func(key string) {
+		cacheItemRepo.Set(key, &domain.CacheItem{Key: key, ExpiresAt: time.Now().Add(time.Hour)})
+	}
+	s := NewPurgeService(repository.NewCdnRepository(), cacheItemRepo)
+
+	cache("example.com/a.png")
+	cache("example.com/b.png")
+	job := domain.PurgeJob{ID: "job1", Domain: "example.com", Type: domain.PurgeTypeDomain}
+	if n := s.Purge(job); n != 2 {
+		t.Fatalf("purged %d items, want 2", n)
+	}
+
+	// A mid handing the job out again leaves what was cached since alone
+	cache("example.com/a.png")
+	if n := s.Purge(job); n != 2 {
+		t.Errorf("purged %d items running the job again, want the first count, 2", n)
+	}
+	if _, ok := cacheItemRepo.Get("example.com/a.png"); !ok {
+		t.Error("item cached after the job was purged by it again")
+	}
+
+	job.ID = "job2"
+	if n := s.Purge(job); n != 1 {
+		t.Errorf("purged %d items with another job, want 1", n)
+	}
+}
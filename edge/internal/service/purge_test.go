@@ -0,0 +1,113 @@
+package service
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+)
+
+func TestPurgeMatcher(t *testing.T) {
+	keys := []string{
+		"example.com/img/a.png",
+		"example.com/img/a.png#vary=accept-encoding:gzip",
+		"example.com/img/a.png#slice=2",
+		"example.com/img/b.png",
+		"example.com/img/a.png?v=1",
+		"example.com/img/a.png?v=1#slice=0",
+		"example.com/index.html?a=1&b=2",
+		"example.com/index.html?a=1",
+		"example.com.evil/img/a.png",
+		"other.com/img/a.png",
+	}
+
+	for _, tc := range []struct {
+		name string
+		cdn  domain.CDN
+		job  domain.PurgeJob
+		want []string
+	}{
+		{
+			name: "domain",
+			job:  domain.PurgeJob{Type: domain.PurgeTypeDomain, Domain: "example.com"},
+			want: keys[:8],
+		},
+		{
+			name: "tag within the domain",
+			job:  domain.PurgeJob{Type: domain.PurgeTypeTag, Domain: "other.com", Tags: []string{"img"}},
+			want: keys[9:],
+		},
+		{
+			name: "prefix",
+			job:  domain.PurgeJob{Type: domain.PurgeTypePrefix, Domain: "example.com", Paths: []string{"/img/a"}},
+			want: []string{keys[0], keys[1], keys[2], keys[4], keys[5]},
+		},
+		{
+			name: "prefixes",
+			job:  domain.PurgeJob{Type: domain.PurgeTypePrefix, Domain: "example.com", Paths: []string{"/img/b", "/index"}},
+			want: []string{keys[3], keys[6], keys[7]},
+		},
+		{
+			name: "url with its variants and slices",
+			job:  domain.PurgeJob{Type: domain.PurgeTypeURL, Domain: "example.com", Paths: []string{"/img/a.png"}},
+			want: keys[:3],
+		},
+		{
+			name: "url with a query",
+			job:  domain.PurgeJob{Type: domain.PurgeTypeURL, Domain: "example.com", Paths: []string{"/img/a.png?v=1"}},
+			want: keys[4:6],
+		},
+		{
+			name: "url keyed without its query",
+			cdn:  domain.CDN{QueryKeyMode: domain.QueryKeyIgnore},
+			job:  domain.PurgeJob{Type: domain.PurgeTypeURL, Domain: "example.com", Paths: []string{"/img/a.png?v=2"}},
+			want: keys[:3],
+		},
+		{
+			name: "url keyed by sorted query",
+			cdn:  domain.CDN{QueryKeyMode: domain.QueryKeySorted},
+			job:  domain.PurgeJob{Type: domain.PurgeTypeURL, Domain: "example.com", Paths: []string{"/index.html?b=2&a=1"}},
+			want: keys[6:7],
+		},
+		{
+			name: "url keyed by allowed parameters",
+			cdn:  domain.CDN{QueryKeyMode: domain.QueryKeyAllowlist, QueryKeyParams: []string{"a"}},
+			job:  domain.PurgeJob{Type: domain.PurgeTypeURL, Domain: "example.com", Paths: []string{"/index.html?a=1&utm=x"}},
+			want: keys[7:8],
+		},
+		{
+			name: "url keyed without denied parameters",
+			cdn:  domain.CDN{QueryKeyMode: domain.QueryKeyDenylist, QueryKeyParams: []string{"b"}},
+			job:  domain.PurgeJob{Type: domain.PurgeTypeURL, Domain: "example.com", Paths: []string{"/index.html?b=3&a=1"}},
+			want: keys[7:8],
+		},
+		{
+			name: "url as sent",
+			job:  domain.PurgeJob{Type: domain.PurgeTypeURL, Domain: "example.com", Paths: []string{"/index.html?b=2&a=1"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			match := purgeMatcher(tc.cdn, tc.job)
+			var got []string
+			for _, key := range keys {
+				if match(key) {
+					got = append(got, key)
+				}
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("purged %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCacheTags(t *testing.T) {
+	header := http.Header{
+		"Surrogate-Key": {"product-1  products", "home"},
+		"Cache-Tag":     {"products,sale, home"},
+	}
+	if got, want := cacheTags(header), []string{"home", "product-1", "products", "sale"}; !slices.Equal(got, want) {
+		t.Errorf("cacheTags = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,455 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/metrics"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// slicePiece is one byte range of a sliced object, read either from its cached
+// slice file or straight from an upstream response.
+type slicePiece struct {
+	body   io.ReadCloser
+	header http.Header
+	status int
+	start  int64 // offset of the first body byte within the object
+	length int64
+	total  int64 // size of the whole object, -1 when unknown
+
+	item    *domain.CacheItem // set when the piece is a cached slice
+	tier    string            // storage tier of cached slices
+	warning string            // set when the piece is an expired slice served stale
+	stored  bool              // set when the piece is being written to the cache
+}
+
+// tailingBody reads a slice while it is written to the cache.
+type tailingBody struct {
+	io.Reader
+	io.Closer
+}
+
+func newSlicePiece(body io.ReadCloser, header http.Header, status int) *slicePiece {
+	piece := &slicePiece{body: body, header: header, status: status, total: -1}
+
+	switch status {
+	case http.StatusPartialContent:
+		var first, last, total int64
+		if _, err := fmt.Sscanf(header.Get("Content-Range"), "bytes %d-%d/%d", &first, &last, &total); err == nil && first <= last && last < total {
+			piece.start, piece.length, piece.total = first, last-first+1, total
+		}
+	case http.StatusOK:
+		// Upstream ignored the range and sent the whole object
+		if n := contentLength(header); n >= 0 {
+			piece.length, piece.total = n, n
+		}
+	}
+
+	return piece
+}
+
+func sliceKey(cacheKey string, index int64) string {
+	return cacheKey + "#slice=" + strconv.FormatInt(index, 10)
+}
+
+// serveSliced answers a GET on a slicing CDN by assembling the response from
+// fixed-size byte-range slices, each fetched, cached and expired on its own.
+func (s *cacheService) serveSliced(c *gin.Context, cdn domain.CDN, cacheKey string) {
+	sliceSize := int64(cdn.SliceSize)
+
+	piece, err := s.openSlice(c, cdn, cacheKey, rangeStartHint(c.Request)/sliceSize)
+	if err != nil {
+		c.String(http.StatusBadGateway, "Error fetching slice: %v", err)
+		return
+	}
+	defer func() {
+		if piece != nil {
+			_ = piece.body.Close()
+		}
+	}()
+
+	for k, vals := range piece.header {
+		for _, v := range vals {
+			c.Writer.Header().Add(k, v)
+		}
+	}
+	if piece.warning != "" {
+		c.Header("Warning", piece.warning)
+	}
+
+	if piece.total >= 0 && notModified(c.Request, piece.header) {
+		c.Writer.Header().Del("Content-Range")
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	// Upstream errors and bodies of unknown size are passed through untouched
+	if piece.total < 0 {
+		c.Status(piece.status)
+		n, _ := io.Copy(c.Writer, piece.body)
+		metrics.BytesSent.WithLabelValues(cdn.Domain, "slice").Add(float64(n))
+		return
+	}
+
+	total := piece.total
+	etag := piece.header.Get("ETag")
+	c.Writer.Header().Del("Content-Range")
+	c.Header("Accept-Ranges", "bytes")
+
+	r, err := requestedRange(c.Request, piece.header, total)
+	if errors.Is(err, errRangeNotSatisfiable) {
+		c.Writer.Header().Del("Content-Length")
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", total))
+		c.Status(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	from, to, status := int64(0), total, http.StatusOK
+	if r != nil {
+		from, to, status = r.start, r.start+r.length, http.StatusPartialContent
+		c.Header("Content-Range", r.contentRange(total))
+	}
+	c.Header("Content-Length", strconv.FormatInt(to-from, 10))
+	c.Status(status)
+
+	for pos := from; pos < to; {
+		if piece == nil || pos < piece.start || pos >= piece.start+piece.length {
+			if piece != nil {
+				_ = piece.body.Close()
+			}
+
+			piece, err = s.openSlice(c, cdn, cacheKey, pos/sliceSize)
+			if err == nil && (piece.total != total || piece.header.Get("ETag") != etag) {
+				err = errors.New("object changed upstream")
+			}
+			if err != nil {
+				// The short body makes the server drop the connection
+				metrics.ErrorsTotal.WithLabelValues(cdn.Domain, "slice_assembly").Inc()
+				return
+			}
+		}
+
+		if err := skip(piece.body, pos-piece.start); err != nil {
+			metrics.ErrorsTotal.WithLabelValues(cdn.Domain, "slice_assembly").Inc()
+			return
+		}
+
+		n, err := io.CopyN(c.Writer, piece.body, min(to, piece.start+piece.length)-pos)
+		metrics.BytesSent.WithLabelValues(cdn.Domain, "slice").Add(float64(n))
+		pos += n
+		if err != nil {
+			return
+		}
+
+		_ = piece.body.Close()
+		piece = nil
+	}
+}
+
+// openSlice returns slice index of the object from the cache or upstream.
+// Concurrent misses for the same slice share one upstream fetch.
+func (s *cacheService) openSlice(c *gin.Context, cdn domain.CDN, cacheKey string, index int64) (*slicePiece, error) {
+	// The response headers describe the slice the response starts with
+	d := diagnosticsOf(c)
+
+	key := sliceKey(cacheKey, index)
+	if piece, ok := s.cachedSlice(key); ok {
+		metrics.CacheHits.WithLabelValues(cdn.Domain).Inc()
+		metrics.CacheTierHits.WithLabelValues(cdn.Domain, piece.tier).Inc()
+		d.record(cacheHit, "")
+		d.served(piece.item)
+		return piece, nil
+	}
+
+	stale, found := s.cacheItemRepository.Get(key)
+	if found && time.Now().Before(stale.StaleUntil) {
+		if piece, ok := s.openStaleSlice(stale, `110 - "Response is Stale"`); ok {
+			metrics.StaleServed.WithLabelValues(cdn.Domain, "revalidate").Inc()
+			d.record(cacheStale, "")
+			d.served(piece.item)
+			s.refreshSliceInBackground(c, cdn, key, index, stale)
+			return piece, nil
+		}
+	}
+	metrics.CacheMisses.WithLabelValues(cdn.Domain).Inc()
+	if found {
+		d.record(cacheExpired, fwdStale)
+	} else {
+		d.record(cacheMiss, fwdURIMiss)
+	}
+
+	req, err := s.newSliceRequest(c, cdn, index)
+	if err != nil {
+		return nil, err
+	}
+
+	f, leader := s.fills.acquire(key)
+	if !leader {
+		metrics.CollapsedRequests.WithLabelValues(cdn.Domain).Inc()
+		if !f.wait(s.config.CacheLockTimeoutDuration) {
+			metrics.CacheLockTimeouts.WithLabelValues(cdn.Domain).Inc()
+		} else if piece, ok := s.followSlice(key, f); ok {
+			d.collapsed = true
+			d.served(piece.item)
+			return piece, nil
+		}
+		// The leader timed out or did not cache the slice: read it from upstream
+		f = nil
+	}
+
+	piece, err := s.fetchSlice(cdn, c.Request, key, index, req, stale, f)
+	if err == nil {
+		d.served(piece.item)
+		if piece.item == nil {
+			d.forwarded(piece.status)
+		}
+		d.stored = piece.stored
+		if piece.warning != "" {
+			d.result = cacheStale
+		}
+	}
+	return piece, err
+}
+
+// followSlice returns the slice another request is fetching, tailing its
+// cache entry while it is written.
+func (s *cacheService) followSlice(key string, f *fill) (*slicePiece, bool) {
+	if f.cacheable {
+		if body, err := f.entry.OpenPartial(); err == nil {
+			return newSlicePiece(tailingBody{f.reader(body), body}, f.header, f.status), true
+		}
+		// The body has already been moved into place: wait for it to be indexed
+		_ = f.result()
+	}
+
+	// The leader did not stream a new slice, but it may have revalidated it
+	return s.cachedSlice(key)
+}
+
+// refreshSliceInBackground revalidates or downloads again a slice that is
+// being served stale, unless a fetch for it is already in flight.
+func (s *cacheService) refreshSliceInBackground(c *gin.Context, cdn domain.CDN, key string, index int64, stale *domain.CacheItem) {
+	f, leader := s.fills.acquire(key)
+	if !leader {
+		return
+	}
+
+	req, err := s.newSliceRequest(c, cdn, index)
+	if err != nil {
+		s.fills.release(key, f)
+		return
+	}
+	clientReq := c.Request.Clone(context.Background())
+
+	go func() {
+		// Errors are ignored: the stale slice is served until its window runs out
+		if piece, err := s.fetchSlice(cdn, clientReq, key, index, req, stale, f); err == nil {
+			_ = piece.body.Close()
+		}
+	}()
+}
+
+// cachedSlice opens a fresh cached slice.
+func (s *cacheService) cachedSlice(key string) (*slicePiece, bool) {
+	item, found := s.cacheItemRepository.Get(key)
+	if !found || !time.Now().Before(item.ExpiresAt) {
+		return nil, false
+	}
+
+	return s.openCachedSlice(item)
+}
+
+// openStaleSlice opens an expired cached slice flagged with the given Warning.
+func (s *cacheService) openStaleSlice(item *domain.CacheItem, warning string) (*slicePiece, bool) {
+	piece, ok := s.openCachedSlice(item)
+	if ok {
+		piece.warning = warning
+	}
+	return piece, ok
+}
+
+func (s *cacheService) openCachedSlice(item *domain.CacheItem) (*slicePiece, bool) {
+	body, tier, err := s.cacheItemRepository.Open(item)
+	if err != nil {
+		return nil, false
+	}
+
+	piece := newSlicePiece(body, item.Header, http.StatusPartialContent)
+	piece.item = item
+	piece.tier = tier
+	return piece, true
+}
+
+// newSliceRequest builds the upstream range request for slice index.
+func (s *cacheService) newSliceRequest(c *gin.Context, cdn domain.CDN, index int64) (*http.Request, error) {
+	req, err := s.newUpstreamRequest(c, cdn)
+	if err != nil {
+		metrics.ErrorsTotal.WithLabelValues(cdn.Domain, "request_creation").Inc()
+		return nil, err
+	}
+
+	sliceSize := int64(cdn.SliceSize)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", index*sliceSize, (index+1)*sliceSize-1))
+	return req, nil
+}
+
+// fetchSlice sends req, the range request of clientReq for slice index,
+// upstream. When f is not nil, fetchSlice releases it once done, and a
+// cacheable slice is streamed from its cache entry while it is written in the
+// background; otherwise the upstream body is returned as it is. A stale slice
+// is revalidated and refreshed when upstream answers 304, and is returned
+// instead of upstream errors within its stale-if-error window.
+func (s *cacheService) fetchSlice(cdn domain.CDN, clientReq *http.Request, key string, index int64, req *http.Request, stale *domain.CacheItem, f *fill) (*slicePiece, error) {
+	streaming := false
+	if f != nil {
+		defer func() {
+			if !streaming {
+				s.fills.release(key, f)
+			}
+		}()
+	}
+
+	revalidating := stale != nil && stale.HasValidators()
+	if revalidating {
+		setValidators(req, stale.Header)
+	}
+
+	resp, err := s.doUpstream(cdn, req)
+	if err != nil {
+		if piece, ok := s.staleSliceOnError(cdn, stale); ok {
+			return piece, nil
+		}
+		return nil, err
+	}
+
+	if resp.StatusCode >= 500 {
+		if piece, ok := s.staleSliceOnError(cdn, stale); ok {
+			_ = resp.Body.Close()
+			return piece, nil
+		}
+	}
+
+	if revalidating {
+		if resp.StatusCode == http.StatusNotModified {
+			_ = resp.Body.Close()
+			metrics.Revalidations.WithLabelValues(cdn.Domain, "not_modified").Inc()
+			s.refreshItem(cdn, clientReq, key, stale, resp.Header)
+			if piece, ok := s.cachedSlice(key); ok {
+				return piece, nil
+			}
+			return nil, errors.New("slice not cached")
+		}
+		metrics.Revalidations.WithLabelValues(cdn.Domain, "modified").Inc()
+	}
+
+	sliceSize := int64(cdn.SliceSize)
+	piece := newSlicePiece(resp.Body, resp.Header, resp.StatusCode)
+	rule := matchCacheRule(cdn, clientReq, resp.Header)
+	ttl, ttlSource, storable := freshness(cachePolicy(cdn, rule, http.StatusOK), resp.Header)
+	if f == nil || !storable || rule.Action != domain.CacheRuleCache || !isCacheableSlice(piece, index*sliceSize, sliceSize) || len(varyNames(resp.Header)) > 0 {
+		return piece, nil
+	}
+
+	// Whole-object responses are stored like any other slice
+	header := resp.Header.Clone()
+	header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", piece.start, piece.start+piece.length-1, piece.total))
+	header.Set("Content-Length", strconv.FormatInt(piece.length, 10))
+
+	entry, err := s.createCacheEntry(cdn, key, http.StatusPartialContent, header, ttl, ttlSource)
+	if err != nil {
+		metrics.ErrorsTotal.WithLabelValues(cdn.Domain, "cache_write").Inc()
+		return piece, nil
+	}
+	body, err := entry.OpenPartial()
+	if err != nil {
+		entry.Abort()
+		metrics.ErrorsTotal.WithLabelValues(cdn.Domain, "cache_write").Inc()
+		return piece, nil
+	}
+
+	streaming = true
+	f.start(key, http.StatusPartialContent, header, entry)
+	go s.fillSlice(cdn, key, resp, piece.length, entry, ttl, ttlSource, f)
+
+	piece.body = tailingBody{f.reader(body), body}
+	piece.header = header
+	piece.stored = true
+	return piece, nil
+}
+
+// fillSlice writes the size bytes of the body of resp into entry, which was
+// created by createCacheEntry, caches the slice once complete and releases f.
+func (s *cacheService) fillSlice(cdn domain.CDN, key string, resp *http.Response, size int64, entry *repository.EntryWriter, ttl time.Duration, ttlSource string, f *fill) {
+	defer s.fills.release(key, f)
+	defer resp.Body.Close()
+
+	received, err := io.Copy(f.track(entry), resp.Body)
+	metrics.BytesReceived.WithLabelValues(cdn.Domain).Add(float64(received))
+	if err == nil && received != size {
+		err = io.ErrUnexpectedEOF
+	}
+
+	// Freshness counts from when the slice is complete
+	item := newCacheItem(cdn, s.cacheFilePath(key), http.StatusPartialContent, f.header.Clone(), ttl, ttlSource)
+	item.Key = key
+	if err == nil {
+		err = entry.Commit(item)
+	} else {
+		entry.Abort()
+	}
+	if err != nil {
+		metrics.ErrorsTotal.WithLabelValues(cdn.Domain, "cache_write").Inc()
+		f.finish(err)
+		return
+	}
+
+	s.storeItem(key, item)
+}
+
+// staleSliceOnError returns the stale slice in place of a failed upstream
+// response when it is still within its stale-if-error window.
+func (s *cacheService) staleSliceOnError(cdn domain.CDN, stale *domain.CacheItem) (*slicePiece, bool) {
+	if stale == nil || !time.Now().Before(stale.StaleIfErrorUntil) {
+		return nil, false
+	}
+
+	piece, ok := s.openStaleSlice(stale, `111 - "Revalidation Failed"`)
+	if ok {
+		metrics.StaleServed.WithLabelValues(cdn.Domain, "error").Inc()
+	}
+	return piece, ok
+}
+
+// isCacheableSlice reports whether piece is exactly the slice starting at
+// start, or a whole object small enough to be the first slice.
+func isCacheableSlice(piece *slicePiece, start, sliceSize int64) bool {
+	switch piece.status {
+	case http.StatusPartialContent:
+		return piece.total >= 0 && piece.start == start && piece.length <= sliceSize
+	case http.StatusOK:
+		return start == 0 && piece.total > 0 && piece.total <= sliceSize
+	}
+	return false
+}
+
+// skip discards the first n bytes of r.
+func skip(r io.Reader, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekCurrent)
+		return err
+	}
+
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/repository"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+func TestSliceStreaming(t *testing.T) {
+	const sliceSize = 16384
+	body := strings.Repeat("0123456789", 3000)
+
+	// The first slice stalls halfway until released
+	var calls atomic.Int32
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var first, last int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &first, &last); err != nil {
+			t.Errorf("upstream request without a slice range: %q", r.Header.Get("Range"))
+			return
+		}
+		last = min(last, len(body)-1)
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(body)))
+		w.Header().Set("Content-Length", fmt.Sprint(last-first+1))
+		w.WriteHeader(http.StatusPartialContent)
+
+		part := body[first : last+1]
+		if first == 0 {
+			_, _ = io.WriteString(w, part[:1000])
+			w.(http.Flusher).Flush()
+			<-release
+			part = part[1000:]
+		}
+		_, _ = io.WriteString(w, part)
+	}))
+	t.Cleanup(upstream.Close)
+
+	cdn := domain.CDN{Domain: "example.com", Origin: upstream.URL, CacheTTL: 60, SliceSize: sliceSize, IsActive: true}
+	edge := httptest.NewServer(newTestCacheRouter(t, cdn, upstream))
+	t.Cleanup(edge.Close)
+	// Cleanups run last first: the servers wait for the stalled response
+	t.Cleanup(unblock)
+
+	get := func(rng string) string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, edge.URL+"/video.mp4", nil)
+		req.Host = "example.com"
+		req.Header.Set("Range", rng)
+		client := &http.Client{Timeout: 5 * time.Second}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			t.Fatalf("%s answered %d, want 206", rng, resp.StatusCode)
+		}
+		return string(data)
+	}
+
+	// The leader and a collapsed follower are served the bytes written so far
+	// while the slice is still being filled
+	if got := get("bytes=0-99"); got != body[:100] {
+		t.Errorf("leader read %q, want %q", got, body[:100])
+	}
+	if got := get("bytes=500-599"); got != body[500:600] {
+		t.Errorf("follower read %q, want %q", got, body[500:600])
+	}
+
+	unblock()
+	if got := get("bytes=0-16383"); got != body[:sliceSize] {
+		t.Errorf("read %d bytes of the first slice, want the whole slice", len(got))
+	}
+	if got := get("bytes=16000-16999"); got != body[16000:17000] {
+		t.Errorf("read across slices %q, want %q", got, body[16000:17000])
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("upstream got %d requests, want one a slice", n)
+	}
+}
+
+// newTestCacheRouter returns a router serving cdn through a cache service
+// that fills from upstream.
+func newTestCacheRouter(t *testing.T, cdn domain.CDN, upstream *httptest.Server) *gin.Engine {
+	t.Helper()
+	cfg := &config.Config{
+		CacheDir:                   t.TempDir(),
+		CacheLockTimeoutDuration:   500 * time.Millisecond,
+		CacheRetentionDuration:     time.Minute,
+		MidFailureCooldownDuration: time.Second,
+	}
+	store, err := storage.NewFS(cfg.CacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdnRepo := repository.NewCdnRepository()
+	cdnRepo.Set([]domain.CDN{cdn}, "v1")
+	midRepo := repository.NewMidRepository([]string{strings.TrimPrefix(upstream.URL, "http://")})
+	s := NewCacheService(cfg, cdnRepo, repository.NewCacheItemRepository(cfg, store), NewUpstreamPool(cfg, midRepo))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Any("/*path", s.CacheRequest)
+	return r
+}
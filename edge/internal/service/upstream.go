@@ -0,0 +1,154 @@
+package service
+
+import (
+	"cmp"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/metrics"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/repository"
+)
+
+// UpstreamPoolInterface picks the nodes cache fills are sent to.
+type UpstreamPoolInterface interface {
+	// Nodes returns the base URLs of the nodes the fill req may be sent to,
+	// in the order they are tried.
+	Nodes(cdn domain.CDN, req *http.Request) []string
+	// Acquire reserves a request to node, or reports false when the node has
+	// no capacity left. release is called once the response is done with.
+	Acquire(cdn domain.CDN, node string) (release func(), ok bool)
+	// Failed records a request to node that got no response.
+	Failed(cdn domain.CDN, node string)
+	// Answered records a response of node and reports whether it is used,
+	// the next node being tried otherwise.
+	Answered(cdn domain.CDN, node string, status int) bool
+}
+
+// midPool spreads cache keys over the mids with rendezvous hashing, so every
+// key is filled through the same mid and a mid joining or leaving only moves
+// its share of the keys. Mids that fail are skipped for a cooldown, their keys
+// moving to the next mid in each key's order meanwhile. With the origin
+// fallback on, fills go to the CDN origin once no mid is left, within a
+// concurrency budget, until a mid answers again.
+type midPool struct {
+	config        *config.Config
+	midRepository repository.MidRepositoryInterface
+	originSlots   chan struct{} // nil when origin requests are not limited
+}
+
+func NewUpstreamPool(config *config.Config, midRepo repository.MidRepositoryInterface) UpstreamPoolInterface {
+	for _, mid := range midRepo.GetAll() {
+		metrics.MidUp.WithLabelValues(mid).Set(1)
+	}
+	var originSlots chan struct{}
+	if config.OriginFallbackMaxConns > 0 {
+		originSlots = make(chan struct{}, config.OriginFallbackMaxConns)
+	}
+	return &midPool{
+		config:        config,
+		midRepository: midRepo,
+		originSlots:   originSlots,
+	}
+}
+
+// Nodes returns the mids by decreasing rendezvous score for the cache key of
+// req. Mids that recently failed come last, so they are only tried when all
+// others fail, or are replaced by the CDN origin with the origin fallback on.
+func (p *midPool) Nodes(cdn domain.CDN, req *http.Request) []string {
+	key := requestCacheKey(cdn, cdn.Domain, req.URL)
+	var up, down []string
+	for _, mid := range p.midRepository.GetAll() {
+		if p.midRepository.IsDown(mid) {
+			down = append(down, mid)
+		} else {
+			up = append(up, mid)
+		}
+	}
+	byScore := func(a, b string) int {
+		return cmp.Compare(rendezvousScore(b, key), rendezvousScore(a, key))
+	}
+	slices.SortFunc(up, byScore)
+	slices.SortFunc(down, byScore)
+
+	nodes := make([]string, 0, len(up)+len(down))
+	for _, mid := range up {
+		nodes = append(nodes, "http://"+mid)
+	}
+
+	if p.config.OriginFallback && cdn.Origin != "" {
+		if len(up) == 0 {
+			metrics.DegradedMode.Set(1)
+		} else {
+			metrics.DegradedMode.Set(0)
+		}
+		return append(nodes, cdn.Origin)
+	}
+
+	for _, mid := range down {
+		nodes = append(nodes, "http://"+mid)
+	}
+	return nodes
+}
+
+// Acquire limits the requests sent to the origin in degraded mode. Mids are
+// not limited.
+func (p *midPool) Acquire(cdn domain.CDN, node string) (func(), bool) {
+	if node != cdn.Origin || p.originSlots == nil {
+		return func() {}, true
+	}
+	select {
+	case p.originSlots <- struct{}{}:
+		return func() { <-p.originSlots }, true
+	default:
+		metrics.TierBypasses.WithLabelValues(cdn.Domain, "rejected").Inc()
+		return nil, false
+	}
+}
+
+func (p *midPool) Failed(cdn domain.CDN, node string) {
+	if node == cdn.Origin {
+		metrics.TierBypasses.WithLabelValues(cdn.Domain, "error").Inc()
+		return
+	}
+	mid := strings.TrimPrefix(node, "http://")
+	p.midRepository.MarkDown(mid, time.Now().Add(p.config.MidFailureCooldownDuration))
+	metrics.MidUp.WithLabelValues(mid).Set(0)
+	metrics.MidFailovers.WithLabelValues(mid).Inc()
+}
+
+// Answered uses every response, mids pass on the errors of the origin.
+func (p *midPool) Answered(cdn domain.CDN, node string, _ int) bool {
+	if node == cdn.Origin {
+		metrics.TierBypasses.WithLabelValues(cdn.Domain, "ok").Inc()
+		return true
+	}
+	mid := strings.TrimPrefix(node, "http://")
+	if p.midRepository.IsDown(mid) {
+		p.midRepository.MarkUp(mid)
+	}
+	metrics.MidUp.WithLabelValues(mid).Set(1)
+	return true
+}
+
+// rendezvousScore returns the weight of node for key. Keys go to the node
+// with the highest score.
+func rendezvousScore(node string, key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(node))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(key))
+
+	// FNV alone spreads keys differing in their last bytes poorly
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
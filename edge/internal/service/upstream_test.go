@@ -0,0 +1,109 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/repository"
+)
+
+func TestRendezvousScore(t *testing.T) {
+	// Edges must agree on the mid of every key across versions
+	if got := rendezvousScore("mid1:9060", "example.com/a.png"); got != 17172535015428310603 {
+		t.Errorf("rendezvousScore = %d, want 17172535015428310603", got)
+	}
+}
+
+func TestMidPoolNodes(t *testing.T) {
+	cdn := domain.CDN{Domain: "example.com", Origin: "http://origin"}
+	mids := []string{"mid1:9060", "mid2:9060", "mid3:9060", "mid4:9060"}
+	midRepo := repository.NewMidRepository(mids)
+	pool := NewUpstreamPool(&config.Config{MidFailureCooldownDuration: time.Minute}, midRepo)
+
+	first := func(path string) string {
+		return pool.Nodes(cdn, httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil))[0]
+	}
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := range 4000 {
+		path := fmt.Sprintf("/%d.png", i)
+		owners[path] = first(path)
+		counts[owners[path]]++
+	}
+
+	// Keys spread evenly, each in a stable order of all mids
+	for _, mid := range mids {
+		if n := counts["http://"+mid]; n < 800 || n > 1200 {
+			t.Errorf("%s owns %d of 4000 keys, want about 1000", mid, n)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/a.png", nil)
+	nodes := pool.Nodes(cdn, req)
+	if len(nodes) != len(mids) || !slices.Equal(nodes, pool.Nodes(cdn, req)) {
+		t.Fatalf("nodes %q, want every mid in a stable order", nodes)
+	}
+
+	// A leaving mid only moves its own keys
+	midRepo.Set([]string{"mid1:9060", "mid2:9060", "mid4:9060"})
+	for path, owner := range owners {
+		if got := first(path); owner != "http://mid3:9060" && got != owner {
+			t.Fatalf("%s moved from %s to %s", path, owner, got)
+		}
+	}
+
+	// A failed mid is tried last until its cooldown ends
+	midRepo.Set(mids)
+	pool.Failed(cdn, nodes[0])
+	if got := pool.Nodes(cdn, req); !slices.Equal(got, append(slices.Clone(nodes[1:]), nodes[0])) {
+		t.Errorf("nodes after a failure %q, want %q last", got, nodes[0])
+	}
+	pool.Answered(cdn, nodes[0], http.StatusOK)
+	if got := pool.Nodes(cdn, req); !slices.Equal(got, nodes) {
+		t.Errorf("nodes after an answer %q, want %q", got, nodes)
+	}
+}
+
+func TestMidPoolOriginFallback(t *testing.T) {
+	cdn := domain.CDN{Domain: "example.com", Origin: "http://origin"}
+	midRepo := repository.NewMidRepository([]string{"mid1:9060", "mid2:9060"})
+	pool := NewUpstreamPool(&config.Config{
+		MidFailureCooldownDuration: time.Minute,
+		OriginFallback:             true,
+		OriginFallbackMaxConns:     1,
+	}, midRepo)
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/a.png", nil)
+
+	nodes := pool.Nodes(cdn, req)
+	if len(nodes) != 3 || nodes[2] != cdn.Origin {
+		t.Fatalf("nodes %q, want the mids then the origin", nodes)
+	}
+
+	// Failed mids are left out, the origin taking their place
+	pool.Failed(cdn, nodes[0])
+	pool.Failed(cdn, nodes[1])
+	if got := pool.Nodes(cdn, req); !slices.Equal(got, []string{cdn.Origin}) {
+		t.Fatalf("nodes with every mid down %q, want only the origin", got)
+	}
+
+	// Origin requests stay within their budget, mids are not limited
+	release, ok := pool.Acquire(cdn, cdn.Origin)
+	if !ok {
+		t.Fatal("first origin request rejected")
+	}
+	if _, ok := pool.Acquire(cdn, cdn.Origin); ok {
+		t.Error("origin request past the budget accepted")
+	}
+	if _, ok := pool.Acquire(cdn, nodes[0]); !ok {
+		t.Error("mid request rejected")
+	}
+	release()
+	if _, ok := pool.Acquire(cdn, cdn.Origin); !ok {
+		t.Error("origin request rejected after a release")
+	}
+}
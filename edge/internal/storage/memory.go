@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+	"sync"
+)
+
+// errWriterDone is returned when reading a partial blob whose writer is done.
+var errWriterDone = errors.New("blob writer is done")
+
+// memoryStorage keeps blobs in process memory. Nothing survives a restart,
+// and CACHE_MAX_SIZE bounds the memory it takes.
+type memoryStorage struct {
+	mu    sync.RWMutex
+	blobs map[string][]byte
+}
+
+// NewMemory returns an empty storage keeping blobs in memory.
+func NewMemory() Storage {
+	return &memoryStorage{blobs: make(map[string][]byte)}
+}
+
+func (s *memoryStorage) Create(name string) (Writer, error) {
+	return &memoryWriter{storage: s, name: name}, nil
+}
+
+func (s *memoryStorage) Open(name string) (Reader, int64, error) {
+	s.mu.RLock()
+	data, ok := s.blobs[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, 0, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	// Committed blobs are never modified, so readers share them
+	return memoryBlob{bytes.NewReader(data)}, int64(len(data)), nil
+}
+
+func (s *memoryStorage) Size(name string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.blobs[name]
+	if !ok {
+		return 0, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return int64(len(data)), nil
+}
+
+// Patch replaces the blob with a patched copy, since committed blobs are
+// shared by their readers.
+func (s *memoryStorage) Patch(name string, head []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.blobs[name]
+	if !ok {
+		return &fs.PathError{Op: "patch", Path: name, Err: fs.ErrNotExist}
+	}
+	patched := make([]byte, max(len(data), len(head)))
+	copy(patched, data)
+	copy(patched, head)
+	s.blobs[name] = patched
+	return nil
+}
+
+func (s *memoryStorage) Rename(from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.blobs[from]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: from, Err: fs.ErrNotExist}
+	}
+	delete(s.blobs, from)
+	s.blobs[to] = data
+	return nil
+}
+
+func (s *memoryStorage) Delete(name string) error {
+	s.mu.Lock()
+	delete(s.blobs, name)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStorage) Walk(fn func(name string)) error {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.blobs))
+	for name := range s.blobs {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		fn(name)
+	}
+	return nil
+}
+
+type memoryWriter struct {
+	storage *memoryStorage
+	name    string
+
+	mu   sync.RWMutex
+	data []byte
+	done bool
+}
+
+func (w *memoryWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.done {
+		return 0, errWriterDone
+	}
+	if end := off + int64(len(p)); end > int64(len(w.data)) {
+		w.data = append(w.data, make([]byte, end-int64(len(w.data)))...)
+	}
+	return copy(w.data[off:], p), nil
+}
+
+func (w *memoryWriter) OpenPartial() (Reader, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.done {
+		return nil, errWriterDone
+	}
+	return memoryPartial{w}, nil
+}
+
+func (w *memoryWriter) Commit() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.done {
+		return errWriterDone
+	}
+	w.done = true
+
+	w.storage.mu.Lock()
+	w.storage.blobs[w.name] = w.data
+	w.storage.mu.Unlock()
+	return nil
+}
+
+func (w *memoryWriter) Abort() {
+	w.mu.Lock()
+	w.done = true
+	w.mu.Unlock()
+}
+
+// memoryBlob reads a committed blob.
+type memoryBlob struct {
+	*bytes.Reader
+}
+
+func (memoryBlob) Close() error {
+	return nil
+}
+
+// memoryPartial reads a blob while it is being written. Like open files, it
+// keeps reading the data once the writer is done.
+type memoryPartial struct {
+	w *memoryWriter
+}
+
+func (p memoryPartial) ReadAt(b []byte, off int64) (int, error) {
+	p.w.mu.RLock()
+	defer p.w.mu.RUnlock()
+
+	if off >= int64(len(p.w.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, p.w.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (memoryPartial) Close() error {
+	return nil
+}
@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFSStorage(t *testing.T) {
+	s, err := NewFS(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, s)
+}
+
+func TestFSPatch(t *testing.T) {
+	root := t.TempDir()
+	s, err := NewFS(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit(t, s, "ab/patch.cache", "0123456789")
+	r, _, err := s.Open("ab/patch.cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// The patched file replaces the blob rather than being written over it
+	if err := s.Patch("ab/patch.cache", []byte("ab")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAt(t, r, 4, 0); got != "0123" {
+		t.Errorf("reader opened before the patch read %q, want %q", got, "0123")
+	}
+	if got := readAll(t, s, "ab/patch.cache"); got != "ab23456789" {
+		t.Errorf("read %q, want %q", got, "ab23456789")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, "ab")); len(entries) != 1 {
+		t.Errorf("%d files after the patch, want the blob alone", len(entries))
+	}
+	if err := s.Patch("missing.cache", []byte("ab")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("patch missing blob: %v, want not exist", err)
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	testStorage(t, NewMemory())
+}
+
+func TestS3Storage(t *testing.T) {
+	_, s := newTestS3(t, S3Options{})
+	testStorage(t, s)
+}
+
+// testStorage checks the behaviour every backend shares.
+func testStorage(t *testing.T, s Storage) {
+	t.Run("write", func(t *testing.T) {
+		w, err := s.Create("ab/cd/write.cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustWriteAt(t, w, "body", 100)
+
+		partial, err := w.OpenPartial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer partial.Close()
+		if got := readAt(t, partial, 4, 100); got != "body" {
+			t.Errorf("partial read %q, want %q", got, "body")
+		}
+		if _, _, err := s.Open("ab/cd/write.cache"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("open before commit: %v, want not exist", err)
+		}
+
+		mustWriteAt(t, w, "head", 0)
+		if err := w.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if got := readAt(t, partial, 4, 100); got != "body" {
+			t.Errorf("partial read after commit %q, want %q", got, "body")
+		}
+		if _, err := w.OpenPartial(); err == nil {
+			t.Error("partial opened after commit")
+		}
+
+		r, size, err := s.Open("ab/cd/write.cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if size != 104 {
+			t.Errorf("size %d, want 104", size)
+		}
+		if size, err := s.Size("ab/cd/write.cache"); err != nil || size != 104 {
+			t.Errorf("Size = %d, %v, want 104", size, err)
+		}
+		if got := readAt(t, r, 4, 100); got != "body" {
+			t.Errorf("read %q, want %q", got, "body")
+		}
+		if got := readAt(t, r, 4, 0); got != "head" {
+			t.Errorf("read backwards %q, want %q", got, "head")
+		}
+	})
+
+	t.Run("read past end", func(t *testing.T) {
+		commit(t, s, "end.cache", "0123456789")
+		r, _, err := s.Open("end.cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		p := make([]byte, 8)
+		if n, err := r.ReadAt(p, 6); n != 4 || err != io.EOF || string(p[:n]) != "6789" {
+			t.Errorf("short read = %d %q, %v, want 4 %q, EOF", n, p[:n], err, "6789")
+		}
+		if n, err := r.ReadAt(p, 20); n != 0 || err != io.EOF {
+			t.Errorf("read past end = %d, %v, want 0, EOF", n, err)
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		commit(t, s, "replace.cache", "old body")
+		commit(t, s, "replace.cache", "new")
+		if got := readAll(t, s, "replace.cache"); got != "new" {
+			t.Errorf("read %q, want %q", got, "new")
+		}
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		w, err := s.Create("aborted.cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustWriteAt(t, w, "x", 0)
+		w.Abort()
+		if _, err := s.Size("aborted.cache"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Size of aborted blob: %v, want not exist", err)
+		}
+	})
+
+	t.Run("patch", func(t *testing.T) {
+		commit(t, s, "patch.cache", "0123456789")
+		if err := s.Patch("patch.cache", []byte("ab")); err != nil {
+			t.Fatal(err)
+		}
+		if got := readAll(t, s, "patch.cache"); got != "ab23456789" {
+			t.Errorf("read %q, want %q", got, "ab23456789")
+		}
+
+		r, _, err := s.Open("patch.cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if got := readAt(t, r, 4, 1); got != "b234" {
+			t.Errorf("read across the patch %q, want %q", got, "b234")
+		}
+
+		// Patches do not outlive the blob
+		commit(t, s, "patch.cache", "0123")
+		if got := readAll(t, s, "patch.cache"); got != "0123" {
+			t.Errorf("read after replace %q, want %q", got, "0123")
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		commit(t, s, "from.cache", "moved")
+		if err := s.Patch("from.cache", []byte("M")); err != nil {
+			t.Fatal(err)
+		}
+		commit(t, s, "to.cache", "replaced")
+		if err := s.Rename("from.cache", "to.cache"); err != nil {
+			t.Fatal(err)
+		}
+		if got := readAll(t, s, "to.cache"); got != "Moved" {
+			t.Errorf("read %q, want %q", got, "Moved")
+		}
+		if _, _, err := s.Open("from.cache"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("open renamed blob: %v, want not exist", err)
+		}
+		if err := s.Rename("missing.cache", "to.cache"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("rename missing blob: %v, want not exist", err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		commit(t, s, "delete.cache", "x")
+		for range 2 {
+			if err := s.Delete("delete.cache"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, _, err := s.Open("delete.cache"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("open deleted blob: %v, want not exist", err)
+		}
+		if _, err := s.Size("delete.cache"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Size of deleted blob: %v, want not exist", err)
+		}
+	})
+
+	t.Run("walk", func(t *testing.T) {
+		w, err := s.Create("ab/uncommitted.cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Abort()
+
+		var names []string
+		if err := s.Walk(func(name string) { names = append(names, name) }); err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(names)
+		want := []string{"ab/cd/write.cache", "end.cache", "patch.cache", "replace.cache", "to.cache"}
+		if !slices.Equal(names, want) {
+			t.Errorf("walked %q, want %q", names, want)
+		}
+	})
+}
+
+func commit(t *testing.T, s Storage, name, data string) {
+	t.Helper()
+	w, err := s.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustWriteAt(t, w, data, 0)
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustWriteAt(t *testing.T, w io.WriterAt, data string, off int64) {
+	t.Helper()
+	if _, err := w.WriteAt([]byte(data), off); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readAt(t *testing.T, r io.ReaderAt, n int, off int64) string {
+	t.Helper()
+	p := make([]byte, n)
+	if _, err := r.ReadAt(p, off); err != nil {
+		t.Fatal(err)
+	}
+	return string(p)
+}
+
+func readAll(t *testing.T, s Storage, name string) string {
+	t.Helper()
+	r, size, err := s.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	return readAt(t, r, int(size), 0)
+}
@@ -3,13 +3,13 @@ package main
 import (
 	"fmt"
 	"log"
-	"os"
 
 	"github.com/AmirAghaee/go-cdn-stack/edge/internal/client"
 	"github.com/AmirAghaee/go-cdn-stack/edge/internal/config"
 	"github.com/AmirAghaee/go-cdn-stack/edge/internal/handler/http"
 	"github.com/AmirAghaee/go-cdn-stack/edge/internal/repository"
 	"github.com/AmirAghaee/go-cdn-stack/edge/internal/service"
+	"github.com/AmirAghaee/go-cdn-stack/edge/internal/storage"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/gin-gonic/gin"
@@ -21,30 +21,36 @@ func main() {
 	// Load configuration
 	cfg := config.Load()
 
-	// Ensure cache directory exists
-	if err := os.MkdirAll(cfg.CacheDir, 0755); err != nil {
-		panic(err)
+	// setup cache storage
+	cacheStorage, err := storage.New(cfg)
+	if err != nil {
+		log.Fatalf("cache storage: %v", err)
 	}
 
 	// setup clients
-	midClient := client.NewMidClient(cfg.MidInternalURL)
+	midClient := client.NewMidClient(cfg.MidInternalURLs)
 
 	// setup repository
 	cdnRepository := repository.NewCdnRepository()
-	cacheItemRepository := repository.NewCacheItemRepository(cfg)
+	midRepository := repository.NewMidRepository(cfg.MidCacheURLs)
+	cacheItemRepository := repository.NewCacheItemRepository(cfg, cacheStorage)
 
 	// setup services
-	cacheService := service.NewCacheService(cfg, cdnRepository, cacheItemRepository)
+	upstreamPool := service.NewUpstreamPool(cfg, midRepository)
+	cacheService := service.NewCacheService(cfg, cdnRepository, cacheItemRepository, upstreamPool)
+	purgeService := service.NewPurgeService(cdnRepository, cacheItemRepository)
+	prefetchService := service.NewPrefetchService(cfg, cfg.AppName, cfg.AppCacheURL)
+	adminService := service.NewAdminService(cfg, cdnRepository, cacheItemRepository)
 
 	// Load existing cache and start cleaner
 	cacheItemRepository.LoadFromDisk()
 	cacheItemRepository.StartCleaner()
 
 	//  setup services
-	midService := service.NewMidService(midClient, cdnRepository, cfg, cfg.AppName, cfg.AppCacheURL, AppVersion)
+	midService := service.NewMidService(midClient, cdnRepository, midRepository, purgeService, prefetchService, cfg, cfg.AppName, cfg.AppCacheURL, AppVersion)
 	midService.StartSubmitHeartbeat()
 
-	go startInternalPort(cfg)
+	go startInternalPort(cfg, adminService)
 
 	// Setup HTTP server
 	gin.SetMode(cfg.GinMode)
@@ -57,9 +63,10 @@ func main() {
 	}
 }
 
-func startInternalPort(cfg *config.Config) {
+func startInternalPort(cfg *config.Config, adminService service.AdminServiceInterface) {
 	r := gin.Default()
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	http.RegisterAdminRoutes(r, adminService)
 
 	fmt.Printf("Internal Edge API running on %s\n", cfg.AppInternalURL)
 	if err := r.Run(cfg.AppInternalURL); err != nil {
@@ -19,4 +19,6 @@ func NewEdgeHandler(edgeService service.EdgeServiceInterface) *EdgeHandler {
 func (h *EdgeHandler) Register(r *gin.Engine) {
 	r.POST("/edge/submit", h.edgeService.Register)
 	r.GET("/edge/cdns", h.edgeService.GetCdns)
+	r.POST("/edge/purge", h.edgeService.ReportPurge)
+	r.POST("/edge/prefetch", h.edgeService.ReportPrefetch)
 }
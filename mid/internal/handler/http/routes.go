@@ -13,3 +13,7 @@ func RegisterCacheRoutes(r *gin.Engine, cacheSvc service.CacheServiceInterface)
 func RegisterInternalRoutes(r *gin.Engine, cacheSvc service.EdgeServiceInterface) {
 	NewEdgeHandler(cacheSvc).Register(r)
 }
+
+func RegisterAdminRoutes(r *gin.Engine, adminSvc service.AdminServiceInterface) {
+	NewAdminHandler(adminSvc).Register(r)
+}
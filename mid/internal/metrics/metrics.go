@@ -41,6 +41,14 @@ var (
 		[]string{"host"},
 	)
 
+	CacheBypasses = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mid_cache_bypasses_total",
+			Help: "Total number of requests a cache rule bypassed the cache for",
+		},
+		[]string{"host"},
+	)
+
 	CacheSize = promauto.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "mid_cache_size_bytes",
@@ -55,6 +63,116 @@ var (
 		},
 	)
 
+	// Storage tier metrics
+	CacheTierHits = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mid_cache_tier_hits_total",
+			Help: "Total number of cache hits by the storage tier the body was read from",
+		},
+		[]string{"host", "tier"},
+	)
+
+	MemoryTierSize = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mid_cache_memory_tier_bytes",
+			Help: "Current size in bytes of the bodies held in the memory tier",
+		},
+	)
+
+	MemoryTierItems = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mid_cache_memory_tier_items",
+			Help: "Current number of bodies held in the memory tier",
+		},
+	)
+
+	MemoryTierMoves = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mid_cache_memory_tier_moves_total",
+			Help: "Total number of bodies promoted to or demoted from the memory tier",
+		},
+		[]string{"direction"},
+	)
+
+	// Request coalescing metrics
+	CollapsedRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mid_cache_collapsed_requests_total",
+			Help: "Total number of cache misses collapsed into an in-flight fetch",
+		},
+		[]string{"host"},
+	)
+
+	CacheLockTimeouts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mid_cache_lock_timeouts_total",
+			Help: "Total number of collapsed requests that timed out waiting for the in-flight fetch",
+		},
+		[]string{"host"},
+	)
+
+	// Conditional revalidation metrics
+	Revalidations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mid_cache_revalidations_total",
+			Help: "Total number of expired cache items revalidated upstream",
+		},
+		[]string{"host", "result"},
+	)
+
+	// Stale serving metrics
+	StaleServed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mid_cache_stale_served_total",
+			Help: "Total number of expired cache items served stale",
+		},
+		[]string{"host", "reason"},
+	)
+
+	// Purge metrics
+	PurgedItems = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mid_cache_purged_items_total",
+			Help: "Total number of cache items removed by purge jobs",
+		},
+		[]string{"host"},
+	)
+
+	// Eviction metrics
+	EvictedItems = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mid_cache_evicted_items_total",
+			Help: "Total number of cache items evicted before they expired",
+		},
+		[]string{"reason"},
+	)
+
+	EvictedBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mid_cache_evicted_bytes_total",
+			Help: "Total number of bytes freed on disk by evictions",
+		},
+		[]string{"reason"},
+	)
+
+	// Compression metrics
+	CompressedResponses = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mid_compressed_responses_total",
+			Help: "Total number of upstream responses compressed by the cache",
+		},
+		[]string{"host", "encoding"},
+	)
+
+	// Prefetch metrics
+	PrefetchRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mid_prefetch_requests_total",
+			Help: "Total number of URLs fetched by prefetch jobs",
+		},
+		[]string{"host", "result"},
+	)
+
 	// Origin request metrics
 	OriginRequestsTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -73,6 +191,23 @@ var (
 		[]string{"host", "status"},
 	)
 
+	// Origin pool metrics
+	OriginUp = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mid_origin_up",
+			Help: "Whether cache fills are sent to an origin (1) or it is skipped after failing (0)",
+		},
+		[]string{"host", "origin"},
+	)
+
+	OriginFailovers = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mid_origin_failovers_total",
+			Help: "Total number of requests to an origin that failed or got a 5xx and moved on to the next origin",
+		},
+		[]string{"host", "origin"},
+	)
+
 	// Bandwidth metrics
 	BytesSent = promauto.NewCounterVec(
 		prometheus.CounterOpts{
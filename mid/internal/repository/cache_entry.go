@@ -0,0 +1,284 @@
+package repository
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/storage"
+)
+
+// A cache entry is a single blob holding a fixed-size preamble, the item
+// metadata as a JSON header block and the body:
+//
+//	magic    [8]byte  entryMagic
+//	offset   uint32   where the body starts
+//	length   uint32   length of the header block
+//	checksum uint32   CRC-32 of the header block
+//	_        uint32   reserved
+//	size     int64    length of the body
+//	header   [length]byte
+//	padding  up to offset
+//	body     [size]byte
+//
+// The header block is padded so it can be rewritten without moving the body,
+// once the body is complete, which followers of a fill are already reading
+// from the blob being written, and when a revalidation refreshes the item.
+const (
+	entryMagic   = "CDNENT01"
+	preambleSize = 32
+	headerSlack  = 1024
+	headerAlign  = 4096
+)
+
+var (
+	// ErrCorruptEntry is returned for entries that are truncated or fail
+	// validation.
+	ErrCorruptEntry = errors.New("corrupt cache entry")
+	// errHeaderTooLarge is returned for headers outgrowing the room reserved
+	// for them before the body.
+	errHeaderTooLarge = errors.New("cache entry header does not fit")
+)
+
+// EntryWriter writes a cache entry that atomically replaces the stored one
+// on Commit.
+type EntryWriter struct {
+	blob   storage.Writer
+	offset int64
+	size   int64
+}
+
+// createEntry starts writing the entry for item to the blob name.
+func createEntry(store storage.Storage, name string, item *domain.CacheItem) (*EntryWriter, error) {
+	header, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := store.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &EntryWriter{
+		blob:   blob,
+		offset: (preambleSize + int64(len(header)) + headerSlack + headerAlign - 1) / headerAlign * headerAlign,
+	}
+	if err := w.writeHead(header); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends p to the body.
+func (w *EntryWriter) Write(p []byte) (int, error) {
+	n, err := w.blob.WriteAt(p, w.offset+w.size)
+	w.size += int64(n)
+	return n, err
+}
+
+// OpenPartial opens the body written so far for reading while the entry is
+// still being written.
+func (w *EntryWriter) OpenPartial() (*PartialEntry, error) {
+	blob, err := w.blob.OpenPartial()
+	if err != nil {
+		return nil, err
+	}
+	return &PartialEntry{blob: blob, offset: w.offset}, nil
+}
+
+// Commit stores item as the header of the written body and makes the entry
+// visible.
+func (w *EntryWriter) Commit(item *domain.CacheItem) error {
+	header, err := json.Marshal(item)
+	if err == nil {
+		err = w.writeHead(header)
+	}
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	return w.blob.Commit()
+}
+
+// Abort discards the entry.
+func (w *EntryWriter) Abort() {
+	w.blob.Abort()
+}
+
+func (w *EntryWriter) writeHead(header []byte) error {
+	head, err := encodeHead(w.offset, w.size, header)
+	if err != nil {
+		return err
+	}
+	_, err = w.blob.WriteAt(head, 0)
+	return err
+}
+
+// encodeHead returns the preamble and header block of an entry whose body of
+// size bytes starts at offset.
+func encodeHead(offset int64, size int64, header []byte) ([]byte, error) {
+	if preambleSize+int64(len(header)) > offset {
+		return nil, fmt.Errorf("%w: %d bytes", errHeaderTooLarge, len(header))
+	}
+
+	head := make([]byte, preambleSize+len(header))
+	copy(head, entryMagic)
+	binary.BigEndian.PutUint32(head[8:], uint32(offset))
+	binary.BigEndian.PutUint32(head[12:], uint32(len(header)))
+	binary.BigEndian.PutUint32(head[16:], crc32.ChecksumIEEE(header))
+	binary.BigEndian.PutUint64(head[24:], uint64(size))
+	copy(head[preambleSize:], header)
+	return head, nil
+}
+
+// PartialEntry reads the body of an entry while it is being written.
+type PartialEntry struct {
+	blob   storage.Reader
+	offset int64
+}
+
+func (p *PartialEntry) ReadAt(b []byte, off int64) (int, error) {
+	return p.blob.ReadAt(b, p.offset+off)
+}
+
+func (p *PartialEntry) Close() error {
+	return p.blob.Close()
+}
+
+// writeEntry atomically writes the entry for item to the blob name with the
+// body read from body, which may be nil.
+func writeEntry(store storage.Storage, name string, item *domain.CacheItem, body io.Reader) error {
+	w, err := createEntry(store, name, item)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		if _, err := io.Copy(w, body); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	return w.Commit(item)
+}
+
+// patchEntry replaces the header of the entry stored in the blob name with
+// item, leaving the body where it is. It fails with errHeaderTooLarge
+// when item does not fit the room reserved for the header.
+func patchEntry(store storage.Storage, name string, item *domain.CacheItem) error {
+	header, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	blob, _, err := store.Open(name)
+	if err != nil {
+		return err
+	}
+	p, err := readPreamble(blob)
+	_ = blob.Close()
+	if err != nil {
+		return err
+	}
+
+	head, err := encodeHead(p.offset, p.size, header)
+	if err != nil {
+		return err
+	}
+	return store.Patch(name, head)
+}
+
+// Entry is the body of a cache entry opened for reading.
+type Entry struct {
+	*io.SectionReader
+	blob storage.Reader
+}
+
+func (e *Entry) Close() error {
+	return e.blob.Close()
+}
+
+// openEntry opens the body of the entry stored in the blob name.
+func openEntry(store storage.Storage, name string) (*Entry, error) {
+	blob, _, err := store.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// The header is not needed to read the body
+	p, err := readPreamble(blob)
+	if err != nil {
+		_ = blob.Close()
+		return nil, err
+	}
+	return &Entry{SectionReader: io.NewSectionReader(blob, p.offset, p.size), blob: blob}, nil
+}
+
+// readEntry reads and validates the header of the entry stored in the blob
+// name, and returns it along with the size of the blob.
+func readEntry(store storage.Storage, name string) (*domain.CacheItem, int64, error) {
+	blob, blobSize, err := store.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer blob.Close()
+
+	p, err := readPreamble(blob)
+	if err != nil {
+		return nil, 0, err
+	}
+	if blobSize != p.offset+p.size {
+		return nil, 0, ErrCorruptEntry
+	}
+	header, err := readHeader(blob, p)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var item domain.CacheItem
+	if err := json.Unmarshal(header, &item); err != nil {
+		return nil, 0, ErrCorruptEntry
+	}
+	return &item, blobSize, nil
+}
+
+// preamble is the fixed-size start of an entry.
+type preamble struct {
+	offset   int64 // where the body starts
+	length   int64 // of the header block
+	checksum uint32
+	size     int64 // of the body
+}
+
+// readPreamble reads the preamble of the entry in blob.
+func readPreamble(blob io.ReaderAt) (preamble, error) {
+	head := make([]byte, preambleSize)
+	if _, err := blob.ReadAt(head, 0); err != nil || string(head[:8]) != entryMagic {
+		return preamble{}, ErrCorruptEntry
+	}
+
+	p := preamble{
+		offset:   int64(binary.BigEndian.Uint32(head[8:])),
+		length:   int64(binary.BigEndian.Uint32(head[12:])),
+		checksum: binary.BigEndian.Uint32(head[16:]),
+		size:     int64(binary.BigEndian.Uint64(head[24:])),
+	}
+	if preambleSize+p.length > p.offset || p.size < 0 {
+		return preamble{}, ErrCorruptEntry
+	}
+	return p, nil
+}
+
+// readHeader reads and validates the header block of the entry in blob.
+func readHeader(blob io.ReaderAt, p preamble) ([]byte, error) {
+	header := make([]byte, p.length)
+	if _, err := blob.ReadAt(header, preambleSize); err != nil || crc32.ChecksumIEEE(header) != p.checksum {
+		return nil, ErrCorruptEntry
+	}
+	return header, nil
+}
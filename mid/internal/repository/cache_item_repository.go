@@ -1,53 +1,72 @@
 package repository
 
 import (
-	"encoding/hex"
-	"encoding/json"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"os"
-	"path/filepath"
-	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/AmirAghaee/go-cdn-stack/mid/internal/config"
 	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
 	"github.com/AmirAghaee/go-cdn-stack/mid/internal/metrics"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/storage"
 	"github.com/dgraph-io/ristretto"
 )
 
 type CacheItemRepositoryInterface interface {
 	Get(key string) (*domain.CacheItem, bool)
+	Open(item *domain.CacheItem) (Body, string, error)
+	FilePath(key string) string
+	CreateEntry(item *domain.CacheItem) (*EntryWriter, error)
+	WriteEntry(item *domain.CacheItem, body io.Reader) error
+	UpdateEntry(item *domain.CacheItem) error
 	Set(key string, item *domain.CacheItem)
 	Delete(key string)
+	DeleteMatching(match func(key string) bool) int
+	DeleteTagged(tags []string, match func(key string) bool) int
+	Inspect(key string) (CacheEntryInfo, bool)
+	List(prefix, after string, limit int) []CacheEntryInfo
+	Usage() map[string]CacheUsage
 	LoadFromDisk()
 	StartCleaner()
 }
 
 type cacheItemRepository struct {
-	cache  *ristretto.Cache
-	config *config.Config
+	cache    *ristretto.Cache
+	tags     *tagIndex
+	usage    *diskUsage
+	memory   *memoryTier
+	evicting atomic.Bool
+	storage  storage.Storage
+	config   *config.Config
 }
 
-func NewCacheItemRepository(cfg *config.Config) CacheItemRepositoryInterface {
+func NewCacheItemRepository(cfg *config.Config, store storage.Storage) CacheItemRepositoryInterface {
+	r := &cacheItemRepository{
+		tags:    newTagIndex(),
+		usage:   newDiskUsage(),
+		memory:  newMemoryTier(cfg.MemoryCacheMaxSize, cfg.MemoryCacheMaxObject),
+		storage: store,
+		config:  cfg,
+	}
+
 	// Ristretto config
 	cache, err := ristretto.NewCache(&ristretto.Config{
 		NumCounters: 1e7,     // Adjust based on expected key count
 		MaxCost:     1 << 28, // 256MB max (adjust depending on memory)
 		BufferItems: 64,
-		OnEvict: func(item *ristretto.Item) {
-			// Update metrics when items are evicted
-			go updateCacheMetrics(cfg.CacheDir)
-		},
+		OnEvict:     r.onEvict,
+		OnReject:    r.onReject,
 	})
 	if err != nil {
 		panic(err)
 	}
+	r.cache = cache
 
-	return &cacheItemRepository{
-		cache:  cache,
-		config: cfg,
-	}
+	return r
 }
 
 func (r *cacheItemRepository) Get(key string) (*domain.CacheItem, bool) {
@@ -56,140 +75,319 @@ func (r *cacheItemRepository) Get(key string) (*domain.CacheItem, bool) {
 		return nil, false
 	}
 
+	r.usage.touch(key)
 	return value.(*domain.CacheItem), true
 }
 
+// Open opens the body of a cached item and returns the tier it is read from.
+// Bodies held in memory are served from there; others are read from storage
+// and promoted to memory when they are small and were hit often enough.
+func (r *cacheItemRepository) Open(item *domain.CacheItem) (Body, string, error) {
+	if data, ok := r.memory.get(item.Key, item); ok {
+		return memoryReader{bytes.NewReader(data)}, TierMemory, nil
+	}
+
+	entry, err := openEntry(r.storage, item.FilePath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if hits, ok := r.usage.hits(item.Key, item); ok && hits >= r.config.MemoryCacheMinHits && r.memory.admits(entry.Size()) {
+		data := make([]byte, entry.Size())
+		if _, err := entry.ReadAt(data, 0); err == nil {
+			r.memory.promote(item.Key, item, data)
+		}
+	}
+	return entry, TierDisk, nil
+}
+
+// CreateEntry starts writing the entry of item, which replaces the stored one
+// once committed.
+func (r *cacheItemRepository) CreateEntry(item *domain.CacheItem) (*EntryWriter, error) {
+	return createEntry(r.storage, item.FilePath, item)
+}
+
+// WriteEntry atomically writes the entry of item with the body read from
+// body, which may be nil.
+func (r *cacheItemRepository) WriteEntry(item *domain.CacheItem, body io.Reader) error {
+	return writeEntry(r.storage, item.FilePath, item, body)
+}
+
+// UpdateEntry replaces the header of the stored entry of item, keeping its
+// body. Only headers outgrowing the room reserved for them make the entry be
+// rewritten along with its body.
+func (r *cacheItemRepository) UpdateEntry(item *domain.CacheItem) error {
+	err := patchEntry(r.storage, item.FilePath, item)
+	if !errors.Is(err, errHeaderTooLarge) {
+		return err
+	}
+
+	entry, err := openEntry(r.storage, item.FilePath)
+	if err != nil {
+		return err
+	}
+	defer entry.Close()
+
+	return writeEntry(r.storage, item.FilePath, item, entry)
+}
+
 func (r *cacheItemRepository) Set(key string, item *domain.CacheItem) {
-	ttl := time.Until(item.ExpiresAt)
+	size, _ := r.storage.Size(item.FilePath)
+	r.set(key, item, size)
+}
 
-	// If already expired, do nothing
+// set indexes item, whose entry takes size bytes in storage.
+func (r *cacheItemRepository) set(key string, item *domain.CacheItem, size int64) {
+	ttl := time.Until(r.retainUntil(item))
+
+	// If already past retention, do nothing
 	if ttl <= 0 {
 		return
 	}
 
+	// Track the item first so an eviction of the one it replaces leaves its entry alone
+	r.usage.add(key, item, size)
 	r.cache.SetWithTTL(key, item, 1, ttl)
+	r.tags.set(key, item)
+	// Make the item visible before the caller releases concurrent waiters
+	r.cache.Wait()
 
 	// Update metrics after successful set
-	go updateCacheMetrics(r.config.CacheDir)
+	r.updateCacheMetrics()
+
+	if r.config.CacheMaxSize > 0 && r.usage.size() > r.config.CacheHighWatermarkBytes {
+		go r.evict()
+	}
 }
 
 func (r *cacheItemRepository) Delete(key string) {
-	// Get the item before deleting to remove file
+	// Get the item before deleting to remove its entry
 	if value, ok := r.cache.Get(key); ok {
 		if item, ok := value.(*domain.CacheItem); ok {
-			_ = os.Remove(item.FilePath)
-			_ = os.Remove(item.FilePath + ".json")
+			_ = r.storage.Delete(item.FilePath)
 		}
 	}
 
 	r.cache.Del(key)
+	r.tags.remove(key)
+	r.usage.remove(key)
+	r.memory.remove(key)
 
 	// Update metrics after deletion
-	go updateCacheMetrics(r.config.CacheDir)
+	r.updateCacheMetrics()
 }
 
-func (r *cacheItemRepository) LoadFromDisk() {
-	files, err := os.ReadDir(r.config.CacheDir)
-	if err != nil {
-		fmt.Println("Error reading cache dir:", err)
-		return
-	}
-
+// DeleteMatching removes every item whose key matches from the cache and
+// storage and returns how many were removed. Every stored item is indexed, so
+// the index is searched rather than storage.
+func (r *cacheItemRepository) DeleteMatching(match func(key string) bool) int {
 	count := 0
-	for _, f := range files {
-		if f.IsDir() || !strings.HasSuffix(f.Name(), ".cache.json") {
+	for _, v := range r.usage.matching(match) {
+		if _, ok := r.usage.removeItem(v.key, v.item); !ok {
 			continue
 		}
 
-		metaFile := filepath.Join(r.config.CacheDir, f.Name())
-		data, err := os.ReadFile(metaFile)
-		if err != nil {
+		r.cache.Del(v.key)
+		r.tags.remove(v.key)
+		r.memory.remove(v.key)
+		_ = r.storage.Delete(v.item.FilePath)
+		count++
+	}
+
+	r.updateCacheMetrics()
+	return count
+}
+
+// DeleteTagged removes the items tagged with any of tags whose key matches
+// from the cache and storage, and returns how many were removed.
+func (r *cacheItemRepository) DeleteTagged(tags []string, match func(key string) bool) int {
+	count := 0
+	for key, item := range r.tags.lookup(tags) {
+		if !match(key) {
 			continue
 		}
-
-		var item domain.CacheItem
-		if err := json.Unmarshal(data, &item); err != nil {
+		// An item refilled meanwhile is not the one tagged
+		if _, ok := r.usage.removeItem(key, item); !ok {
 			continue
 		}
 
-		if time.Now().Before(item.ExpiresAt) {
-			hexName := strings.TrimSuffix(f.Name(), ".cache.json")
-			if keyBytes, err := hex.DecodeString(hexName); err == nil {
-				r.Set(string(keyBytes), &item)
-				count++
+		r.cache.Del(key)
+		r.tags.remove(key)
+		r.memory.remove(key)
+		_ = r.storage.Delete(item.FilePath)
+		count++
+	}
+
+	r.updateCacheMetrics()
+	return count
+}
+
+// Inspect returns the item stored under key without counting an access.
+func (r *cacheItemRepository) Inspect(key string) (CacheEntryInfo, bool) {
+	return r.usage.info(key)
+}
+
+// List returns up to limit items whose key starts with prefix, in key order,
+// starting after the key after.
+func (r *cacheItemRepository) List(prefix, after string, limit int) []CacheEntryInfo {
+	return r.usage.list(prefix, after, limit)
+}
+
+// Usage returns how much of the cache every domain takes.
+func (r *cacheItemRepository) Usage() map[string]CacheUsage {
+	return r.usage.usageByDomain()
+}
+
+// LoadFromDisk indexes the entries found in storage, quarantining corrupt
+// ones and removing those past retention.
+func (r *cacheItemRepository) LoadFromDisk() {
+	if migrated := r.migrateSidecars(); migrated > 0 {
+		fmt.Printf("Migrated %d cache items to single-file entries\n", migrated)
+	}
+
+	count, quarantined := 0, 0
+	r.walkEntries(func(name string) {
+		item, size, err := readEntry(r.storage, name)
+		if err != nil || item.Key == "" {
+			log.Printf("Quarantining corrupt cache entry %s: %v", name, err)
+			if r.quarantine(name) != nil {
+				_ = r.storage.Delete(name)
 			}
+			quarantined++
+			return
 		}
-	}
 
-	fmt.Printf("Loaded %d cache items from disk\n", count)
+		item.FilePath = name
+		if time.Now().Before(r.retainUntil(item)) {
+			r.set(item.Key, item, size)
+			count++
+		} else {
+			_ = r.storage.Delete(name)
+		}
+	})
+
+	fmt.Printf("Loaded %d cache items from storage, quarantined %d\n", count, quarantined)
 
 	// Update metrics after loading
-	updateCacheMetrics(r.config.CacheDir)
+	r.updateCacheMetrics()
 }
 
+// StartCleaner periodically removes the entries of items past retention.
+// Storage is only walked on load, so the cleaner goes through the index.
 func (r *cacheItemRepository) StartCleaner() {
 	go func() {
 		ticker := time.NewTicker(r.config.CleanerIntervalDuration)
 		defer ticker.Stop()
 
 		for range ticker.C {
-			files, _ := os.ReadDir(r.config.CacheDir)
 			deletedCount := 0
 
-			for _, f := range files {
-				if !strings.HasSuffix(f.Name(), ".cache.json") {
-					continue
-				}
-
-				metaFile := filepath.Join(r.config.CacheDir, f.Name())
-				data, err := os.ReadFile(metaFile)
-				if err != nil {
+			for _, v := range r.usage.expired(time.Now(), r.retainUntil) {
+				if _, ok := r.usage.removeItem(v.key, v.item); !ok {
 					continue
 				}
 
-				var item domain.CacheItem
-				if json.Unmarshal(data, &item) != nil {
-					continue
-				}
-
-				if time.Now().After(item.ExpiresAt) {
-					_ = os.Remove(item.FilePath)
-					_ = os.Remove(metaFile)
-					deletedCount++
-					log.Printf("Deleted expired cache (disk): %s", item.FilePath)
-				}
+				r.cache.Del(v.key)
+				r.tags.remove(v.key)
+				r.memory.remove(v.key)
+				_ = r.storage.Delete(v.item.FilePath)
+				deletedCount++
+				log.Printf("Deleted expired cache entry: %s", v.item.FilePath)
 			}
 
 			// Update metrics after cleanup if anything was deleted
 			if deletedCount > 0 {
-				updateCacheMetrics(r.config.CacheDir)
+				r.updateCacheMetrics()
 			}
 		}
 	}()
 }
 
-// updateCacheMetrics calculates and updates cache size and item count metrics
-func updateCacheMetrics(cacheDir string) {
-	files, err := os.ReadDir(cacheDir)
-	if err != nil {
+// evict removes items, least recently or least frequently used first
+// depending on the eviction policy, until the cache is back under its low
+// watermark. Only one eviction runs at a time.
+func (r *cacheItemRepository) evict() {
+	if !r.evicting.CompareAndSwap(false, true) {
 		return
 	}
+	defer r.evicting.Store(false)
 
-	var totalSize int64
-	var itemCount int
-
-	for _, f := range files {
-		if f.IsDir() || !strings.HasSuffix(f.Name(), ".cache") {
+	count := 0
+	for _, v := range r.usage.victims(r.config.CacheLowWatermarkBytes, r.config.CacheEvictionPolicy) {
+		if _, ok := r.usage.removeItem(v.key, v.item); !ok {
 			continue
 		}
 
-		filePath := filepath.Join(cacheDir, f.Name())
-		if info, err := os.Stat(filePath); err == nil {
-			totalSize += info.Size()
-			itemCount++
+		r.cache.Del(v.key)
+		r.tags.remove(v.key)
+		r.memory.remove(v.key)
+		_ = r.storage.Delete(v.item.FilePath)
+		metrics.EvictedItems.WithLabelValues("disk").Inc()
+		metrics.EvictedBytes.WithLabelValues("disk").Add(float64(v.size))
+		count++
+	}
+
+	if count > 0 {
+		log.Printf("Evicted %d cache items over the disk budget", count)
+		r.updateCacheMetrics()
+	}
+}
+
+// onEvict removes the entries of items ristretto drops from the index,
+// whether they expired or were evicted, so none are left behind in storage.
+func (r *cacheItemRepository) onEvict(item *ristretto.Item) {
+	size, ok := r.drop(item)
+	// Items dropped before they expire were evicted rather than cleaned up
+	if ok && (item.Expiration.IsZero() || time.Now().Before(item.Expiration)) {
+		metrics.EvictedItems.WithLabelValues("index").Inc()
+		metrics.EvictedBytes.WithLabelValues("index").Add(float64(size))
+	}
+}
+
+// onReject removes the entries of items ristretto never admitted to the
+// index. They were not cached, so are not counted as evicted.
+func (r *cacheItemRepository) onReject(item *ristretto.Item) {
+	r.drop(item)
+}
+
+func (r *cacheItemRepository) drop(item *ristretto.Item) (int64, bool) {
+	cached, ok := item.Value.(*domain.CacheItem)
+	if !ok {
+		return 0, false
+	}
+	size, ok := r.usage.removeItem(cached.Key, cached)
+	if !ok {
+		return 0, false
+	}
+
+	r.tags.remove(cached.Key)
+	r.memory.remove(cached.Key)
+	_ = r.storage.Delete(cached.FilePath)
+	r.updateCacheMetrics()
+	return size, true
+}
+
+// retainUntil returns when item is dropped. Expired items that carry
+// validators are kept for a while so they can be revalidated upstream
+// instead of downloaded again, and any item is kept for as long as it may
+// be served stale.
+func (r *cacheItemRepository) retainUntil(item *domain.CacheItem) time.Time {
+	until := item.ExpiresAt
+	if item.HasValidators() {
+		until = until.Add(r.config.CacheRetentionDuration)
+	}
+	for _, t := range []time.Time{item.StaleUntil, item.StaleIfErrorUntil} {
+		if t.After(until) {
+			until = t
 		}
 	}
+	return until
+}
 
-	metrics.CacheSize.Set(float64(totalSize))
-	metrics.CacheItems.Set(float64(itemCount))
+// updateCacheMetrics updates the cache size and item count metrics from the
+// index
+func (r *cacheItemRepository) updateCacheMetrics() {
+	size, items := r.usage.totals()
+	metrics.CacheSize.Set(float64(size))
+	metrics.CacheItems.Set(float64(items))
 }
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/metrics"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/storage"
+	"github.com/dgraph-io/ristretto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestIndexDrops(t *testing.T) {
+	store := storage.NewMemory()
+	r := NewCacheItemRepository(&config.Config{}, store).(*cacheItemRepository)
+	defer r.cache.Close()
+
+	stored := func(key string) *domain.CacheItem {
+		item := &domain.CacheItem{Key: key, FilePath: key + ".cache"}
+		w, err := store.Create(item.FilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.WriteAt([]byte("body"), 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		r.usage.add(key, item, 4)
+		return item
+	}
+	evicted := func() float64 {
+		var m dto.Metric
+		if err := metrics.EvictedItems.WithLabelValues("index").Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetCounter().GetValue()
+	}
+	gone := func(item *domain.CacheItem) bool {
+		_, err := store.Size(item.FilePath)
+		return errors.Is(err, fs.ErrNotExist)
+	}
+	before := evicted()
+
+	// Items never admitted are removed but were not cached, so not evicted
+	rejected := stored("example.com/rejected")
+	r.onReject(&ristretto.Item{Value: rejected})
+	if !gone(rejected) || evicted() != before {
+		t.Errorf("rejected item removed %v, evictions %v, want removed and %v", gone(rejected), evicted(), before)
+	}
+
+	expired := stored("example.com/expired")
+	r.onEvict(&ristretto.Item{Value: expired, Expiration: time.Now().Add(-time.Second)})
+	if !gone(expired) || evicted() != before {
+		t.Errorf("expired item removed %v, evictions %v, want removed and %v", gone(expired), evicted(), before)
+	}
+
+	dropped := stored("example.com/dropped")
+	r.onEvict(&ristretto.Item{Value: dropped, Expiration: time.Now().Add(time.Hour)})
+	if !gone(dropped) || evicted() != before+1 {
+		t.Errorf("evicted item removed %v, evictions %v, want removed and %v", gone(dropped), evicted(), before+1)
+	}
+}
+
+func TestDeleteTagged(t *testing.T) {
+	store := storage.NewMemory()
+	r := NewCacheItemRepository(&config.Config{}, store).(*cacheItemRepository)
+	defer r.cache.Close()
+
+	set := func(key, filePath string, tags ...string) *domain.CacheItem {
+		item := &domain.CacheItem{Key: key, FilePath: filePath, Tags: tags, ExpiresAt: time.Now().Add(time.Hour)}
+		if err := r.WriteEntry(item, nil); err != nil {
+			t.Fatal(err)
+		}
+		r.Set(key, item)
+		return item
+	}
+	all := func(string) bool { return true }
+
+	set("example.com/a.css", "a.cache", "css")
+	set("example.com/b.js", "b.cache", "js")
+	if n := r.DeleteTagged([]string{"css"}, all); n != 1 {
+		t.Errorf("purged %d items tagged css, want 1", n)
+	}
+	if _, ok := r.Inspect("example.com/a.css"); ok {
+		t.Error("tagged item still indexed")
+	}
+	if _, ok := r.Inspect("example.com/b.js"); !ok {
+		t.Error("item with other tags purged")
+	}
+
+	// A purge that found the item before it was refilled leaves the new one
+	old := set("example.com/c.css", "c1.cache", "css")
+	set("example.com/c.css", "c2.cache", "css")
+	r.tags.set("example.com/c.css", old)
+	if n := r.DeleteTagged([]string{"css"}, all); n != 0 {
+		t.Errorf("purged %d items, want the refilled one kept", n)
+	}
+	if info, ok := r.Inspect("example.com/c.css"); !ok || info.Item.FilePath != "c2.cache" {
+		t.Error("refilled item dropped from the index")
+	}
+	if _, err := store.Size("c2.cache"); err != nil {
+		t.Errorf("refilled entry: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+)
+
+// quarantineDir is where entries failing validation are moved.
+const quarantineDir = "quarantine"
+
+// FilePath returns the name of the blob the entry of key is stored in. Blobs
+// are named after the SHA-256 of the key and spread over two directory
+// levels, so neither long keys nor large caches hit file name or directory
+// size limits. Each entry holds the metadata, which records the key, followed
+// by the body.
+func (r *cacheItemRepository) FilePath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return path.Join(name[:2], name[2:4], name+".cache")
+}
+
+// walkEntries calls fn with the blob name of every entry in the cache.
+func (r *cacheItemRepository) walkEntries(fn func(name string)) {
+	_ = r.storage.Walk(func(name string) {
+		if strings.HasSuffix(name, ".cache") && !strings.HasPrefix(name, quarantineDir+"/") {
+			fn(name)
+		}
+	})
+}
+
+// quarantine moves a corrupt entry out of the cache, keeping it for
+// inspection.
+func (r *cacheItemRepository) quarantine(name string) error {
+	return r.storage.Rename(name, path.Join(quarantineDir, path.Base(name)))
+}
+
+// migrateSidecars converts items of the former layouts, where the metadata
+// was kept in a ".json" file next to the body, to single-file entries and
+// returns how many were converted. Files were named after the hex-encoded key
+// directly in CacheDir at first, and after its SHA-256 in sharded directories
+// later. Bodies without metadata are removed.
+func (r *cacheItemRepository) migrateSidecars() int {
+	count := 0
+	_ = filepath.WalkDir(r.config.CacheDir, func(metaFile string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(d.Name(), ".cache.json") {
+			return nil
+		}
+
+		bodyFile := strings.TrimSuffix(metaFile, ".json")
+		item, ok := readSidecar(metaFile)
+		if ok && filepath.Dir(metaFile) == filepath.Clean(r.config.CacheDir) {
+			keyBytes, err := hex.DecodeString(strings.TrimSuffix(d.Name(), ".cache.json"))
+			item.Key, ok = string(keyBytes), err == nil
+		}
+		if ok && item.Key != "" && r.convertSidecar(bodyFile, item) == nil {
+			count++
+		} else {
+			_ = os.Remove(bodyFile)
+		}
+		_ = os.Remove(metaFile)
+		return nil
+	})
+
+	// Leftover bodies of the flat layout have no metadata to recover them from
+	files, _ := os.ReadDir(r.config.CacheDir)
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".cache") {
+			_ = os.Remove(filepath.Join(r.config.CacheDir, f.Name()))
+		}
+	}
+
+	return count
+}
+
+// convertSidecar writes the entry of item with the body read from bodyFile.
+func (r *cacheItemRepository) convertSidecar(bodyFile string, item *domain.CacheItem) error {
+	item.FilePath = r.FilePath(item.Key)
+	entryFile := filepath.Join(r.config.CacheDir, filepath.FromSlash(item.FilePath))
+
+	// Vary index items have no body of their own
+	var body io.Reader
+	file, err := os.Open(bodyFile)
+	if err == nil {
+		defer file.Close()
+		body = file
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := writeEntry(r.storage, item.FilePath, item, body); err != nil {
+		return err
+	}
+	if bodyFile != entryFile {
+		_ = os.Remove(bodyFile)
+	}
+	return nil
+}
+
+func readSidecar(metaFile string) (*domain.CacheItem, bool) {
+	data, err := os.ReadFile(metaFile)
+	if err != nil {
+		return nil, false
+	}
+
+	var item domain.CacheItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return nil, false
+	}
+	return &item, true
+}
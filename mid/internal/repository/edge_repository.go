@@ -2,31 +2,39 @@ package repository
 
 import (
 	"sync"
+	"time"
 
 	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
 )
 
 type EdgeRepositoryInterface interface {
-	Set(edge domain.Edge)
+	Set(edge domain.Edge) bool
 	SetAll([]domain.Edge)
 	GetAll() []domain.Edge
+	Expire(ttl time.Duration) []domain.Edge
 }
 
 type edgeRepository struct {
 	mu   sync.RWMutex
 	data map[string]domain.Edge
+	seen map[string]time.Time
 }
 
 func NewEdgeRepository() EdgeRepositoryInterface {
 	return &edgeRepository{
 		data: make(map[string]domain.Edge),
+		seen: make(map[string]time.Time),
 	}
 }
 
-func (r *edgeRepository) Set(edge domain.Edge) {
+// Set records a heartbeat of edge and reports whether the edge is new.
+func (r *edgeRepository) Set(edge domain.Edge) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	_, ok := r.data[edge.Service]
 	r.data[edge.Service] = edge
+	r.seen[edge.Service] = time.Now()
+	return !ok
 }
 
 func (r *edgeRepository) SetAll(edges []domain.Edge) {
@@ -34,10 +42,13 @@ func (r *edgeRepository) SetAll(edges []domain.Edge) {
 	defer r.mu.Unlock()
 
 	newMap := make(map[string]domain.Edge, len(edges))
+	seen := make(map[string]time.Time, len(edges))
 	for _, e := range edges {
 		newMap[e.Instance] = e
+		seen[e.Instance] = time.Now()
 	}
 	r.data = newMap
+	r.seen = seen
 }
 
 func (r *edgeRepository) GetAll() []domain.Edge {
@@ -50,3 +61,20 @@ func (r *edgeRepository) GetAll() []domain.Edge {
 	}
 	return result
 }
+
+// Expire removes and returns the edges not heard from within ttl, which
+// are down or have moved to another mid.
+func (r *edgeRepository) Expire(ttl time.Duration) []domain.Edge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var expired []domain.Edge
+	for key, e := range r.data {
+		if time.Since(r.seen[key]) >= ttl {
+			expired = append(expired, e)
+			delete(r.data, key)
+			delete(r.seen, key)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"sync"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+)
+
+// JobQueueInterface keeps the jobs of one kind each edge still has to run.
+// Edges receive their pending jobs with every heartbeat until they report them.
+type JobQueueInterface[J any] interface {
+	Add(edge string, job J)
+	Pending(edge string) []J
+	Done(edge string, jobID string)
+	Remove(edge string)
+}
+
+type (
+	PurgeRepositoryInterface    = JobQueueInterface[domain.PurgeJob]
+	PrefetchRepositoryInterface = JobQueueInterface[domain.PrefetchJob]
+)
+
+type jobQueue[J any] struct {
+	mu   sync.RWMutex
+	data map[string][]J
+	id   func(J) string
+}
+
+// NewJobQueue returns a queue of jobs identified by id.
+func NewJobQueue[J any](id func(J) string) JobQueueInterface[J] {
+	return &jobQueue[J]{
+		data: make(map[string][]J),
+		id:   id,
+	}
+}
+
+func NewPurgeRepository() PurgeRepositoryInterface {
+	return NewJobQueue(func(job domain.PurgeJob) string { return job.ID })
+}
+
+func NewPrefetchRepository() PrefetchRepositoryInterface {
+	return NewJobQueue(func(job domain.PrefetchJob) string { return job.ID })
+}
+
+// Add queues job for edge unless it is queued already.
+func (r *jobQueue[J]) Add(edge string, job J) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, queued := range r.data[edge] {
+		if r.id(queued) == r.id(job) {
+			return
+		}
+	}
+	r.data[edge] = append(r.data[edge], job)
+}
+
+func (r *jobQueue[J]) Pending(edge string) []J {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]J, len(r.data[edge]))
+	copy(result, r.data[edge])
+	return result
+}
+
+func (r *jobQueue[J]) Done(edge string, jobID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	jobs := r.data[edge][:0]
+	for _, job := range r.data[edge] {
+		if r.id(job) != jobID {
+			jobs = append(jobs, job)
+		}
+	}
+	if len(jobs) == 0 {
+		delete(r.data, edge)
+		return
+	}
+	r.data[edge] = jobs
+}
+
+// Remove drops the jobs of an edge that went away.
+func (r *jobQueue[J]) Remove(edge string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.data, edge)
+}
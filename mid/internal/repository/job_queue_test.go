@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+)
+
+func TestJobQueue(t *testing.T) {
+	q := NewPurgeRepository()
+	q.Add("edge1", domain.PurgeJob{ID: "a"})
+	q.Add("edge1", domain.PurgeJob{ID: "b"})
+	q.Add("edge2", domain.PurgeJob{ID: "a"})
+	q.Add("edge1", domain.PurgeJob{ID: "a"})
+
+	// Pending jobs are handed out until they are done
+	ids := func(edge string) []string {
+		var ids []string
+		for _, job := range q.Pending(edge) {
+			ids = append(ids, job.ID)
+		}
+		return ids
+	}
+	if got := ids("edge1"); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("pending %q, want [a b]", got)
+	}
+	if got := ids("edge1"); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("pending again %q, want [a b]", got)
+	}
+
+	q.Done("edge1", "a")
+	if got := ids("edge1"); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("pending after done %q, want [b]", got)
+	}
+	if got := ids("edge2"); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("pending of another edge %q, want [a]", got)
+	}
+
+	q.Done("edge1", "b")
+	q.Done("edge3", "a")
+	if got := q.Pending("edge1"); len(got) != 0 {
+		t.Errorf("pending after all done %v, want none", got)
+	}
+
+	q.Remove("edge2")
+	if got := q.Pending("edge2"); len(got) != 0 {
+		t.Errorf("pending of a removed edge %v, want none", got)
+	}
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"sync"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/metrics"
+)
+
+// Storage tiers cached bodies are read from
+const (
+	TierMemory = "memory"
+	TierDisk   = "disk"
+)
+
+// Body is the body of a cached item opened for reading.
+type Body interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+	Size() int64
+}
+
+// memoryTier holds copies of the bodies of small, frequently served items
+// within a byte budget. Their entries stay on disk, so the least recently
+// used bodies are demoted to make room by dropping the copy.
+type memoryTier struct {
+	mu            sync.Mutex
+	bodies        map[string]*list.Element
+	lru           *list.List // of *memoryBody, most recently used first
+	size          int64
+	maxSize       int64
+	maxObjectSize int64
+}
+
+type memoryBody struct {
+	key  string
+	item *domain.CacheItem // the item the body was read for
+	data []byte
+}
+
+func newMemoryTier(maxSize, maxObjectSize int64) *memoryTier {
+	return &memoryTier{
+		bodies:        make(map[string]*list.Element),
+		lru:           list.New(),
+		maxSize:       maxSize,
+		maxObjectSize: maxObjectSize,
+	}
+}
+
+// admits reports whether bodies of size may be held in memory.
+func (m *memoryTier) admits(size int64) bool {
+	return size > 0 && size <= min(m.maxObjectSize, m.maxSize)
+}
+
+// get returns the body of item held under key. Copies of items that were
+// replaced since are dropped.
+func (m *memoryTier) get(key string, item *domain.CacheItem) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.bodies[key]
+	if !ok {
+		return nil, false
+	}
+	body := elem.Value.(*memoryBody)
+	if body.item != item {
+		m.removeLocked(elem)
+		return nil, false
+	}
+
+	m.lru.MoveToFront(elem)
+	return body.data, true
+}
+
+// promote holds data as the body of item under key, demoting the least
+// recently used bodies until it fits.
+func (m *memoryTier) promote(key string, item *domain.CacheItem, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.bodies[key]; ok {
+		m.removeLocked(elem)
+	}
+	for m.size+int64(len(data)) > m.maxSize && m.lru.Len() > 0 {
+		m.removeLocked(m.lru.Back())
+		metrics.MemoryTierMoves.WithLabelValues("demote").Inc()
+	}
+
+	m.bodies[key] = m.lru.PushFront(&memoryBody{key: key, item: item, data: data})
+	m.size += int64(len(data))
+	metrics.MemoryTierMoves.WithLabelValues("promote").Inc()
+	m.updateMetricsLocked()
+}
+
+// remove drops the body held under key, if any.
+func (m *memoryTier) remove(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.bodies[key]; ok {
+		m.removeLocked(elem)
+		m.updateMetricsLocked()
+	}
+}
+
+func (m *memoryTier) removeLocked(elem *list.Element) {
+	body := m.lru.Remove(elem).(*memoryBody)
+	delete(m.bodies, body.key)
+	m.size -= int64(len(body.data))
+}
+
+func (m *memoryTier) updateMetricsLocked() {
+	metrics.MemoryTierSize.Set(float64(m.size))
+	metrics.MemoryTierItems.Set(float64(m.lru.Len()))
+}
+
+// memoryReader reads a body held in memory.
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error {
+	return nil
+}
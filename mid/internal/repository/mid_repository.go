@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MidRepositoryInterface holds the mids edges spread cache keys over, by the
+// cache address edges reach them at. Mids are known from their heartbeats.
+type MidRepositoryInterface interface {
+	Seen(mid string)
+	GetAll() []string
+}
+
+type midRepository struct {
+	mu      sync.RWMutex
+	seed    []string
+	seen    map[string]time.Time
+	ttl     time.Duration
+	started time.Time
+}
+
+// NewMidRepository returns a repository of the mids heard from within ttl.
+// Until it has been listening for ttl, or while no mid is heard from, it
+// holds the seed mids instead.
+func NewMidRepository(seed []string, ttl time.Duration) MidRepositoryInterface {
+	return &midRepository{
+		seed:    slices.Clone(seed),
+		seen:    make(map[string]time.Time),
+		ttl:     ttl,
+		started: time.Now(),
+	}
+}
+
+func (r *midRepository) Seen(mid string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seen[mid] = time.Now()
+}
+
+func (r *midRepository) GetAll() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.started) < r.ttl {
+		return slices.Clone(r.seed)
+	}
+	for mid, at := range r.seen {
+		if time.Since(at) >= r.ttl {
+			delete(r.seen, mid)
+		}
+	}
+	if len(r.seen) == 0 {
+		return slices.Clone(r.seed)
+	}
+	return slices.Sorted(maps.Keys(r.seen))
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+)
+
+func TestMidRepository(t *testing.T) {
+	r := NewMidRepository([]string{"seed:9060"}, time.Minute).(*midRepository)
+	r.Seen("mid2:9060")
+	r.Seen("mid1:9060")
+
+	// The seed is held until the mids had time to be heard from
+	if got := r.GetAll(); !slices.Equal(got, []string{"seed:9060"}) {
+		t.Errorf("mids while starting %q, want the seed", got)
+	}
+
+	r.started = time.Now().Add(-time.Minute)
+	if got := r.GetAll(); !slices.Equal(got, []string{"mid1:9060", "mid2:9060"}) {
+		t.Errorf("mids %q, want those heard from", got)
+	}
+
+	r.seen["mid2:9060"] = time.Now().Add(-time.Minute)
+	if got := r.GetAll(); !slices.Equal(got, []string{"mid1:9060"}) {
+		t.Errorf("mids %q, want the ones heard from lately", got)
+	}
+
+	r.seen["mid1:9060"] = time.Now().Add(-time.Minute)
+	if got := r.GetAll(); !slices.Equal(got, []string{"seed:9060"}) {
+		t.Errorf("mids with none heard from %q, want the seed", got)
+	}
+}
+
+func TestEdgeRepositoryExpire(t *testing.T) {
+	r := NewEdgeRepository().(*edgeRepository)
+	if !r.Set(domain.Edge{Service: "edge1"}) || !r.Set(domain.Edge{Service: "edge2"}) {
+		t.Error("new edges reported as known")
+	}
+	if r.Set(domain.Edge{Service: "edge1"}) {
+		t.Error("known edge reported as new")
+	}
+
+	r.seen["edge2"] = time.Now().Add(-time.Minute)
+	expired := r.Expire(time.Minute)
+	if len(expired) != 1 || expired[0].Service != "edge2" {
+		t.Errorf("expired %v, want edge2", expired)
+	}
+	if got := r.GetAll(); len(got) != 1 || got[0].Service != "edge1" {
+		t.Errorf("edges %v, want edge1", got)
+	}
+	if !r.Set(domain.Edge{Service: "edge2"}) {
+		t.Error("edge coming back reported as known")
+	}
+}
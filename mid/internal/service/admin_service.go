@@ -0,0 +1,196 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAdminPageSize = 100
+	maxAdminPageSize     = 1000
+)
+
+// AdminServiceInterface answers the cache inspection endpoints of the
+// internal port.
+type AdminServiceInterface interface {
+	GetItem(c *gin.Context)
+	ListItems(c *gin.Context)
+	DeleteItem(c *gin.Context)
+	Stats(c *gin.Context)
+}
+
+type adminService struct {
+	config              *config.Config
+	cdnRepository       repository.CdnRepositoryInterface
+	cacheItemRepository repository.CacheItemRepositoryInterface
+}
+
+func NewAdminService(config *config.Config, cdnRepo repository.CdnRepositoryInterface, cacheItemRepo repository.CacheItemRepositoryInterface) AdminServiceInterface {
+	return &adminService{
+		config:              config,
+		cdnRepository:       cdnRepo,
+		cacheItemRepository: cacheItemRepo,
+	}
+}
+
+// cacheItemView is a cached item as the inspection endpoints show it.
+type cacheItemView struct {
+	*domain.CacheItem
+	Fresh      bool      `json:"fresh"`
+	Size       int64     `json:"size"` // bytes on disk
+	Hits       int64     `json:"hits"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+func newCacheItemView(info repository.CacheEntryInfo) cacheItemView {
+	return cacheItemView{
+		CacheItem:  info.Item,
+		Fresh:      time.Now().Before(info.Item.ExpiresAt),
+		Size:       info.Size,
+		Hits:       info.Hits,
+		LastAccess: info.LastAccess,
+	}
+}
+
+// GetItem shows the item stored under the "key" query parameter, or under
+// the key requests for the "url" query parameter map to, along with the keys
+// of its variants and slices.
+func (s *adminService) GetItem(c *gin.Context) {
+	key, ok := s.itemKey(c)
+	if !ok {
+		return
+	}
+
+	variants := []string{}
+	for _, v := range s.cacheItemRepository.List(key+"#", "", maxAdminPageSize) {
+		variants = append(variants, v.Item.Key)
+	}
+
+	// Sliced objects only have items for their slices
+	var item *cacheItemView
+	if info, found := s.cacheItemRepository.Inspect(key); found {
+		view := newCacheItemView(info)
+		item = &view
+	} else if len(variants) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not cached", "key": key})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"key": key, "item": item, "variants": variants})
+}
+
+// ListItems pages through the cached items in key order. They are filtered
+// by the "domain" and "prefix" query parameters, the latter being a path
+// prefix when a domain is given and a key prefix otherwise. Pages hold
+// "limit" items and continue after the "cursor" key.
+func (s *adminService) ListItems(c *gin.Context) {
+	limit := defaultAdminPageSize
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = min(n, maxAdminPageSize)
+	}
+
+	// Keys of a domain continue with the path, so other domains sharing its
+	// name as a prefix are left out
+	prefix := c.Query("prefix")
+	if d := c.Query("domain"); d != "" {
+		prefix = d + "/" + strings.TrimPrefix(prefix, "/")
+	}
+
+	items := []cacheItemView{}
+	for _, info := range s.cacheItemRepository.List(prefix, c.Query("cursor"), limit) {
+		items = append(items, newCacheItemView(info))
+	}
+
+	nextCursor := ""
+	if len(items) == limit {
+		nextCursor = items[len(items)-1].Key
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items, "next_cursor": nextCursor})
+}
+
+// DeleteItem removes the item stored under the "key" or "url" query
+// parameter together with its variants and slices.
+func (s *adminService) DeleteItem(c *gin.Context) {
+	key, ok := s.itemKey(c)
+	if !ok {
+		return
+	}
+
+	keys := []string{}
+	if _, found := s.cacheItemRepository.Inspect(key); found {
+		keys = append(keys, key)
+	}
+	for after := ""; ; {
+		related := s.cacheItemRepository.List(key+"#", after, maxAdminPageSize)
+		for _, info := range related {
+			keys = append(keys, info.Item.Key)
+			s.cacheItemRepository.Delete(info.Item.Key)
+		}
+		if len(related) < maxAdminPageSize {
+			break
+		}
+		after = related[len(related)-1].Item.Key
+	}
+	if len(keys) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not cached", "key": key})
+		return
+	}
+
+	s.cacheItemRepository.Delete(key)
+	c.JSON(http.StatusOK, gin.H{"deleted": keys})
+}
+
+// Stats reports the disk usage and item counts of every CDN, and of domains
+// no longer configured that still have items cached.
+func (s *adminService) Stats(c *gin.Context) {
+	usage := s.cacheItemRepository.Usage()
+	for _, cdn := range s.cdnRepository.GetAll() {
+		if _, ok := usage[cdn.Domain]; !ok {
+			usage[cdn.Domain] = repository.CacheUsage{}
+		}
+	}
+
+	var total repository.CacheUsage
+	for _, u := range usage {
+		total.Items += u.Items
+		total.Expired += u.Expired
+		total.Bytes += u.Bytes
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":     total,
+		"max_bytes": s.config.CacheMaxSize,
+		"domains":   usage,
+	})
+}
+
+// itemKey returns the cache key named by the "key" query parameter, or the
+// primary key of requests for the "url" query parameter under its CDN's
+// cache key options.
+func (s *adminService) itemKey(c *gin.Context) (string, bool) {
+	if key := c.Query("key"); key != "" {
+		return key, true
+	}
+
+	u, err := url.Parse(c.Query("url"))
+	if err != nil || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key or absolute url required"})
+		return "", false
+	}
+
+	// Unknown domains are keyed with the default cache key options
+	cdn, _ := s.cdnRepository.GetByDomain(u.Host)
+	return requestCacheKey(cdn, u.Host, u), true
+}
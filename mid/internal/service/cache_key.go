@@ -0,0 +1,40 @@
+package service
+
+import (
+	"net/url"
+	"slices"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+)
+
+// requestCacheKey returns the primary cache key of a request: its host and
+// path, followed by the query parameters the CDN's query key mode selects.
+func requestCacheKey(cdn domain.CDN, host string, u *url.URL) string {
+	if query := cacheKeyQuery(cdn, u.RawQuery); query != "" {
+		return host + u.Path + "?" + query
+	}
+	return host + u.Path
+}
+
+func cacheKeyQuery(cdn domain.CDN, rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	switch cdn.QueryKeyMode {
+	case domain.QueryKeyIgnore:
+		return ""
+	case domain.QueryKeySorted, domain.QueryKeyAllowlist, domain.QueryKeyDenylist:
+		// Malformed pairs are dropped, the valid ones still count
+		values, _ := url.ParseQuery(rawQuery)
+		for name := range values {
+			listed := slices.Contains(cdn.QueryKeyParams, name)
+			if cdn.QueryKeyMode == domain.QueryKeyAllowlist && !listed || cdn.QueryKeyMode == domain.QueryKeyDenylist && listed {
+				delete(values, name)
+			}
+		}
+		return values.Encode()
+	default:
+		return rawQuery
+	}
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+)
+
+// pathPatterns caches the compiled path patterns of cache rules by source,
+// nil for invalid ones.
+var pathPatterns sync.Map
+
+// bypassesCache reports whether req skips the cache altogether: the first
+// rule matching it has no content type conditions, which need the response to
+// be evaluated, and bypasses the cache.
+func bypassesCache(cdn domain.CDN, req *http.Request) bool {
+	for _, rules := range [][]domain.CacheRule{cdn.CacheRules, domain.DefaultCacheRules} {
+		for _, rule := range rules {
+			if matchesRequest(rule, req) {
+				return len(rule.ContentTypes) == 0 && rule.Action == domain.CacheRuleBypass
+			}
+		}
+	}
+	return false
+}
+
+// matchCacheRule returns the first rule of the CDN, or else of
+// DefaultCacheRules, matching req and a response with header. The zero rule
+// is returned when none does.
+func matchCacheRule(cdn domain.CDN, req *http.Request, header http.Header) domain.CacheRule {
+	for _, rules := range [][]domain.CacheRule{cdn.CacheRules, domain.DefaultCacheRules} {
+		for _, rule := range rules {
+			if matchesRequest(rule, req) && matchesContentType(rule, header.Get("Content-Type")) {
+				return rule
+			}
+		}
+	}
+	return domain.CacheRule{}
+}
+
+func matchesRequest(rule domain.CacheRule, req *http.Request) bool {
+	if rule.PathGlob != "" && !matchesPattern("glob:"+rule.PathGlob, req.URL.Path) {
+		return false
+	}
+	if rule.PathRegex != "" && !matchesPattern("regex:"+rule.PathRegex, req.URL.Path) {
+		return false
+	}
+	if len(rule.Extensions) > 0 && !containsFold(rule.Extensions, strings.TrimPrefix(path.Ext(req.URL.Path), "."), ".") {
+		return false
+	}
+	if len(rule.Methods) > 0 && !containsFold(rule.Methods, req.Method, "") {
+		return false
+	}
+
+	for name, want := range rule.Headers {
+		values := req.Header.Values(name)
+		if len(values) == 0 || want != "" && !containsFold(values, want, "") {
+			return false
+		}
+	}
+	for name, want := range rule.Cookies {
+		cookie, err := req.Cookie(name)
+		if err != nil || want != "" && cookie.Value != want {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesContentType(rule domain.CacheRule, contentType string) bool {
+	if len(rule.ContentTypes) == 0 {
+		return true
+	}
+
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range rule.ContentTypes {
+		if contentType != "" && strings.HasPrefix(contentType, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold reports whether list holds s, ignoring case and the given
+// prefix of the listed values.
+func containsFold(list []string, s string, prefix string) bool {
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimPrefix(v, prefix), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPattern reports whether p matches the "glob:" or "regex:" pattern
+// source. Invalid patterns match nothing.
+func matchesPattern(source string, p string) bool {
+	cached, ok := pathPatterns.Load(source)
+	if !ok {
+		cached, _ = pathPatterns.LoadOrStore(source, compilePattern(source))
+	}
+	re := cached.(*regexp.Regexp)
+	return re != nil && re.MatchString(p)
+}
+
+func compilePattern(source string) *regexp.Regexp {
+	expr, isRegex := strings.CutPrefix(source, "regex:")
+	if !isRegex {
+		expr = globExpr(strings.TrimPrefix(source, "glob:"))
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+// globExpr translates a path glob to an anchored regular expression. "**"
+// matches any characters, "*" and "?" any characters but "/" and one of them.
+func globExpr(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			b.WriteString("[^/]*")
+		case glob[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// cachePolicy returns cdn with the TTL and TTL policy applying to a response
+// with status that rule matched. Rules set those of 200 responses, the TTLs
+// of other statuses come from StatusTTLs.
+func cachePolicy(cdn domain.CDN, rule domain.CacheRule, status int) domain.CDN {
+	if status != http.StatusOK {
+		cdn.CacheTTL = cdn.StatusTTLs[strconv.Itoa(status)]
+		return cdn
+	}
+
+	if rule.TTL > 0 {
+		cdn.CacheTTL = rule.TTL
+	}
+	if rule.TTLPolicy != "" {
+		cdn.TTLPolicy = rule.TTLPolicy
+	}
+	return cdn
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/metrics"
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+// Content codings the cache decodes upstream responses from and compresses
+// responses to
+const (
+	encodingBrotli   = "br"
+	encodingGzip     = "gzip"
+	encodingIdentity = "identity"
+)
+
+// negotiateEncoding replaces the Accept-Encoding header of the request with
+// the coding it is served in: brotli or gzip, in this order, when accepted and
+// compression is enabled, identity otherwise. Compressed responses are then
+// cached as Vary: Accept-Encoding variants of their own, one per coding.
+// Range requests are answered from the identity representation.
+func (s *cacheService) negotiateEncoding(c *gin.Context) {
+	encoding := encodingIdentity
+	if s.config.CompressionEnabled && c.GetHeader("Range") == "" {
+		encoding = preferredEncoding(c.GetHeader("Accept-Encoding"))
+	}
+	c.Request.Header.Set("Accept-Encoding", encoding)
+}
+
+// preferredEncoding returns the first of brotli and gzip accepted by an
+// Accept-Encoding header, or identity.
+func preferredEncoding(acceptEncoding string) string {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "x-gzip" {
+			coding = encodingGzip
+		}
+
+		weight := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil {
+				weight = v
+			}
+		}
+		weights[coding] = weight
+	}
+
+	for _, coding := range []string{encodingBrotli, encodingGzip} {
+		weight, ok := weights[coding]
+		if !ok {
+			weight, ok = weights["*"]
+		}
+		if ok && weight > 0 {
+			return coding
+		}
+	}
+	return encodingIdentity
+}
+
+// transcode prepares a full upstream response for a request whose coding was
+// negotiated in reqHeader. Bodies in a coding the cache understands are
+// decoded, so every variant is produced from the identity representation and
+// no client is sent a coding it did not accept. Compressible responses vary on
+// Accept-Encoding and are compressed on the fly for clients accepting it.
+func (s *cacheService) transcode(cdn domain.CDN, reqHeader http.Header, resp *http.Response) {
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+
+	switch coding := strings.ToLower(resp.Header.Get("Content-Encoding")); coding {
+	case encodingBrotli, encodingGzip, "x-gzip":
+		body, err := newDecoder(resp.Body, coding)
+		if err != nil {
+			return
+		}
+		resp.Body = body
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		weakenETag(resp.Header)
+	}
+
+	if !s.isCompressible(resp) {
+		return
+	}
+	addVary(resp.Header, "Accept-Encoding")
+
+	encoding := reqHeader.Get("Accept-Encoding")
+	if encoding != encodingBrotli && encoding != encodingGzip {
+		return
+	}
+	resp.Body = newEncoder(resp.Body, resp.ContentLength, encoding)
+	resp.ContentLength = -1
+	resp.Header.Set("Content-Encoding", encoding)
+	resp.Header.Del("Content-Length")
+	weakenETag(resp.Header)
+	metrics.CompressedResponses.WithLabelValues(cdn.Domain, encoding).Inc()
+}
+
+// isCompressible reports whether the identity body of resp is worth
+// compressing.
+func (s *cacheService) isCompressible(resp *http.Response) bool {
+	if coding := resp.Header.Get("Content-Encoding"); coding != "" && coding != encodingIdentity {
+		return false
+	}
+	if strings.Contains(strings.ToLower(resp.Header.Get("Cache-Control")), "no-transform") {
+		return false
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength < s.config.CompressionMinSize {
+		return false
+	}
+	return isCompressibleContentType(resp.Header.Get("Content-Type"))
+}
+
+func isCompressibleContentType(contentType string) bool {
+	compressible := []string{
+		"text/",
+		"application/javascript",
+		"application/x-javascript",
+		"application/json",
+		"application/manifest+json",
+		"application/xml",
+		"application/rss+xml",
+		"application/atom+xml",
+		"application/wasm",
+		"image/svg+xml",
+		"image/x-icon",
+		"font/ttf",
+		"font/otf",
+	}
+
+	for _, prefix := range compressible {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// addVary adds name to the Vary header unless it is listed already.
+func addVary(header http.Header, name string) {
+	for _, existing := range varyNames(header) {
+		if existing == name || existing == "*" {
+			return
+		}
+	}
+	header.Add("Vary", name)
+}
+
+// weakenETag marks the entity tag as weak: the representation it was issued
+// for has been transformed, so it no longer matches byte for byte.
+func weakenETag(header http.Header) {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+}
+
+// codingBody closes both the transformed body and the upstream body it reads.
+type codingBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *codingBody) Close() error {
+	for _, c := range b.closers {
+		_ = c.Close()
+	}
+	return nil
+}
+
+// newDecoder returns the decoded body of a response in coding.
+func newDecoder(body io.ReadCloser, coding string) (io.ReadCloser, error) {
+	if coding == encodingBrotli {
+		return &codingBody{Reader: brotli.NewReader(body), closers: []io.Closer{body}}, nil
+	}
+
+	zr, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	return &codingBody{Reader: zr, closers: []io.Closer{zr, body}}, nil
+}
+
+// newEncoder returns body compressed in encoding. The upstream body is read
+// in the background; a body shorter than length fails the compressed one.
+func newEncoder(body io.ReadCloser, length int64, encoding string) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		var enc io.WriteCloser
+		if encoding == encodingBrotli {
+			enc = brotli.NewWriterLevel(pw, brotli.DefaultCompression)
+		} else {
+			enc, _ = gzip.NewWriterLevel(pw, gzip.DefaultCompression)
+		}
+
+		n, err := io.Copy(enc, body)
+		if err == nil && length >= 0 && n != length {
+			err = io.ErrUnexpectedEOF
+		}
+		if closeErr := enc.Close(); err == nil {
+			err = closeErr
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	return &codingBody{Reader: pr, closers: []io.Closer{pr, body}}
+}
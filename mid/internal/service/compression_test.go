@@ -0,0 +1,169 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+func TestPreferredEncoding(t *testing.T) {
+	for acceptEncoding, want := range map[string]string{
+		"":                      encodingIdentity,
+		"gzip":                  encodingGzip,
+		"gzip, deflate, br":     encodingBrotli,
+		"GZIP;q=0.5":            encodingGzip,
+		"x-gzip":                encodingGzip,
+		"br;q=0, gzip":          encodingGzip,
+		"br;q=0, gzip;q=0":      encodingIdentity,
+		"*":                     encodingBrotli,
+		"*;q=0":                 encodingIdentity,
+		"br;q=0, *;q=0.1":       encodingGzip,
+		"gzip, identity;q=0":    encodingGzip,
+		"identity;q=0":          encodingIdentity,
+		"deflate":               encodingIdentity,
+		"br;q=high":             encodingBrotli,
+		"br ; q=0 , gzip ; q=1": encodingGzip,
+	} {
+		if got := preferredEncoding(acceptEncoding); got != want {
+			t.Errorf("preferredEncoding(%q) = %q, want %q", acceptEncoding, got, want)
+		}
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+		header  map[string]string
+		want    string
+	}{
+		{"compression on", true, map[string]string{"Accept-Encoding": "gzip, br"}, encodingBrotli},
+		{"compression off", false, map[string]string{"Accept-Encoding": "gzip, br"}, encodingIdentity},
+		{"range request", true, map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-9"}, encodingIdentity},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &cacheService{config: &config.Config{CompressionEnabled: tc.enabled}}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "http://example.com/a.css", nil)
+			for k, v := range tc.header {
+				c.Request.Header.Set(k, v)
+			}
+
+			s.negotiateEncoding(c)
+			if got := c.Request.Header.Get("Accept-Encoding"); got != tc.want {
+				t.Errorf("Accept-Encoding %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTranscode(t *testing.T) {
+	body := strings.Repeat("body { color: red } ", 10)
+	gzipped := func(s string) string {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		_, _ = io.WriteString(zw, s)
+		_ = zw.Close()
+		return b.String()
+	}
+
+	css := http.Header{"Content-Type": {"text/css"}}
+	with := func(header http.Header, k, v string) http.Header {
+		header = header.Clone()
+		header.Set(k, v)
+		return header
+	}
+
+	for _, tc := range []struct {
+		name     string
+		encoding string // negotiated for the request
+		status   int
+		header   http.Header
+		body     string
+		coding   string // of the transcoded response
+		want     string // decoded body
+		vary     bool
+		weak     bool
+	}{
+		{"gzip", encodingGzip, http.StatusOK, css, body, encodingGzip, body, true, true},
+		{"brotli", encodingBrotli, http.StatusOK, css, body, encodingBrotli, body, true, true},
+		{"identity", encodingIdentity, http.StatusOK, css, body, "", body, true, false},
+		{"decoded for identity", encodingIdentity, http.StatusOK, with(css, "Content-Encoding", "gzip"), gzipped(body), "", body, true, true},
+		{"recoded", encodingBrotli, http.StatusOK, with(css, "Content-Encoding", "gzip"), gzipped(body), encodingBrotli, body, true, true},
+		{"not compressible", encodingGzip, http.StatusOK, http.Header{"Content-Type": {"image/png"}}, body, "", body, false, false},
+		{"no-transform", encodingGzip, http.StatusOK, with(css, "Cache-Control", "no-transform"), body, "", body, false, false},
+		{"too small", encodingGzip, http.StatusOK, with(css, "Content-Length", "10"), body[:10], "", body[:10], false, false},
+		{"unknown coding", encodingGzip, http.StatusOK, with(css, "Content-Encoding", "zstd"), body, "zstd", body, false, false},
+		{"not a full response", encodingGzip, http.StatusPartialContent, css, body, "", body, false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &cacheService{config: &config.Config{CompressionEnabled: true, CompressionMinSize: 100}}
+			header := with(tc.header, "ETag", `"v1"`)
+			resp := &http.Response{
+				StatusCode:    tc.status,
+				Header:        header,
+				Body:          io.NopCloser(strings.NewReader(tc.body)),
+				ContentLength: contentLength(header),
+			}
+
+			s.transcode(domain.CDN{Domain: "example.com"}, http.Header{"Accept-Encoding": {tc.encoding}}, resp)
+			defer resp.Body.Close()
+
+			if got := resp.Header.Get("Content-Encoding"); got != tc.coding {
+				t.Errorf("Content-Encoding %q, want %q", got, tc.coding)
+			}
+			if got := resp.Header.Get("Vary") == "Accept-Encoding"; got != tc.vary {
+				t.Errorf("Vary %q, want Accept-Encoding %v", resp.Header.Get("Vary"), tc.vary)
+			}
+			if got := strings.HasPrefix(resp.Header.Get("ETag"), "W/"); got != tc.weak {
+				t.Errorf("ETag %q, want weak %v", resp.Header.Get("ETag"), tc.weak)
+			}
+
+			var r io.Reader = resp.Body
+			switch tc.coding {
+			case encodingGzip:
+				zr, err := gzip.NewReader(resp.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = zr
+			case encodingBrotli:
+				r = brotli.NewReader(resp.Body)
+			}
+			if data, err := io.ReadAll(r); err != nil || string(data) != tc.want {
+				t.Errorf("decoded %q, %v, want %q", data, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewEncoder(t *testing.T) {
+	body := strings.Repeat("0123456789", 100)
+
+	enc := newEncoder(io.NopCloser(strings.NewReader(body)), int64(len(body)), encodingGzip)
+	zr, err := gzip.NewReader(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(zr); err != nil || string(data) != body {
+		t.Errorf("read %d bytes, %v, want the body", len(data), err)
+	}
+	_ = enc.Close()
+
+	// Upstream bodies cut short fail the compressed body
+	enc = newEncoder(io.NopCloser(strings.NewReader(body)), int64(len(body))+1, encodingBrotli)
+	defer enc.Close()
+	if _, err := io.ReadAll(brotli.NewReader(enc)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("read of a short body: %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
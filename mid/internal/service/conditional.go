@@ -0,0 +1,64 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+)
+
+// setValidators makes req conditional on the validators of a cached response.
+func setValidators(req *http.Request, header http.Header) {
+	if etag := header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := header.Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// notModified reports whether the client's conditional GET is satisfied by a
+// representation with the validators in header, so a 304 can be sent.
+func notModified(req *http.Request, header http.Header) bool {
+	// If-None-Match takes precedence over If-Modified-Since
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		if strings.TrimSpace(ifNoneMatch) == "*" {
+			return true
+		}
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			// If-None-Match uses the weak comparison function
+			if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
+
+// mergeNotModified returns the stored headers updated with those sent in a
+// 304 response, keeping the ones that describe the stored body.
+func mergeNotModified(stored, received http.Header) http.Header {
+	merged := stored.Clone()
+	for k, vals := range received {
+		switch k {
+		case "Content-Length", "Content-Range", "Transfer-Encoding", "Content-Encoding", "Vary":
+			continue
+		}
+		merged[k] = vals
+	}
+
+	// Entity tags of transformed bodies stay weak
+	if strings.HasPrefix(stored.Get("ETag"), "W/") {
+		weakenETag(merged)
+	}
+	return merged
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+)
+
+func TestSetValidators(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/a.png", nil)
+	setValidators(req, http.Header{"Etag": {`W/"v1"`}, "Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}})
+	if got := req.Header.Get("If-None-Match"); got != `W/"v1"` {
+		t.Errorf("If-None-Match %q, want the ETag", got)
+	}
+	if got := req.Header.Get("If-Modified-Since"); got != "Mon, 02 Jan 2006 15:04:05 GMT" {
+		t.Errorf("If-Modified-Since %q, want Last-Modified", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/a.png", nil)
+	setValidators(req, http.Header{})
+	if len(req.Header) != 0 {
+		t.Errorf("conditions %v set without validators", req.Header)
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	const (
+		earlier = "Sun, 01 Jan 2006 00:00:00 GMT"
+		date    = "Mon, 02 Jan 2006 15:04:05 GMT"
+		later   = "Tue, 03 Jan 2006 00:00:00 GMT"
+	)
+	header := http.Header{"Etag": {`"v1"`}, "Last-Modified": {date}}
+
+	for _, tc := range []struct {
+		name   string
+		req    map[string]string
+		header http.Header
+		want   bool
+	}{
+		{"matching etag", map[string]string{"If-None-Match": `"v1"`}, header, true},
+		{"one of several", map[string]string{"If-None-Match": `"v0", "v1"`}, header, true},
+		{"weak client etag", map[string]string{"If-None-Match": `W/"v1"`}, header, true},
+		{"weak stored etag", map[string]string{"If-None-Match": `"v1"`}, http.Header{"Etag": {`W/"v1"`}}, true},
+		{"other etag", map[string]string{"If-None-Match": `"v2"`}, header, false},
+		{"any etag", map[string]string{"If-None-Match": " * "}, header, true},
+		{"any etag without one", map[string]string{"If-None-Match": "*"}, http.Header{"Last-Modified": {date}}, false},
+		{"etag over modification date", map[string]string{"If-None-Match": `"v2"`, "If-Modified-Since": later}, header, false},
+		{"etag match over modification date", map[string]string{"If-None-Match": `"v1"`, "If-Modified-Since": earlier}, header, true},
+		{"not modified since", map[string]string{"If-Modified-Since": date}, header, true},
+		{"modified since", map[string]string{"If-Modified-Since": earlier}, header, false},
+		{"no last modified", map[string]string{"If-Modified-Since": later}, http.Header{"Etag": {`"v1"`}}, false},
+		{"invalid date", map[string]string{"If-Modified-Since": "yesterday"}, header, false},
+		{"unconditional", nil, header, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/a.png", nil)
+			for k, v := range tc.req {
+				req.Header.Set(k, v)
+			}
+			if got := notModified(req, tc.header); got != tc.want {
+				t.Errorf("notModified = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRevalidation(t *testing.T) {
+	var requests, revalidations atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("If-None-Match") == `"v1"` && r.Header.Get("If-Modified-Since") != "" {
+			revalidations.Add(1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("X-Version", "refreshed")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "max-age=1")
+		w.Header().Set("Etag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Header().Set("X-Version", "original")
+		_, _ = io.WriteString(w, "body")
+	}))
+	t.Cleanup(upstream.Close)
+
+	cdn := domain.CDN{Domain: "example.com", Origin: upstream.URL, CacheTTL: 60, IsActive: true}
+	router := newTestCacheRouter(t, cdn, upstream)
+	get := func(header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/a.png", nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get(nil); rec.Code != http.StatusOK || rec.Body.String() != "body" {
+		t.Fatalf("answered %d %q, want 200 with the body", rec.Code, rec.Body.String())
+	}
+
+	// An expired item is revalidated and refreshed from the 304's headers
+	time.Sleep(1100 * time.Millisecond)
+	rec := get(nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "body" || rec.Header().Get("X-Version") != "refreshed" {
+		t.Errorf("revalidated answer %d %q, X-Version %q, want the stored body with the refreshed headers", rec.Code, rec.Body.String(), rec.Header().Get("X-Version"))
+	}
+	if revalidations.Load() != 1 {
+		t.Fatalf("upstream got %d conditional requests, want 1", revalidations.Load())
+	}
+
+	// The refreshed item is fresh again, and answers clients' conditions
+	rec = get(map[string]string{"If-None-Match": `W/"v1"`})
+	if rec.Code != http.StatusNotModified || rec.Header().Get("X-Version") != "refreshed" {
+		t.Errorf("conditional request answered %d, X-Version %q, want 304 from the refreshed item", rec.Code, rec.Header().Get("X-Version"))
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("upstream got %d requests, want 2", n)
+	}
+}
@@ -3,26 +3,41 @@ package service
 import (
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/config"
 	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
 	"github.com/AmirAghaee/go-cdn-stack/mid/internal/repository"
 	"github.com/gin-gonic/gin"
 )
 
+// edgeTTL is how long an edge is kept without heartbeats, three of them.
+const edgeTTL = 30 * time.Second
+
 type EdgeServiceInterface interface {
 	Register(c *gin.Context)
 	GetCdns(c *gin.Context)
+	ReportPurge(c *gin.Context)
+	ReportPrefetch(c *gin.Context)
 }
 
 type edgeService struct {
-	edgeRepository repository.EdgeRepositoryInterface
-	cdnRepository  repository.CdnRepositoryInterface
+	config          *config.Config
+	edgeRepository  repository.EdgeRepositoryInterface
+	cdnRepository   repository.CdnRepositoryInterface
+	midRepository   repository.MidRepositoryInterface
+	purgeService    PurgeServiceInterface
+	prefetchService PrefetchServiceInterface
 }
 
-func NewEdgeService(edgeRepo repository.EdgeRepositoryInterface, cdnRepo repository.CdnRepositoryInterface) EdgeServiceInterface {
+func NewEdgeService(config *config.Config, edgeRepo repository.EdgeRepositoryInterface, cdnRepo repository.CdnRepositoryInterface, midRepo repository.MidRepositoryInterface, purgeService PurgeServiceInterface, prefetchService PrefetchServiceInterface) EdgeServiceInterface {
 	return &edgeService{
-		edgeRepository: edgeRepo,
-		cdnRepository:  cdnRepo,
+		config:          config,
+		edgeRepository:  edgeRepo,
+		cdnRepository:   cdnRepo,
+		midRepository:   midRepo,
+		purgeService:    purgeService,
+		prefetchService: prefetchService,
 	}
 }
 
@@ -32,12 +47,25 @@ func (s *edgeService) Register(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	s.edgeRepository.Set(edge)
+
+	// Edges that went away stop holding up jobs, and those failing over from
+	// another mid get the jobs it handed out lately
+	for _, gone := range s.edgeRepository.Expire(edgeTTL) {
+		s.purgeService.Forget(gone)
+		s.prefetchService.Forget(gone)
+	}
+	if s.edgeRepository.Set(edge) {
+		s.purgeService.Adopt(edge)
+		s.prefetchService.Adopt(edge)
+	}
 	fmt.Println(s.edgeRepository.GetAll())
 	c.JSON(200, gin.H{
 		"status":           "registered",
 		"instance":         edge.Instance,
 		"cdn_list_version": s.cdnRepository.GetVersion(),
+		"purges":           s.purgeService.Pending(edge.Service),
+		"prefetches":       s.prefetchService.Pending(edge.Service),
+		"mids":             s.midRepository.GetAll(),
 	})
 }
 
@@ -45,3 +73,23 @@ func (s *edgeService) GetCdns(c *gin.Context) {
 	cdns := s.cdnRepository.GetAll()
 	c.JSON(http.StatusOK, cdns)
 }
+
+func (s *edgeService) ReportPurge(c *gin.Context) {
+	var result domain.PurgeResult
+	if err := c.ShouldBindJSON(&result); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	s.purgeService.Report(result)
+	c.JSON(http.StatusOK, gin.H{"status": "reported"})
+}
+
+func (s *edgeService) ReportPrefetch(c *gin.Context) {
+	var result domain.PrefetchResult
+	if err := c.ShouldBindJSON(&result); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	s.prefetchService.Report(result)
+	c.JSON(http.StatusOK, gin.H{"status": "reported"})
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/repository"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/storage"
+)
+
+func TestFillGroup(t *testing.T) {
+	g := newFillGroup()
+	f, leader := g.acquire("example.com/a.png")
+	if !leader {
+		t.Fatal("first acquire is not the leader")
+	}
+	if follower, leader := g.acquire("example.com/a.png"); leader || follower != f {
+		t.Fatal("second acquire does not follow the fill in flight")
+	}
+	if _, leader := g.acquire("example.com/b.png"); !leader {
+		t.Error("acquire of another key follows the fill in flight")
+	}
+
+	g.release("example.com/a.png", f)
+	if next, leader := g.acquire("example.com/a.png"); !leader || next == f {
+		t.Error("acquire after release follows the released fill")
+	}
+}
+
+func TestFillFollowers(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+	}{
+		{"leader done", nil},
+		{"leader failed", errors.New("upstream went away")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, entry := startTestFill(t)
+			partial, err := entry.OpenPartial()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer partial.Close()
+
+			// Followers read what is written as it is written
+			w := f.track(entry)
+			r := f.reader(partial)
+			if _, err := io.WriteString(w, "head"); err != nil {
+				t.Fatal(err)
+			}
+			p := make([]byte, 10)
+			if n, err := r.Read(p); err != nil || string(p[:n]) != "head" {
+				t.Fatalf("read %q, %v, want %q", p[:n], err, "head")
+			}
+
+			done := make(chan struct{})
+			var rest []byte
+			var readErr error
+			go func() {
+				defer close(done)
+				rest, readErr = io.ReadAll(r)
+			}()
+			if _, err := io.WriteString(w, "tail"); err != nil {
+				t.Fatal(err)
+			}
+			f.finish(tc.err)
+			<-done
+
+			if string(rest) != "tail" || !errors.Is(readErr, tc.err) {
+				t.Errorf("read %q, %v, want %q, %v", rest, readErr, "tail", tc.err)
+			}
+			if err := f.result(); !errors.Is(err, tc.err) {
+				t.Errorf("result %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestFillWait(t *testing.T) {
+	// Followers give up on a leader that does not start in time
+	f, _ := newFillGroup().acquire("example.com/a.png")
+	if f.wait(10 * time.Millisecond) {
+		t.Error("wait returned before the leader started")
+	}
+
+	// A leader finishing without caching anything lets followers fetch their own
+	f.finish(nil)
+	if !f.wait(time.Second) || f.cacheable {
+		t.Errorf("wait after an uncached fill: cacheable %v, want false", f.cacheable)
+	}
+}
+
+func TestCollapsedMissLeaderFailure(t *testing.T) {
+	body := strings.Repeat("x", 1<<17)
+	var calls atomic.Int32
+	started := make(chan struct{})
+	fail := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		if calls.Add(1) == 1 {
+			// The first response is cut short, past what responses buffer
+			_, _ = io.WriteString(w, body[:1<<16])
+			w.(http.Flusher).Flush()
+			close(started)
+			<-fail
+			panic(http.ErrAbortHandler)
+		}
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(upstream.Close)
+
+	cdn := domain.CDN{Domain: "example.com", Origin: upstream.URL, CacheTTL: 60, IsActive: true}
+	edge := httptest.NewServer(newTestCacheRouter(t, cdn, upstream))
+	t.Cleanup(edge.Close)
+	t.Cleanup(func() {
+		select {
+		case <-fail:
+		default:
+			close(fail)
+		}
+	})
+
+	get := func() (*http.Response, error) {
+		req, _ := http.NewRequest(http.MethodGet, edge.URL+"/a.png", nil)
+		req.Host = "example.com"
+		return (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	}
+
+	leader := make(chan error, 1)
+	go func() {
+		resp, err := get()
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		leader <- err
+	}()
+	<-started
+
+	// The follower tails the leader's fill and is cut short with it
+	follower, err := get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Body.Close()
+	close(fail)
+	if _, err := io.ReadAll(follower.Body); err == nil {
+		t.Error("follower read a whole body from a failed fill")
+	}
+	if err := <-leader; err == nil {
+		t.Error("leader read a whole body from a failed fill")
+	}
+
+	// Nothing was cached: the next request fills again
+	resp, err := get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if data, err := io.ReadAll(resp.Body); err != nil || string(data) != body {
+		t.Errorf("read %d bytes, %v after the failed fill, want the body", len(data), err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("upstream got %d requests, want 2", n)
+	}
+}
+
+func TestCollapsedMissTimeout(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// The first response is held back past the lock timeout
+			close(started)
+			<-release
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = io.WriteString(w, "body")
+	}))
+	t.Cleanup(upstream.Close)
+
+	cdn := domain.CDN{Domain: "example.com", Origin: upstream.URL, CacheTTL: 60, IsActive: true}
+	router := newTestCacheRouter(t, cdn, upstream)
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/a.png", nil))
+		return rec
+	}
+
+	leader := make(chan *httptest.ResponseRecorder, 1)
+	go func() { leader <- get() }()
+	<-started
+
+	// The follower stops waiting for the leader and fetches the object itself
+	if rec := get(); rec.Code != http.StatusOK || rec.Body.String() != "body" {
+		t.Errorf("follower got %d %q, want 200 %q", rec.Code, rec.Body.String(), "body")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("upstream got %d requests, want 2", n)
+	}
+
+	close(release)
+	if rec := <-leader; rec.Code != http.StatusOK || rec.Body.String() != "body" {
+		t.Errorf("leader got %d %q, want 200 %q", rec.Code, rec.Body.String(), "body")
+	}
+}
+
+// startTestFill returns a fill whose leader writes to entry.
+func startTestFill(t *testing.T) (*fill, *repository.EntryWriter) {
+	t.Helper()
+	cacheItemRepo := repository.NewCacheItemRepository(&config.Config{}, storage.NewMemory())
+	entry, err := cacheItemRepo.CreateEntry(&domain.CacheItem{Key: "example.com/a.png", FilePath: "a.cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(entry.Abort)
+
+	f, _ := newFillGroup().acquire("example.com/a.png")
+	f.start("example.com/a.png", http.StatusOK, http.Header{}, entry)
+	return f, entry
+}
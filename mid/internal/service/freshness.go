@@ -0,0 +1,182 @@
+package service
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+)
+
+// cacheControl holds the directives of a Cache-Control header by lowercase
+// name, with quoted values unquoted.
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, line := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// seconds returns the delta-seconds value of directive when it is present
+// and valid.
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	value, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// freshness returns how long a response may be served from cache under the
+// CDN's TTL policy and what that TTL was derived from. ok is false when the
+// response must not be stored at all.
+func freshness(cdn domain.CDN, header http.Header) (ttl time.Duration, source string, ok bool) {
+	cc := parseCacheControl(header)
+	if cc.has("no-store") || cc.has("private") {
+		return 0, "", false
+	}
+
+	defaultTTL := time.Duration(cdn.CacheTTL) * time.Second
+	switch cdn.TTLPolicy {
+	case domain.TTLPolicyOverride:
+		ttl, source = defaultTTL, "cdn"
+	case domain.TTLPolicyClamp:
+		ttl, source = originFreshness(cc, header, defaultTTL)
+		if minTTL := time.Duration(cdn.MinTTL) * time.Second; ttl < minTTL {
+			ttl, source = minTTL, "min-ttl"
+		}
+		if maxTTL := time.Duration(cdn.MaxTTL) * time.Second; cdn.MaxTTL > 0 && ttl > maxTTL {
+			ttl, source = maxTTL, "max-ttl"
+		}
+	default:
+		ttl, source = originFreshness(cc, header, defaultTTL)
+	}
+
+	// Without freshness or validators there is nothing to serve or revalidate
+	if ttl <= 0 && header.Get("ETag") == "" && header.Get("Last-Modified") == "" {
+		return 0, source, false
+	}
+	return ttl, source, true
+}
+
+// originFreshness returns the remaining freshness lifetime the origin assigned
+// to a response as seen by a shared cache (RFC 9111 section 4.2.1), falling
+// back to defaultTTL when the origin sets none.
+func originFreshness(cc cacheControl, header http.Header, defaultTTL time.Duration) (time.Duration, string) {
+	// An unqualified no-cache response is stored but revalidated before each use
+	if value, ok := cc["no-cache"]; ok && value == "" {
+		return 0, "no-cache"
+	}
+
+	var lifetime time.Duration
+	var source string
+	if sMaxAge, ok := cc.seconds("s-maxage"); ok {
+		lifetime, source = sMaxAge, "s-maxage"
+	} else if maxAge, ok := cc.seconds("max-age"); ok {
+		lifetime, source = maxAge, "max-age"
+	} else if expires := header.Get("Expires"); expires != "" {
+		// An invalid Expires date means the response is already expired
+		source = "expires"
+		if expiresAt, err := http.ParseTime(expires); err == nil {
+			date, err := http.ParseTime(header.Get("Date"))
+			if err != nil {
+				date = time.Now()
+			}
+			lifetime = expiresAt.Sub(date)
+		}
+	} else {
+		return defaultTTL, "default"
+	}
+
+	// Time already spent in upstream caches counts against the lifetime
+	if age, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && age > 0 {
+		lifetime -= time.Duration(age) * time.Second
+	}
+	return max(lifetime, 0), source
+}
+
+// cacheableResponse returns how long resp to req may be served from cache
+// and what that TTL was derived from. ok is false when resp must not be
+// stored: 200 responses are cached when the first cache rule matching them
+// caches them, and the statuses in domain.CacheableStatuses, whatever their
+// content type, when the CDN sets a TTL for them and no rule bypasses them.
+func cacheableResponse(cdn domain.CDN, req *http.Request, resp *http.Response) (ttl time.Duration, source string, ok bool) {
+	if !isCacheableVary(resp.Header) {
+		return 0, "", false
+	}
+
+	rule := matchCacheRule(cdn, req, resp.Header)
+	if resp.StatusCode != http.StatusOK {
+		if rule.Action == domain.CacheRuleBypass || cdn.StatusTTLs[strconv.Itoa(resp.StatusCode)] == 0 || !slices.Contains(domain.CacheableStatuses, resp.StatusCode) {
+			return 0, "", false
+		}
+	} else if rule.Action != domain.CacheRuleCache {
+		return 0, "", false
+	}
+
+	return freshness(cachePolicy(cdn, rule, resp.StatusCode), resp.Header)
+}
+
+// newCacheItem describes a response with status cached at filePath that
+// stays fresh for ttl, along with the stale windows granted to it. The
+// diagnostic headers are dropped from header.
+func newCacheItem(cdn domain.CDN, filePath string, status int, header http.Header, ttl time.Duration, ttlSource string) *domain.CacheItem {
+	expiresAt := time.Now().Add(ttl)
+	staleUntil, staleIfErrorUntil := staleWindows(cdn, header, expiresAt)
+	stripDiagnostics(header)
+
+	return &domain.CacheItem{
+		FilePath:          filePath,
+		Status:            status,
+		Header:            header,
+		ExpiresAt:         expiresAt,
+		TTLSource:         ttlSource,
+		Vary:              varyNames(header),
+		Tags:              cacheTags(header),
+		StaleUntil:        staleUntil,
+		StaleIfErrorUntil: staleIfErrorUntil,
+	}
+}
+
+// staleWindows returns until when a response expiring at expiresAt may be
+// served stale while it is refreshed, and when upstream fails. The origin's
+// stale-while-revalidate and stale-if-error directives (RFC 5861) take
+// precedence over the CDN's grace windows.
+func staleWindows(cdn domain.CDN, header http.Header, expiresAt time.Time) (staleUntil, staleIfErrorUntil time.Time) {
+	cc := parseCacheControl(header)
+	if cc.has("must-revalidate") || cc.has("proxy-revalidate") || cc.has("no-cache") {
+		return expiresAt, expiresAt
+	}
+
+	whileRevalidate := time.Duration(cdn.StaleWhileRevalidate) * time.Second
+	if d, ok := cc.seconds("stale-while-revalidate"); ok {
+		whileRevalidate = d
+	}
+	ifError := time.Duration(cdn.StaleIfError) * time.Second
+	if d, ok := cc.seconds("stale-if-error"); ok {
+		ifError = d
+	}
+
+	return expiresAt.Add(whileRevalidate), expiresAt.Add(ifError)
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+)
+
+func TestFreshness(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	date := now.Format(http.TimeFormat)
+	in := func(d time.Duration) string { return now.Add(d).Format(http.TimeFormat) }
+	respect := domain.CDN{CacheTTL: 300}
+	override := domain.CDN{CacheTTL: 300, TTLPolicy: domain.TTLPolicyOverride}
+	clamp := domain.CDN{CacheTTL: 300, TTLPolicy: domain.TTLPolicyClamp, MinTTL: 60, MaxTTL: 3600}
+
+	for _, tc := range []struct {
+		name   string
+		cdn    domain.CDN
+		header http.Header
+		ttl    time.Duration
+		source string
+		ok     bool
+	}{
+		{"max-age", respect, http.Header{"Cache-Control": {"public, max-age=120"}}, 2 * time.Minute, "max-age", true},
+		{"s-maxage over max-age", respect, http.Header{"Cache-Control": {"max-age=120, s-maxage=600"}}, 10 * time.Minute, "s-maxage", true},
+		{"quoted and split directives", respect, http.Header{"Cache-Control": {"public", `Max-Age="120"`}}, 2 * time.Minute, "max-age", true},
+		{"age counts against the lifetime", respect, http.Header{"Cache-Control": {"max-age=120"}, "Age": {"100"}}, 20 * time.Second, "max-age", true},
+		{"age past the lifetime", respect, http.Header{"Cache-Control": {"max-age=120"}, "Age": {"500"}, "Etag": {`"v1"`}}, 0, "max-age", true},
+		{"expires", respect, http.Header{"Date": {date}, "Expires": {in(time.Hour)}}, time.Hour, "expires", true},
+		{"expires in the past", respect, http.Header{"Date": {date}, "Expires": {in(-time.Hour)}, "Last-Modified": {date}}, 0, "expires", true},
+		{"invalid expires", respect, http.Header{"Expires": {"0"}, "Etag": {`"v1"`}}, 0, "expires", true},
+		{"max-age over expires", respect, http.Header{"Cache-Control": {"max-age=120"}, "Date": {date}, "Expires": {in(time.Hour)}}, 2 * time.Minute, "max-age", true},
+		{"invalid max-age", respect, http.Header{"Cache-Control": {"max-age=-1"}}, 5 * time.Minute, "default", true},
+		{"no freshness falls back to the CDN TTL", respect, http.Header{}, 5 * time.Minute, "default", true},
+		{"no-cache is revalidated", respect, http.Header{"Cache-Control": {"no-cache"}, "Etag": {`"v1"`}}, 0, "no-cache", true},
+		{"no-cache on fields", respect, http.Header{"Cache-Control": {`no-cache="Set-Cookie", max-age=120`}}, 2 * time.Minute, "max-age", true},
+		{"no-cache without validators", respect, http.Header{"Cache-Control": {"no-cache"}}, 0, "no-cache", false},
+		{"no-store", respect, http.Header{"Cache-Control": {"no-store"}}, 0, "", false},
+		{"private", respect, http.Header{"Cache-Control": {"private, max-age=120"}}, 0, "", false},
+		{"no-store under override", override, http.Header{"Cache-Control": {"no-store"}}, 0, "", false},
+		{"override", override, http.Header{"Cache-Control": {"max-age=120"}}, 5 * time.Minute, "cdn", true},
+		{"clamp within bounds", clamp, http.Header{"Cache-Control": {"max-age=120"}}, 2 * time.Minute, "max-age", true},
+		{"clamp to min", clamp, http.Header{"Cache-Control": {"max-age=10"}}, time.Minute, "min-ttl", true},
+		{"clamp to max", clamp, http.Header{"Cache-Control": {"max-age=86400"}}, time.Hour, "max-ttl", true},
+		{"clamp without max", domain.CDN{TTLPolicy: domain.TTLPolicyClamp, MinTTL: 60}, http.Header{"Cache-Control": {"max-age=86400"}}, 24 * time.Hour, "max-age", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ttl, source, ok := freshness(tc.cdn, tc.header)
+			if ttl != tc.ttl || source != tc.source || ok != tc.ok {
+				t.Errorf("freshness = %v, %q, %v, want %v, %q, %v", ttl, source, ok, tc.ttl, tc.source, tc.ok)
+			}
+		})
+	}
+}
@@ -59,8 +59,11 @@ func (h *healthService) publishHealth() {
 		return
 	}
 
-	if err := h.broker.Publish("health", string(payload)); err != nil {
-		log.Printf("failed to publish health: %v", err)
+	// Mids also learn of each other from their health
+	for _, subject := range []string{"health", "cdn.mid.health"} {
+		if err := h.broker.Publish(subject, string(payload)); err != nil {
+			log.Printf("failed to publish %s: %v", subject, err)
+		}
 	}
 
 }
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/repository"
+	"github.com/AmirAghaee/go-cdn-stack/pkg/messaging"
+)
+
+// jobRetention is how long edges that register with the mid, such as those
+// failing over from another mid, are still handed a job.
+const jobRetention = 10 * time.Minute
+
+// jobRelay hands the jobs of one kind, J, to the registered edges, which
+// receive them with their heartbeats, and publishes the results, R, of the
+// mid and its edges for the control panel.
+type jobRelay[J, R any] struct {
+	broker  messaging.MessageBrokerInterface
+	edges   repository.EdgeRepositoryInterface
+	queue   repository.JobQueueInterface[J]
+	subject string                          // results are published to
+	waiting func(job J, edge domain.Edge) R // result of an edge yet to run job
+
+	mu     sync.Mutex
+	recent []relayedJob[J]
+}
+
+type relayedJob[J any] struct {
+	job J
+	at  time.Time
+}
+
+func newJobRelay[J, R any](broker messaging.MessageBrokerInterface, edgeRepo repository.EdgeRepositoryInterface, queue repository.JobQueueInterface[J], subject string, waiting func(J, domain.Edge) R) *jobRelay[J, R] {
+	return &jobRelay[J, R]{
+		broker:  broker,
+		edges:   edgeRepo,
+		queue:   queue,
+		subject: subject,
+		waiting: waiting,
+	}
+}
+
+// announce reports every registered edge as waiting for job.
+func (r *jobRelay[J, R]) announce(job J) {
+	for _, edge := range r.edges.GetAll() {
+		r.publish(r.waiting(job, edge))
+	}
+}
+
+// enqueue hands job to the registered edges with their next heartbeat, and
+// to the edges registering within jobRetention.
+func (r *jobRelay[J, R]) enqueue(job J) {
+	r.mu.Lock()
+	r.recent = append(r.retained(), relayedJob[J]{job: job, at: time.Now()})
+	r.mu.Unlock()
+
+	for _, edge := range r.edges.GetAll() {
+		r.hand(edge, job)
+	}
+}
+
+// adopt hands the jobs enqueued within jobRetention to an edge that has just
+// registered.
+func (r *jobRelay[J, R]) adopt(edge domain.Edge) {
+	r.mu.Lock()
+	r.recent = r.retained()
+	recent := r.recent
+	r.mu.Unlock()
+
+	for _, relayed := range recent {
+		r.hand(edge, relayed.job)
+	}
+}
+
+// forget drops the jobs of an edge that went away. It gets them again if it
+// comes back.
+func (r *jobRelay[J, R]) forget(edge domain.Edge) {
+	r.queue.Remove(edge.Service)
+}
+
+func (r *jobRelay[J, R]) hand(edge domain.Edge, job J) {
+	r.publish(r.waiting(job, edge))
+	r.queue.Add(edge.Service, job)
+}
+
+// retained returns the recent jobs still within jobRetention. r.mu is held.
+func (r *jobRelay[J, R]) retained() []relayedJob[J] {
+	i := 0
+	for i < len(r.recent) && time.Since(r.recent[i].at) >= jobRetention {
+		i++
+	}
+	return r.recent[i:]
+}
+
+func (r *jobRelay[J, R]) pending(edge string) []J {
+	return r.queue.Pending(edge)
+}
+
+// report relays the result of edge, which stops receiving the job once it is
+// done with it.
+func (r *jobRelay[J, R]) report(edge, jobID string, done bool, result R) {
+	if done {
+		r.queue.Done(edge, jobID)
+	}
+	r.publish(result)
+}
+
+func (r *jobRelay[J, R]) publish(result R) {
+	payload, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("failed to marshal %s: %v", r.subject, err)
+		return
+	}
+
+	if err := r.broker.Publish(r.subject, string(payload)); err != nil {
+		log.Printf("failed to publish %s: %v", r.subject, err)
+	}
+}
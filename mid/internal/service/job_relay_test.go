@@ -0,0 +1,118 @@
+package service
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/repository"
+)
+
+// recordingBroker keeps the messages published to it.
+type recordingBroker struct {
+	mu       sync.Mutex
+	messages map[string][]string
+}
+
+func newRecordingBroker() *recordingBroker {
+	return &recordingBroker{messages: make(map[string][]string)}
+}
+
+func (b *recordingBroker) Publish(subject, msg string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.messages[subject] = append(b.messages[subject], msg)
+	return nil
+}
+
+func (b *recordingBroker) Subscribe(string, func(string)) error {
+	return nil
+}
+
+func (b *recordingBroker) published(t *testing.T, subject string) []domain.PurgeResult {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var results []domain.PurgeResult
+	for _, msg := range b.messages[subject] {
+		var result domain.PurgeResult
+		if err := json.Unmarshal([]byte(msg), &result); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func TestJobRelay(t *testing.T) {
+	broker := newRecordingBroker()
+	edges := repository.NewEdgeRepository()
+	edges.Set(domain.Edge{Service: "edge1", Instance: "10.0.0.1"})
+	edges.Set(domain.Edge{Service: "edge2", Instance: "10.0.0.2"})
+	relay := newJobRelay(broker, edges, repository.NewPurgeRepository(), "cdn.purge.result", waitingPurge)
+
+	job := domain.PurgeJob{ID: "job1"}
+	relay.enqueue(job)
+
+	results := broker.published(t, "cdn.purge.result")
+	if len(results) != 2 {
+		t.Fatalf("published %d results, want 2", len(results))
+	}
+	for _, result := range results {
+		if result.Status != domain.PurgeStatusPending {
+			t.Errorf("%s reported %s, want pending", result.Service, result.Status)
+		}
+	}
+
+	// Edges keep receiving the job until they are done with it
+	relay.report("edge1", job.ID, false, domain.PurgeResult{JobID: job.ID, Service: "edge1", Status: "running"})
+	if got := relay.pending("edge1"); len(got) != 1 {
+		t.Errorf("pending after a progress report %v, want the job", got)
+	}
+	relay.report("edge1", job.ID, true, domain.PurgeResult{JobID: job.ID, Service: "edge1", Status: domain.PurgeStatusDone})
+	if got := relay.pending("edge1"); len(got) != 0 {
+		t.Errorf("pending once done %v, want none", got)
+	}
+	if got := relay.pending("edge2"); len(got) != 1 {
+		t.Errorf("pending of another edge %v, want the job", got)
+	}
+	if results := broker.published(t, "cdn.purge.result"); len(results) != 4 {
+		t.Errorf("published %d results, want 4", len(results))
+	}
+}
+
+func TestJobRelayFailover(t *testing.T) {
+	broker := newRecordingBroker()
+	edges := repository.NewEdgeRepository()
+	edges.Set(domain.Edge{Service: "edge1", Instance: "10.0.0.1"})
+	relay := newJobRelay(broker, edges, repository.NewPurgeRepository(), "cdn.purge.result", waitingPurge)
+	relay.enqueue(domain.PurgeJob{ID: "job1"})
+
+	// An edge that went away stops holding the job up
+	relay.forget(domain.Edge{Service: "edge1"})
+	if got := relay.pending("edge1"); len(got) != 0 {
+		t.Errorf("pending of a departed edge %v, want none", got)
+	}
+
+	// An edge failing over to the mid gets the recent jobs
+	edge := domain.Edge{Service: "edge2", Instance: "10.0.0.2"}
+	relay.adopt(edge)
+	relay.adopt(edge)
+	if got := relay.pending("edge2"); len(got) != 1 || got[0].ID != "job1" {
+		t.Errorf("pending of an adopted edge %v, want job1", got)
+	}
+	results := broker.published(t, "cdn.purge.result")
+	if last := results[len(results)-1]; last.Service != "edge2" || last.Status != domain.PurgeStatusPending {
+		t.Errorf("adopted edge reported %s %s, want edge2 pending", last.Service, last.Status)
+	}
+
+	// Jobs are not handed out past their retention
+	relay.recent[0].at = time.Now().Add(-jobRetention)
+	relay.adopt(domain.Edge{Service: "edge3"})
+	if got := relay.pending("edge3"); len(got) != 0 {
+		t.Errorf("pending of an edge adopted after retention %v, want none", got)
+	}
+}
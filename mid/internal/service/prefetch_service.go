@@ -0,0 +1,132 @@
+package service
+
+import (
+	"cmp"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/repository"
+	"github.com/AmirAghaee/go-cdn-stack/pkg/messaging"
+)
+
+type PrefetchServiceInterface interface {
+	Process(job domain.PrefetchJob)
+	Pending(edge string) []domain.PrefetchJob
+	Adopt(edge domain.Edge)
+	Forget(edge domain.Edge)
+	Report(result domain.PrefetchResult)
+}
+
+type prefetchService struct {
+	config        *config.Config
+	cdnRepository repository.CdnRepositoryInterface
+	midRepository repository.MidRepositoryInterface
+	relay         *jobRelay[domain.PrefetchJob, domain.PrefetchResult]
+	prefetcher    *prefetcher
+	service       string
+	instance      string
+}
+
+func NewPrefetchService(
+	config *config.Config,
+	broker messaging.MessageBrokerInterface,
+	cdnRepo repository.CdnRepositoryInterface,
+	midRepo repository.MidRepositoryInterface,
+	edgeRepo repository.EdgeRepositoryInterface,
+	prefetchRepo repository.PrefetchRepositoryInterface,
+	service, instance string,
+) PrefetchServiceInterface {
+	return &prefetchService{
+		config:        config,
+		cdnRepository: cdnRepo,
+		midRepository: midRepo,
+		relay:         newJobRelay(broker, edgeRepo, prefetchRepo, "cdn.prefetch.result", waitingPrefetch),
+		prefetcher:    newPrefetcher(config.AppCacheURL, nil), // the variants edges ask for, they negotiate codings themselves
+		service:       service,
+		instance:      instance,
+	}
+}
+
+// Process warms the mid's own cache in the background and then queues the
+// job for every registered edge, so edges fill from the mid rather than all
+// of them hitting the origin. Edges are reported as pending until they run it.
+// Every mid only warms the paths edges fill through it.
+func (s *prefetchService) Process(job domain.PrefetchJob) {
+	s.relay.announce(job)
+
+	concurrency := min(job.Concurrency, s.config.PrefetchMaxConcurrency)
+	own := job
+	own.Paths = s.ownPaths(job)
+	go func() {
+		s.prefetcher.run(own, concurrency, func(result domain.PrefetchResult) {
+			result.Service = s.service
+			result.Instance = s.instance
+			result.Timestamp = time.Now().UTC()
+			s.relay.publish(result)
+		})
+
+		s.relay.enqueue(job)
+	}()
+}
+
+func waitingPrefetch(job domain.PrefetchJob, edge domain.Edge) domain.PrefetchResult {
+	return domain.PrefetchResult{
+		JobID:     job.ID,
+		Service:   edge.Service,
+		Instance:  edge.Instance,
+		Status:    domain.PrefetchStatusPending,
+		Total:     len(job.Paths),
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+func (s *prefetchService) Pending(edge string) []domain.PrefetchJob {
+	return s.relay.pending(edge)
+}
+
+// Adopt hands the recent jobs to a newly registered edge.
+func (s *prefetchService) Adopt(edge domain.Edge) {
+	s.relay.adopt(edge)
+}
+
+// Forget drops the jobs of an edge that went away.
+func (s *prefetchService) Forget(edge domain.Edge) {
+	s.relay.forget(edge)
+}
+
+// Report relays the progress of an edge, which stops receiving the job once
+// it is done.
+func (s *prefetchService) Report(result domain.PrefetchResult) {
+	s.relay.report(result.Service, result.JobID, result.Status == domain.PrefetchStatusDone, result)
+}
+
+// ownPaths returns the paths of job whose cache keys edges spread to this mid
+// with rendezvous hashing, as long as the mid is up. All paths are the mid's
+// when it is not among the mids it knows of.
+func (s *prefetchService) ownPaths(job domain.PrefetchJob) []string {
+	mids := s.midRepository.GetAll()
+	if !slices.Contains(mids, s.config.AppAdvertiseURL) {
+		return job.Paths
+	}
+
+	// Unknown domains are keyed with the default cache key options
+	cdn, _ := s.cdnRepository.GetByDomain(job.Domain)
+	var paths []string
+	for _, path := range job.Paths {
+		u, err := url.Parse(path)
+		if err != nil {
+			continue
+		}
+		key := requestCacheKey(cdn, job.Domain, u)
+		owner := slices.MaxFunc(mids, func(a, b string) int {
+			return cmp.Compare(rendezvousScore(a, key), rendezvousScore(b, key))
+		})
+		if owner == s.config.AppAdvertiseURL {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
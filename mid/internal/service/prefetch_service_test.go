@@ -0,0 +1,72 @@
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/repository"
+)
+
+func TestRendezvousScore(t *testing.T) {
+	// Edges compute the same score to pick the mid of a cache key
+	if got := rendezvousScore("mid1:9060", "example.com/a.png"); got != 17172535015428310603 {
+		t.Errorf("rendezvousScore = %d, want the score edges compute", got)
+	}
+}
+
+func TestPrefetchOwnPaths(t *testing.T) {
+	cdnRepo := repository.NewCdnRepository()
+	cdnRepo.Set([]domain.CDN{{Domain: "example.com", IsActive: true}})
+	mids := []string{"mid1:9060", "mid2:9060", "mid3:9060"}
+	midRepo := repository.NewMidRepository(mids, time.Minute)
+
+	job := domain.PrefetchJob{Domain: "example.com"}
+	for i := range 300 {
+		job.Paths = append(job.Paths, fmt.Sprintf("/img/%d.png", i))
+	}
+
+	// Every path is warmed by exactly one mid, the one edges fill it through
+	var all []string
+	for _, mid := range mids {
+		s := &prefetchService{
+			config:        &config.Config{AppAdvertiseURL: mid},
+			cdnRepository: cdnRepo,
+			midRepository: midRepo,
+		}
+		own := s.ownPaths(job)
+		if len(own) < 50 {
+			t.Errorf("%s warms %d of %d paths", mid, len(own), len(job.Paths))
+		}
+		for _, path := range own {
+			key := "example.com" + path
+			owner := slices.MaxFunc(mids, func(a, b string) int {
+				return cmp.Compare(rendezvousScore(a, key), rendezvousScore(b, key))
+			})
+			if owner != mid {
+				t.Errorf("%s warms %s, which edges fill through %s", mid, path, owner)
+			}
+		}
+		all = append(all, own...)
+	}
+	slices.Sort(all)
+	want := slices.Clone(job.Paths)
+	slices.Sort(want)
+	if !slices.Equal(all, want) {
+		t.Errorf("mids warm %d paths, want each of the %d once", len(all), len(want))
+	}
+
+	// A mid edges do not know of cannot tell which paths are its own
+	s := &prefetchService{
+		config:        &config.Config{AppAdvertiseURL: "mid4:9060"},
+		cdnRepository: cdnRepo,
+		midRepository: midRepo,
+	}
+	if own := s.ownPaths(job); len(own) != len(job.Paths) {
+		t.Errorf("unlisted mid warms %d paths, want all %d", len(own), len(job.Paths))
+	}
+}
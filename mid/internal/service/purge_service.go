@@ -0,0 +1,91 @@
+package service
+
+import (
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/metrics"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/repository"
+	"github.com/AmirAghaee/go-cdn-stack/pkg/messaging"
+)
+
+type PurgeServiceInterface interface {
+	Process(job domain.PurgeJob)
+	Pending(edge string) []domain.PurgeJob
+	Adopt(edge domain.Edge)
+	Forget(edge domain.Edge)
+	Report(result domain.PurgeResult)
+}
+
+type purgeService struct {
+	cdnRepository       repository.CdnRepositoryInterface
+	cacheItemRepository repository.CacheItemRepositoryInterface
+	relay               *jobRelay[domain.PurgeJob, domain.PurgeResult]
+	service             string
+	instance            string
+}
+
+func NewPurgeService(
+	broker messaging.MessageBrokerInterface,
+	cdnRepo repository.CdnRepositoryInterface,
+	cacheItemRepo repository.CacheItemRepositoryInterface,
+	edgeRepo repository.EdgeRepositoryInterface,
+	purgeRepo repository.PurgeRepositoryInterface,
+	service, instance string,
+) PurgeServiceInterface {
+	return &purgeService{
+		cdnRepository:       cdnRepo,
+		cacheItemRepository: cacheItemRepo,
+		relay:               newJobRelay(broker, edgeRepo, purgeRepo, "cdn.purge.result", waitingPurge),
+		service:             service,
+		instance:            instance,
+	}
+}
+
+// Process purges the mid's own cache and queues the job for every registered
+// edge, reporting each of them as pending until the edge runs it.
+func (s *purgeService) Process(job domain.PurgeJob) {
+	// Unknown domains are purged with the default cache key options
+	cdn, _ := s.cdnRepository.GetByDomain(job.Domain)
+
+	purged := purgeCache(s.cacheItemRepository, cdn, job)
+	metrics.PurgedItems.WithLabelValues(job.Domain).Add(float64(purged))
+	s.relay.publish(domain.PurgeResult{
+		JobID:     job.ID,
+		Service:   s.service,
+		Instance:  s.instance,
+		Status:    domain.PurgeStatusDone,
+		Purged:    purged,
+		Timestamp: time.Now().UTC(),
+	})
+	s.relay.enqueue(job)
+}
+
+func waitingPurge(job domain.PurgeJob, edge domain.Edge) domain.PurgeResult {
+	return domain.PurgeResult{
+		JobID:     job.ID,
+		Service:   edge.Service,
+		Instance:  edge.Instance,
+		Status:    domain.PurgeStatusPending,
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+func (s *purgeService) Pending(edge string) []domain.PurgeJob {
+	return s.relay.pending(edge)
+}
+
+// Adopt hands the recent jobs to a newly registered edge.
+func (s *purgeService) Adopt(edge domain.Edge) {
+	s.relay.adopt(edge)
+}
+
+// Forget drops the jobs of an edge that went away.
+func (s *purgeService) Forget(edge domain.Edge) {
+	s.relay.forget(edge)
+}
+
+// Report records that an edge ran a purge job and relays its result.
+func (s *purgeService) Report(result domain.PurgeResult) {
+	s.relay.report(result.Service, result.JobID, true, result)
+}
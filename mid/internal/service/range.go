@@ -0,0 +1,188 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errRangeNotSatisfiable = errors.New("range not satisfiable")
+	errRangeComplete       = errors.New("range complete")
+)
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// requestedRange returns the single byte range requested by the client for a
+// body of size bytes. It returns nil when the full body should be sent: no or
+// malformed Range header, multiple ranges, unknown size or a failed If-Range.
+func requestedRange(req *http.Request, header http.Header, size int64) (*byteRange, error) {
+	spec := req.Header.Get("Range")
+	if spec == "" || size < 0 || !strings.HasPrefix(spec, "bytes=") || !ifRangeMatches(req, header) {
+		return nil, nil
+	}
+
+	// Multiple ranges are only served from complete cached objects
+	specs := strings.Split(strings.TrimPrefix(spec, "bytes="), ",")
+	if len(specs) != 1 {
+		return nil, nil
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(specs[0]), "-")
+	if !ok {
+		return nil, nil
+	}
+
+	// Suffix range: the last n bytes
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return nil, nil
+		}
+		if n == 0 || size == 0 {
+			return nil, errRangeNotSatisfiable
+		}
+		n = min(n, size)
+		return &byteRange{start: size - n, length: n}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+	if start >= size {
+		return nil, errRangeNotSatisfiable
+	}
+
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, nil
+		}
+		end = min(end, size-1)
+	}
+
+	return &byteRange{start: start, length: end - start + 1}, nil
+}
+
+// rangeStartHint returns the first byte of a single "bytes=N-" range request,
+// or 0 when the start cannot be known before the object size.
+func rangeStartHint(req *http.Request) int64 {
+	spec, ok := strings.CutPrefix(req.Header.Get("Range"), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0
+	}
+
+	first, _, _ := strings.Cut(spec, "-")
+	start, err := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	if err != nil || start < 0 {
+		return 0
+	}
+	return start
+}
+
+// ifRangeMatches reports whether the If-Range precondition, if any, matches
+// the validators of the cached representation.
+func ifRangeMatches(req *http.Request, header http.Header) bool {
+	ifRange := req.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+
+	// Entity tags must match with the strong comparison function
+	if strings.HasPrefix(ifRange, `"`) {
+		etag := header.Get("ETag")
+		return etag != "" && etag == ifRange
+	}
+	if strings.HasPrefix(ifRange, "W/") {
+		return false
+	}
+
+	since, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && since.Equal(modified)
+}
+
+// writeStreamHead writes the response head for a cacheable body of size bytes
+// that is still being streamed from upstream, honoring client conditionals
+// and a single byte range. The body must be copied through the returned writer.
+func writeStreamHead(c *gin.Context, status int, header http.Header, size int64) io.Writer {
+	c.Header("Accept-Ranges", "bytes")
+	if status != http.StatusOK {
+		c.Status(status)
+		return c.Writer
+	}
+
+	if notModified(c.Request, header) {
+		c.Status(http.StatusNotModified)
+		return io.Discard
+	}
+
+	r, err := requestedRange(c.Request, header, size)
+	if errors.Is(err, errRangeNotSatisfiable) {
+		c.Writer.Header().Del("Content-Length")
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+		c.Status(http.StatusRequestedRangeNotSatisfiable)
+		return io.Discard
+	}
+	if r == nil {
+		c.Status(status)
+		return c.Writer
+	}
+
+	c.Header("Content-Range", r.contentRange(size))
+	c.Header("Content-Length", strconv.FormatInt(r.length, 10))
+	c.Status(http.StatusPartialContent)
+	return &rangeWriter{w: c.Writer, start: r.start, end: r.start + r.length}
+}
+
+// rangeWriter forwards only the bytes in [start, end) of the stream written
+// to it and fails once the range has been sent.
+type rangeWriter struct {
+	w          io.Writer
+	start, end int64
+	offset     int64
+}
+
+func (r *rangeWriter) Write(p []byte) (int, error) {
+	if r.offset >= r.end {
+		return 0, errRangeComplete
+	}
+
+	n := int64(len(p))
+	from := max(r.start-r.offset, 0)
+	to := min(r.end-r.offset, n)
+	r.offset += n
+
+	if from < to {
+		if _, err := r.w.Write(p[from:to]); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// contentLength returns the Content-Length announced in header, or -1.
+func contentLength(header http.Header) int64 {
+	n, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+)
+
+func TestRequestedRange(t *testing.T) {
+	const size = 1000
+	validators := http.Header{
+		"Etag":          {`"v1"`},
+		"Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"},
+	}
+
+	for _, tc := range []struct {
+		name    string
+		rng     string
+		ifRange string
+		size    int64
+		want    *byteRange
+		err     error
+	}{
+		{name: "no range", size: size},
+		{name: "first bytes", rng: "bytes=0-99", size: size, want: &byteRange{0, 100}},
+		{name: "open ended", rng: "bytes=900-", size: size, want: &byteRange{900, 100}},
+		{name: "end past the body", rng: "bytes=900-5000", size: size, want: &byteRange{900, 100}},
+		{name: "suffix", rng: "bytes=-10", size: size, want: &byteRange{990, 10}},
+		{name: "suffix past the body", rng: "bytes=-5000", size: size, want: &byteRange{0, size}},
+		{name: "spaces", rng: "bytes= 10-19", size: size, want: &byteRange{10, 10}},
+		{name: "start past the body", rng: "bytes=1000-", size: size, err: errRangeNotSatisfiable},
+		{name: "empty suffix", rng: "bytes=-0", size: size, err: errRangeNotSatisfiable},
+		{name: "empty body", rng: "bytes=-10", size: 0, err: errRangeNotSatisfiable},
+		{name: "multiple ranges", rng: "bytes=0-9,20-29", size: size},
+		{name: "other unit", rng: "items=0-9", size: size},
+		{name: "end before start", rng: "bytes=20-10", size: size},
+		{name: "malformed", rng: "bytes=a-b", size: size},
+		{name: "unknown size", rng: "bytes=0-9", size: -1},
+		{name: "matching etag", rng: "bytes=0-9", ifRange: `"v1"`, size: size, want: &byteRange{0, 10}},
+		{name: "changed etag", rng: "bytes=0-9", ifRange: `"v2"`, size: size},
+		{name: "weak etag", rng: "bytes=0-9", ifRange: `W/"v1"`, size: size},
+		{name: "matching date", rng: "bytes=0-9", ifRange: "Mon, 02 Jan 2006 15:04:05 GMT", size: size, want: &byteRange{0, 10}},
+		{name: "changed date", rng: "bytes=0-9", ifRange: "Tue, 03 Jan 2006 15:04:05 GMT", size: size},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/a.mp4", nil)
+			if tc.rng != "" {
+				req.Header.Set("Range", tc.rng)
+			}
+			if tc.ifRange != "" {
+				req.Header.Set("If-Range", tc.ifRange)
+			}
+
+			got, err := requestedRange(req, validators, tc.size)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("error %v, want %v", err, tc.err)
+			}
+			if (got == nil) != (tc.want == nil) || got != nil && *got != *tc.want {
+				t.Errorf("range %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRangeStartHint(t *testing.T) {
+	for rng, want := range map[string]int64{
+		"":              0,
+		"bytes=500-":    500,
+		"bytes=500-999": 500,
+		"bytes=-500":    0,
+		"bytes=0-9,20-": 0,
+		"bytes=x-":      0,
+		"items=500-":    0,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/a.mp4", nil)
+		req.Header.Set("Range", rng)
+		if got := rangeStartHint(req); got != want {
+			t.Errorf("rangeStartHint(%q) = %d, want %d", rng, got, want)
+		}
+	}
+}
+
+func TestRangeResponses(t *testing.T) {
+	body := strings.Repeat("0123456789", 100)
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(upstream.Close)
+
+	cdn := domain.CDN{Domain: "example.com", Origin: upstream.URL, CacheTTL: 60, IsActive: true}
+	router := newTestCacheRouter(t, cdn, upstream)
+	get := func(header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/a.mp4", nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A range request missing the cache is answered while the body is filled
+	rec := get(map[string]string{"Range": "bytes=10-19"})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != body[10:20] || rec.Header().Get("Content-Range") != "bytes 10-19/1000" {
+		t.Errorf("miss answered %d %q %q, want 206 %q", rec.Code, rec.Header().Get("Content-Range"), rec.Body.String(), body[10:20])
+	}
+
+	// Later ones are served from the cached object
+	for _, tc := range []struct {
+		name   string
+		header map[string]string
+		status int
+		rng    string
+		body   string
+	}{
+		{"single range", map[string]string{"Range": "bytes=990-"}, http.StatusPartialContent, "bytes 990-999/1000", body[990:]},
+		{"suffix range", map[string]string{"Range": "bytes=-5"}, http.StatusPartialContent, "bytes 995-999/1000", body[995:]},
+		{"unsatisfiable", map[string]string{"Range": "bytes=2000-"}, http.StatusRequestedRangeNotSatisfiable, "bytes */1000", ""},
+		{"matching If-Range", map[string]string{"Range": "bytes=0-4", "If-Range": `"v1"`}, http.StatusPartialContent, "bytes 0-4/1000", body[:5]},
+		{"changed If-Range", map[string]string{"Range": "bytes=0-4", "If-Range": `"v2"`}, http.StatusOK, "", body},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := get(tc.header)
+			if rec.Code != tc.status || rec.Header().Get("Content-Range") != tc.rng {
+				t.Fatalf("answered %d %q, want %d %q", rec.Code, rec.Header().Get("Content-Range"), tc.status, tc.rng)
+			}
+			if tc.body != "" && rec.Body.String() != tc.body {
+				t.Errorf("body %q, want %q", rec.Body.String(), tc.body)
+			}
+		})
+	}
+
+	t.Run("multiple ranges", func(t *testing.T) {
+		rec := get(map[string]string{"Range": "bytes=0-1,998-"})
+		mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+		if rec.Code != http.StatusPartialContent || err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("answered %d %q, want 206 multipart/byteranges", rec.Code, rec.Header().Get("Content-Type"))
+		}
+
+		var parts []string
+		mr := multipart.NewReader(rec.Body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(part)
+			parts = append(parts, part.Header.Get("Content-Range")+" "+string(data))
+		}
+		want := []string{"bytes 0-1/1000 01", "bytes 998-999/1000 89"}
+		if strings.Join(parts, "|") != strings.Join(want, "|") {
+			t.Errorf("parts %q, want %q", parts, want)
+		}
+	})
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("upstream got %d requests, want 1", n)
+	}
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"cmp"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/metrics"
+)
+
+// UpstreamPoolInterface picks the nodes cache fills are sent to.
+type UpstreamPoolInterface interface {
+	// Nodes returns the base URLs of the nodes the fill req may be sent to,
+	// in the order they are tried.
+	Nodes(cdn domain.CDN, req *http.Request) []string
+	// Acquire reserves a request to node, or reports false when the node has
+	// no capacity left. release is called once the response is done with.
+	Acquire(cdn domain.CDN, node string) (release func(), ok bool)
+	// Failed records a request to node that got no response.
+	Failed(cdn domain.CDN, node string)
+	// Answered records a response of node and reports whether it is used,
+	// the next node being tried otherwise.
+	Answered(cdn domain.CDN, node string, status int) bool
+}
+
+// originPool balances cache fills over the origin pool of each CDN. The
+// origins of the lowest priority share the fills according to the CDN's
+// balancing strategy; origins that fail or answer with a 5xx are skipped for
+// a cooldown, their fills going to the next origin meanwhile.
+type originPool struct {
+	config  *config.Config
+	mu      sync.Mutex
+	origins map[string]*originState // by CDN domain and origin URL
+}
+
+type originState struct {
+	inFlight  int
+	current   int // smooth weighted round-robin counter
+	downUntil time.Time
+}
+
+func NewUpstreamPool(config *config.Config) UpstreamPoolInterface {
+	return &originPool{
+		config:  config,
+		origins: make(map[string]*originState),
+	}
+}
+
+// Nodes returns the origins by priority, each priority ordered by the CDN's
+// balancing strategy. Origins that recently failed come last.
+func (p *originPool) Nodes(cdn domain.CDN, req *http.Request) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var up, down []domain.OriginServer
+	for _, origin := range cdn.OriginServers() {
+		if p.state(cdn, origin.URL).downUntil.After(now) {
+			down = append(down, origin)
+		} else {
+			up = append(up, origin)
+		}
+	}
+	byPriority := func(a, b domain.OriginServer) int {
+		return cmp.Compare(a.Priority, b.Priority)
+	}
+	slices.SortStableFunc(up, byPriority)
+	slices.SortStableFunc(down, byPriority)
+
+	nodes := make([]string, 0, len(up)+len(down))
+	for start := 0; start < len(up); {
+		end := start + 1
+		for end < len(up) && up[end].Priority == up[start].Priority {
+			end++
+		}
+		for _, origin := range p.balance(cdn, up[start:end], req) {
+			nodes = append(nodes, origin.URL)
+		}
+		start = end
+	}
+	for _, origin := range down {
+		nodes = append(nodes, origin.URL)
+	}
+	return nodes
+}
+
+// balance orders origins of the same priority by the CDN's strategy.
+func (p *originPool) balance(cdn domain.CDN, origins []domain.OriginServer, req *http.Request) []domain.OriginServer {
+	if cdn.OriginBalance == domain.OriginBalanceIPHash {
+		ip := clientIP(req)
+		scores := make(map[string]float64, len(origins))
+		for _, origin := range origins {
+			scores[origin.URL] = weightedScore(origin, ip)
+		}
+		slices.SortFunc(origins, func(a, b domain.OriginServer) int {
+			return cmp.Compare(scores[b.URL], scores[a.URL])
+		})
+		return origins
+	}
+
+	// Smooth weighted round-robin: every origin gains its weight, the one
+	// with the highest counter is picked and loses the total
+	total := 0
+	for _, origin := range origins {
+		state := p.state(cdn, origin.URL)
+		state.current += originWeight(origin)
+		total += originWeight(origin)
+	}
+	slices.SortStableFunc(origins, func(a, b domain.OriginServer) int {
+		return cmp.Compare(p.state(cdn, b.URL).current, p.state(cdn, a.URL).current)
+	})
+	p.state(cdn, origins[0].URL).current -= total
+
+	// Least connections keeps the round-robin order between equally loaded
+	// origins
+	if cdn.OriginBalance == domain.OriginBalanceLeastConnections {
+		slices.SortStableFunc(origins, func(a, b domain.OriginServer) int {
+			return cmp.Compare(p.state(cdn, a.URL).inFlight*originWeight(b), p.state(cdn, b.URL).inFlight*originWeight(a))
+		})
+	}
+	return origins
+}
+
+func (p *originPool) Acquire(cdn domain.CDN, node string) (func(), bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := p.state(cdn, node)
+	state.inFlight++
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		state.inFlight--
+	}, true
+}
+
+func (p *originPool) Failed(cdn domain.CDN, node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.state(cdn, node).downUntil = time.Now().Add(p.config.OriginFailureCooldownDuration)
+	metrics.OriginUp.WithLabelValues(cdn.Domain, node).Set(0)
+	metrics.OriginFailovers.WithLabelValues(cdn.Domain, node).Inc()
+}
+
+// Answered fails over on 5xx responses.
+func (p *originPool) Answered(cdn domain.CDN, node string, status int) bool {
+	if status >= http.StatusInternalServerError {
+		p.Failed(cdn, node)
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.state(cdn, node).downUntil = time.Time{}
+	metrics.OriginUp.WithLabelValues(cdn.Domain, node).Set(1)
+	return true
+}
+
+// state returns the state of an origin of cdn, p.mu being held.
+func (p *originPool) state(cdn domain.CDN, origin string) *originState {
+	key := cdn.Domain + " " + origin
+	state, ok := p.origins[key]
+	if !ok {
+		state = &originState{}
+		p.origins[key] = state
+	}
+	return state
+}
+
+func originWeight(origin domain.OriginServer) int {
+	return max(int(origin.Weight), 1)
+}
+
+// weightedScore returns the weighted rendezvous score of origin for a client
+// IP. Clients go to the origin with the highest score, each origin getting a
+// share of them proportional to its weight.
+func weightedScore(origin domain.OriginServer, ip string) float64 {
+	// Uniform in (0, 1)
+	u := (float64(rendezvousScore(origin.URL, ip)>>11) + 0.5) / (1 << 53)
+	return float64(originWeight(origin)) / -math.Log(u)
+}
+
+// rendezvousScore returns the weight of node for key. Keys go to the node
+// with the highest score. Edges spread cache keys over mids with the same
+// score.
+func rendezvousScore(node string, key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(node))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(key))
+
+	// FNV alone spreads keys differing in their last bytes poorly
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// clientIP returns the client address the fill is made for, as forwarded by
+// newUpstreamRequest.
+func clientIP(req *http.Request) string {
+	ip, _, _ := strings.Cut(req.Header.Get("X-Forwarded-For"), ",")
+	return strings.TrimSpace(ip)
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/config"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+)
+
+func TestOriginPoolRoundRobin(t *testing.T) {
+	pool := NewUpstreamPool(&config.Config{OriginFailureCooldownDuration: time.Minute})
+	cdn := domain.CDN{Domain: "example.com", Origins: []domain.OriginServer{
+		{URL: "http://a", Weight: 3},
+		{URL: "http://b", Weight: 1},
+		{URL: "http://backup", Priority: 1},
+	}}
+
+	// Weights are interleaved rather than sent in bursts, backups come last
+	var picks string
+	for range 8 {
+		nodes := pool.Nodes(cdn, newFillRequest(""))
+		if len(nodes) != 3 || nodes[2] != "http://backup" {
+			t.Fatalf("nodes %q, want the backup last", nodes)
+		}
+		picks += nodes[0][len("http://"):]
+	}
+	if picks != "aabaaaba" {
+		t.Errorf("picked %q, want %q", picks, "aabaaaba")
+	}
+
+	// A CDN without a pool has its origin alone
+	single := domain.CDN{Domain: "single.example.com", Origin: "http://origin"}
+	if nodes := pool.Nodes(single, newFillRequest("")); !slices.Equal(nodes, []string{"http://origin"}) {
+		t.Errorf("nodes %q, want the CDN origin", nodes)
+	}
+}
+
+func TestOriginPoolLeastConnections(t *testing.T) {
+	pool := NewUpstreamPool(&config.Config{OriginFailureCooldownDuration: time.Minute})
+	cdn := domain.CDN{Domain: "example.com", OriginBalance: domain.OriginBalanceLeastConnections, Origins: []domain.OriginServer{
+		{URL: "http://a", Weight: 2},
+		{URL: "http://b", Weight: 1},
+	}}
+
+	// Requests in flight count relative to weight
+	var releases []func()
+	for _, node := range []string{"http://a", "http://a", "http://b"} {
+		release, ok := pool.Acquire(cdn, node)
+		if !ok {
+			t.Fatalf("request to %s rejected", node)
+		}
+		releases = append(releases, release)
+	}
+	release, _ := pool.Acquire(cdn, "http://a")
+	if nodes := pool.Nodes(cdn, newFillRequest("")); nodes[0] != "http://b" {
+		t.Errorf("nodes %q with a busier than b, want b first", nodes)
+	}
+
+	release()
+	for _, release := range releases {
+		release()
+	}
+	release, _ = pool.Acquire(cdn, "http://b")
+	defer release()
+	if nodes := pool.Nodes(cdn, newFillRequest("")); nodes[0] != "http://a" {
+		t.Errorf("nodes %q with b busier than a, want a first", nodes)
+	}
+}
+
+func TestOriginPoolIPHash(t *testing.T) {
+	pool := NewUpstreamPool(&config.Config{OriginFailureCooldownDuration: time.Minute})
+	cdn := domain.CDN{Domain: "example.com", OriginBalance: domain.OriginBalanceIPHash, Origins: []domain.OriginServer{
+		{URL: "http://a", Weight: 3},
+		{URL: "http://b", Weight: 1},
+	}}
+
+	// Every client sticks to an origin, the origins sharing clients by weight
+	counts := make(map[string]int)
+	for i := range 4000 {
+		ip := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		nodes := pool.Nodes(cdn, newFillRequest(ip+", 192.0.2.1"))
+		if again := pool.Nodes(cdn, newFillRequest(ip)); !slices.Equal(again, nodes) {
+			t.Fatalf("client %s got %q, then %q", ip, nodes, again)
+		}
+		counts[nodes[0]]++
+	}
+	if n := counts["http://a"]; n < 2800 || n > 3200 {
+		t.Errorf("a got %d of 4000 clients, want about 3000", n)
+	}
+}
+
+func TestOriginPoolFailover(t *testing.T) {
+	pool := NewUpstreamPool(&config.Config{OriginFailureCooldownDuration: time.Minute})
+	cdn := domain.CDN{Domain: "example.com", Origins: []domain.OriginServer{
+		{URL: "http://a"},
+		{URL: "http://b", Priority: 1},
+		{URL: "http://c", Priority: 2},
+	}}
+	other := cdn
+	other.Domain = "other.example.com"
+
+	pool.Failed(cdn, "http://a")
+	if !pool.Answered(cdn, "http://b", http.StatusNotFound) {
+		t.Error("4xx response not used")
+	}
+	if pool.Answered(cdn, "http://b", http.StatusServiceUnavailable) {
+		t.Error("5xx response used")
+	}
+	if nodes := pool.Nodes(cdn, newFillRequest("")); !slices.Equal(nodes, []string{"http://c", "http://a", "http://b"}) {
+		t.Errorf("nodes %q, want the backup, then the failed origins", nodes)
+	}
+	if nodes := pool.Nodes(other, newFillRequest("")); nodes[0] != "http://a" {
+		t.Errorf("nodes of another CDN %q, want a first", nodes)
+	}
+
+	// An origin answering again is back in its place
+	pool.Answered(cdn, "http://a", http.StatusOK)
+	if nodes := pool.Nodes(cdn, newFillRequest("")); !slices.Equal(nodes, []string{"http://a", "http://c", "http://b"}) {
+		t.Errorf("nodes %q, want a first again", nodes)
+	}
+}
+
+func TestOriginFailover(t *testing.T) {
+	var failing, backup atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failing.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(primary.Close)
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backup.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = io.WriteString(w, "body")
+	}))
+	t.Cleanup(secondary.Close)
+
+	cdn := domain.CDN{Domain: "example.com", Origin: primary.URL, CacheTTL: 60, IsActive: true, Origins: []domain.OriginServer{
+		{URL: primary.URL},
+		{URL: secondary.URL, Priority: 1},
+	}}
+	router := newTestCacheRouter(t, cdn, primary)
+
+	// The 5xx origin is skipped for its cooldown once it failed
+	for i := range 3 {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://example.com/%d.png", i), nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Body.String() != "body" {
+			t.Fatalf("answered %d %q, want 200 from the backup", rec.Code, rec.Body.String())
+		}
+	}
+	if failing.Load() != 1 || backup.Load() != 3 {
+		t.Errorf("origins got %d and %d requests, want 1 and 3", failing.Load(), backup.Load())
+	}
+}
+
+func TestProxyFailover(t *testing.T) {
+	var backup atomic.Int32
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(failing.Close)
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backup.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.RequestURI(), body)
+	}))
+	t.Cleanup(secondary.Close)
+
+	post := func(primary string) *httptest.ResponseRecorder {
+		cdn := domain.CDN{Domain: "example.com", Origin: primary, IsActive: true, Origins: []domain.OriginServer{
+			{URL: primary},
+			{URL: secondary.URL, Priority: 1},
+		}}
+		router := newTestCacheRouter(t, cdn, secondary)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://example.com/api/items?x=1", strings.NewReader("payload")))
+		return rec
+	}
+
+	// Requests go on to the next origin when one cannot be reached
+	if rec := post(down.URL); rec.Code != http.StatusCreated || rec.Body.String() != "POST /api/items?x=1 payload" {
+		t.Errorf("answered %d %q, want 201 from the backup with the body", rec.Code, rec.Body.String())
+	}
+
+	// but not once an origin may have acted on them
+	if rec := post(failing.URL); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("answered %d, want the 503 of the origin reached", rec.Code)
+	}
+	if n := backup.Load(); n != 1 {
+		t.Errorf("backup got %d requests, want 1", n)
+	}
+}
+
+// newFillRequest returns a fill request made for the clients in
+// X-Forwarded-For.
+func newFillRequest(forwardedFor string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/a.png", nil)
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	return req
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+)
+
+// Responses that vary on request headers are cached under a secondary key per
+// variant. The primary key then holds an index item recording the Vary header
+// names, which lookups use to find the variant matching a request.
+const variantKeySeparator = "#vary="
+
+// varyNames returns the sorted canonical header names listed in the Vary
+// header, or just "*" when the response varies on more than request headers.
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, line := range header.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return []string{"*"}
+			}
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// isCacheableVary reports whether a response with the given headers can be
+// stored: Vary: * means no later request can be matched to it.
+func isCacheableVary(header http.Header) bool {
+	return !slices.Contains(varyNames(header), "*")
+}
+
+// primaryKey strips the variant part of a cache key.
+func primaryKey(cacheKey string) string {
+	primary, _, _ := strings.Cut(cacheKey, variantKeySeparator)
+	return primary
+}
+
+// variantKey returns the secondary key of the variant selected by the values
+// of the vary headers in reqHeader.
+func variantKey(primary string, vary []string, reqHeader http.Header) string {
+	h := sha256.New()
+	for _, name := range vary {
+		value := strings.Join(reqHeader.Values(name), ",")
+		_, _ = io.WriteString(h, name+":"+strings.ReplaceAll(value, " ", "")+"\n")
+	}
+	return fmt.Sprintf("%s%s%x", primary, variantKeySeparator, h.Sum(nil)[:8])
+}
+
+// storageKey returns the key a response to a request with reqHeader is cached
+// under.
+func storageKey(cacheKey string, respHeader, reqHeader http.Header) string {
+	primary := primaryKey(cacheKey)
+	if vary := varyNames(respHeader); len(vary) > 0 {
+		return variantKey(primary, vary, reqHeader)
+	}
+	return primary
+}
+
+// lookup returns the item cached for a request with reqHeader and the key it
+// is cached under, following the Vary index of the primary key if any.
+func (s *cacheService) lookup(cacheKey string, reqHeader http.Header) (string, *domain.CacheItem, bool) {
+	cacheKey = primaryKey(cacheKey)
+	item, found := s.cacheItemRepository.Get(cacheKey)
+	if !found || len(item.Vary) == 0 {
+		return cacheKey, item, found
+	}
+
+	cacheKey = variantKey(cacheKey, item.Vary, reqHeader)
+	item, found = s.cacheItemRepository.Get(cacheKey)
+	return cacheKey, item, found
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestVaryNames(t *testing.T) {
+	for _, tc := range []struct {
+		vary []string
+		want []string
+	}{
+		{nil, nil},
+		{[]string{"accept-encoding"}, []string{"Accept-Encoding"}},
+		{[]string{"Accept-Language, accept-encoding", "Accept-Encoding"}, []string{"Accept-Encoding", "Accept-Language"}},
+		{[]string{"Accept-Encoding, *"}, []string{"*"}},
+		{[]string{" , "}, nil},
+	} {
+		header := http.Header{"Vary": tc.vary}
+		if got := varyNames(header); !slices.Equal(got, tc.want) {
+			t.Errorf("varyNames(%q) = %q, want %q", tc.vary, got, tc.want)
+		}
+		if got, want := isCacheableVary(header), !slices.Contains(tc.want, "*"); got != want {
+			t.Errorf("isCacheableVary(%q) = %v, want %v", tc.vary, got, want)
+		}
+	}
+}
+
+func TestVariantKeys(t *testing.T) {
+	const primary = "example.com/a.css"
+	vary := []string{"Accept-Encoding"}
+	key := func(values ...string) string {
+		return variantKey(primary, vary, http.Header{"Accept-Encoding": values})
+	}
+
+	gzip := key("gzip, br")
+	if !strings.HasPrefix(gzip, primary+variantKeySeparator) || primaryKey(gzip) != primary {
+		t.Errorf("variant key %q does not extend %q", gzip, primary)
+	}
+	if key("gzip,br") != gzip || key("gzip", "br") != gzip {
+		t.Error("variant key depends on whitespace or header lines")
+	}
+	if key("br") == gzip || key() == gzip {
+		t.Error("different header values share a variant key")
+	}
+
+	reqHeader := http.Header{"Accept-Encoding": {"gzip, br"}}
+	if got := storageKey(primary, http.Header{"Vary": {"accept-encoding"}}, reqHeader); got != gzip {
+		t.Errorf("storageKey = %q, want the variant key %q", got, gzip)
+	}
+	if got := storageKey(gzip, http.Header{}, reqHeader); got != primary {
+		t.Errorf("storageKey without Vary = %q, want %q", got, primary)
+	}
+}
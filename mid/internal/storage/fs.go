@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const tmpSuffix = ".tmp"
+
+// fsStorage stores blobs as files under a root directory. Blobs are written
+// to temporary files next to them and renamed into place.
+type fsStorage struct {
+	root string
+}
+
+// NewFS returns a storage keeping blobs under root, removing the temporary
+// files of writes interrupted by a crash.
+func NewFS(root string) (Storage, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	_ = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(d.Name(), tmpSuffix) {
+			_ = os.Remove(p)
+		}
+		return nil
+	})
+	return &fsStorage{root: root}, nil
+}
+
+func (s *fsStorage) path(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(name))
+}
+
+func (s *fsStorage) Create(name string) (Writer, error) {
+	p := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+
+	// Concurrent writers of the same blob never share a temporary file
+	file, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*"+tmpSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &fsWriter{file: file, path: p}, nil
+}
+
+func (s *fsStorage) Open(name string) (Reader, int64, error) {
+	file, err := os.Open(s.path(name))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+func (s *fsStorage) Size(name string) (int64, error) {
+	info, err := os.Stat(s.path(name))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Patch writes a copy of the file starting with head and moves it into
+// place like a new blob, so a crash leaves either version intact. Readers
+// that opened the file keep reading the unpatched one.
+func (s *fsStorage) Patch(name string, head []byte) error {
+	src, err := os.Open(s.path(name))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	w, err := s.Create(name)
+	if err != nil {
+		return err
+	}
+	file := w.(*fsWriter).file
+	_, err = file.Write(head)
+	if err == nil {
+		_, err = src.Seek(int64(len(head)), io.SeekStart)
+	}
+	if err == nil {
+		_, err = io.Copy(file, src)
+	}
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Commit()
+}
+
+func (s *fsStorage) Rename(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(s.path(to)), 0755); err != nil {
+		return err
+	}
+	return os.Rename(s.path(from), s.path(to))
+}
+
+func (s *fsStorage) Delete(name string) error {
+	if err := os.Remove(s.path(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *fsStorage) Walk(fn func(name string)) error {
+	return filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(d.Name(), tmpSuffix) {
+			return nil
+		}
+		if rel, err := filepath.Rel(s.root, p); err == nil {
+			fn(filepath.ToSlash(rel))
+		}
+		return nil
+	})
+}
+
+type fsWriter struct {
+	file *os.File
+	path string
+}
+
+func (w *fsWriter) WriteAt(p []byte, off int64) (int, error) {
+	return w.file.WriteAt(p, off)
+}
+
+// OpenPartial opens the temporary file, which is gone once the writer is done.
+func (w *fsWriter) OpenPartial() (Reader, error) {
+	return os.Open(w.file.Name())
+}
+
+// Commit flushes the blob to disk and moves it into place.
+func (w *fsWriter) Commit() error {
+	err := w.file.Sync()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(w.file.Name(), w.path)
+	}
+	if err != nil {
+		_ = os.Remove(w.file.Name())
+		return err
+	}
+	return syncDir(filepath.Dir(w.path))
+}
+
+func (w *fsWriter) Abort() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
+
+// syncDir flushes the directory entries of dir, making renames durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
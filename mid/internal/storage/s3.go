@@ -0,0 +1,640 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	spoolSuffix = ".spool"
+	// headSuffix names the objects holding the patched start of a blob
+	headSuffix = ".head"
+	// emptyPayloadHash is the SHA-256 of an empty request body
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// maxReadAhead bounds the bytes skipped on a ranged GET instead of
+	// starting another one
+	maxReadAhead = 64 << 10
+)
+
+// S3Options configure the S3 storage backend.
+type S3Options struct {
+	Endpoint  string // e.g. http://minio:9000
+	Region    string
+	Bucket    string
+	Prefix    string // prepended to blob names, letting services share a bucket
+	AccessKey string
+	SecretKey string
+	SpoolDir  string // where blobs are written before being uploaded
+
+	ConnectTimeout time.Duration // to connect to the endpoint, 0 means none
+	Timeout        time.Duration // a request may take, body included, 0 means none
+}
+
+// s3Storage stores blobs as objects of an S3-compatible bucket, addressed
+// path-style and signed with Signature Version 4. Blobs are spooled to a local
+// file while they are written and uploaded with a single PUT on commit.
+//
+// Objects cannot be modified, so a patched blob keeps its patched start in a
+// small object of its own, named after the blob with headSuffix, that readers
+// of the blob see in place of its first bytes.
+type s3Storage struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+
+	mu    sync.Mutex
+	heads map[string]int64 // sizes of the head objects by blob name
+}
+
+// NewS3 returns a storage keeping blobs in the bucket of opts, creating the
+// bucket if it does not exist yet.
+func NewS3(opts S3Options) (Storage, error) {
+	if opts.Bucket == "" {
+		return nil, errors.New("S3_BUCKET is required")
+	}
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT %q", opts.Endpoint)
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if err := os.MkdirAll(opts.SpoolDir, 0755); err != nil {
+		return nil, err
+	}
+
+	// Remove the spool files of uploads interrupted by a crash
+	if spooled, err := filepath.Glob(filepath.Join(opts.SpoolDir, "*"+spoolSuffix)); err == nil {
+		for _, name := range spooled {
+			_ = os.Remove(name)
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.ResponseHeaderTimeout = opts.Timeout
+	client := &http.Client{Transport: transport, Timeout: opts.Timeout}
+
+	s := &s3Storage{opts: opts, endpoint: endpoint, client: client, heads: make(map[string]int64)}
+	if err := s.ensureBucket(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *s3Storage) ensureBucket() error {
+	resp, err := s.do(http.MethodHead, "", nil, nil, nil, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		return checkStatus(resp, "head bucket")
+	}
+
+	resp, err = s.do(http.MethodPut, "", nil, nil, nil, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp, "create bucket")
+}
+
+func (s *s3Storage) Create(name string) (Writer, error) {
+	file, err := os.CreateTemp(s.opts.SpoolDir, "*"+spoolSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Writer{storage: s, name: name, file: file}, nil
+}
+
+func (s *s3Storage) Open(name string) (Reader, int64, error) {
+	resp, err := s.do(http.MethodGet, s.key(name), nil, nil, nil, emptyPayloadHash)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := checkStatus(resp, "get "+name); err != nil {
+		resp.Body.Close()
+		return nil, 0, err
+	}
+
+	// The body is read on demand, and only when reads follow each other
+	r := &s3Reader{storage: s, name: name, body: resp.Body, headSize: s.headSize(name)}
+	return r, resp.ContentLength, nil
+}
+
+func (s *s3Storage) Size(name string) (int64, error) {
+	resp, err := s.do(http.MethodHead, s.key(name), nil, nil, nil, emptyPayloadHash)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if err := checkStatus(resp, "head "+name); err != nil {
+		return 0, err
+	}
+	return resp.ContentLength, nil
+}
+
+// Patch stores head in the head object of the blob.
+func (s *s3Storage) Patch(name string, head []byte) error {
+	resp, err := s.do(http.MethodPut, s.key(name)+headSuffix, nil, nil, bytes.NewReader(head), sha256Hex(head))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, "patch "+name); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.heads[name] = int64(len(head))
+	s.mu.Unlock()
+	return nil
+}
+
+// Rename copies the object and deletes the original, since S3 cannot move
+// objects.
+func (s *s3Storage) Rename(from, to string) error {
+	if err := s.deleteHead(to); err != nil {
+		return err
+	}
+	if err := s.copyObject(s.key(from), s.key(to)); err != nil {
+		return err
+	}
+	if size := s.headSize(from); size > 0 {
+		if err := s.copyObject(s.key(from)+headSuffix, s.key(to)+headSuffix); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.heads[to] = size
+		s.mu.Unlock()
+	}
+	return s.Delete(from)
+}
+
+func (s *s3Storage) copyObject(from, to string) error {
+	header := http.Header{"X-Amz-Copy-Source": {"/" + s.opts.Bucket + "/" + uriEncode(from, true)}}
+	resp, err := s.do(http.MethodPut, to, nil, header, nil, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, "copy "+from); err != nil {
+		return err
+	}
+
+	// Copies can fail after the status line was sent
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err == nil && result.XMLName.Local == "Error" {
+		return fmt.Errorf("copy %s: %s", from, result.Code)
+	}
+	return nil
+}
+
+func (s *s3Storage) Delete(name string) error {
+	if err := s.deleteObject(s.key(name)); err != nil {
+		return err
+	}
+	return s.deleteHead(name)
+}
+
+// deleteHead removes the head object of the blob name, if it has one.
+func (s *s3Storage) deleteHead(name string) error {
+	if s.headSize(name) == 0 {
+		return nil
+	}
+	if err := s.deleteObject(s.key(name) + headSuffix); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.heads, name)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *s3Storage) deleteObject(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, nil, nil, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return checkStatus(resp, "delete "+key)
+}
+
+// headSize returns the size of the head object of the blob name, 0 when it
+// has none.
+func (s *s3Storage) headSize(name string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.heads[name]
+}
+
+// listBucketResult is the response of ListObjectsV2.
+type listBucketResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Walk also learns which blobs have a head object. Head objects are listed
+// right after their blob, so the last blob listed is only passed to fn once
+// the next object shows whether it has one.
+func (s *s3Storage) Walk(fn func(name string)) error {
+	pending := ""
+	flush := func() {
+		if pending != "" {
+			fn(pending)
+			pending = ""
+		}
+	}
+	defer flush()
+
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.opts.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(http.MethodGet, "", query, nil, nil, emptyPayloadHash)
+		if err != nil {
+			return err
+		}
+		var result listBucketResult
+		err = checkStatus(resp, "list")
+		if err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, object := range result.Contents {
+			name := strings.TrimPrefix(object.Key, s.opts.Prefix)
+			if blob, ok := strings.CutSuffix(name, headSuffix); ok {
+				s.mu.Lock()
+				s.heads[blob] = object.Size
+				s.mu.Unlock()
+				continue
+			}
+			flush()
+			pending = name
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// get returns the whole object key.
+func (s *s3Storage) get(key string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, key, nil, nil, nil, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, "get "+key); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// key returns the object key of the blob name.
+func (s *s3Storage) key(name string) string {
+	return s.opts.Prefix + name
+}
+
+// do sends a signed request for the object key, or for the bucket when key
+// is empty.
+func (s *s3Storage) do(method string, key string, query url.Values, header http.Header, body io.ReadSeeker, payloadHash string) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.opts.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, true)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		size, err := body.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		req.Body, req.ContentLength = io.NopCloser(body), size
+	}
+
+	signV4(req, payloadHash, s.opts.Region, s.opts.AccessKey, s.opts.SecretKey, time.Now())
+	return s.client.Do(req)
+}
+
+// checkStatus turns unsuccessful responses into errors, matching
+// fs.ErrNotExist for missing objects.
+func checkStatus(resp *http.Response, op string) error {
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return &fs.PathError{Op: op, Path: resp.Request.URL.Path, Err: fs.ErrNotExist}
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%s: %s", op, resp.Status)
+	}
+	return nil
+}
+
+// signV4 signs req for S3 with AWS Signature Version 4, covering the host,
+// Range and x-amz-* headers.
+func signV4(req *http.Request, payloadHash string, region string, accessKey string, secretKey string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	values := map[string]string{"host": req.URL.Host}
+	for name, v := range req.Header {
+		name = strings.ToLower(name)
+		if name == "range" || strings.HasPrefix(name, "x-amz-") {
+			values[name] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalQuery encodes query sorted by key as Signature Version 4 expects,
+// which is also how it is sent.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, false)+"="+uriEncode(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes every byte of s but unreserved characters, and
+// slashes when keepSlash is set.
+func uriEncode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+type s3Writer struct {
+	storage *s3Storage
+	name    string
+	file    *os.File
+}
+
+func (w *s3Writer) WriteAt(p []byte, off int64) (int, error) {
+	return w.file.WriteAt(p, off)
+}
+
+// OpenPartial opens the spool file, which is gone once the writer is done.
+func (w *s3Writer) OpenPartial() (Reader, error) {
+	return os.Open(w.file.Name())
+}
+
+// Commit uploads the spooled blob, dropping the head object of the blob it
+// replaces first.
+func (w *s3Writer) Commit() error {
+	defer w.Abort()
+
+	if err := w.storage.deleteHead(w.name); err != nil {
+		return err
+	}
+
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, w.file); err != nil {
+		return err
+	}
+
+	resp, err := w.storage.do(http.MethodPut, w.storage.key(w.name), nil, nil, w.file, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp, "put "+w.name)
+}
+
+func (w *s3Writer) Abort() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
+
+// s3Reader reads an object through ranged GETs. A response body is kept open
+// at the end of the last read, so reading an object from start to end, even
+// skipping small gaps, takes a single request. The first headSize bytes come
+// from the head object of the blob, fetched when they are read.
+type s3Reader struct {
+	storage  *s3Storage
+	name     string
+	headSize int64
+
+	mu   sync.Mutex
+	head []byte
+	body io.ReadCloser
+	pos  int64 // offset body is at
+}
+
+func (r *s3Reader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if off >= r.headSize {
+		return r.readObject(p, off)
+	}
+	if r.head == nil {
+		head, err := r.storage.get(r.storage.key(r.name) + headSuffix)
+		if err != nil {
+			return 0, err
+		}
+		r.head = head
+		r.headSize = int64(len(head))
+		if off >= r.headSize {
+			return r.readObject(p, off)
+		}
+	}
+
+	n := copy(p, r.head[off:])
+	if n == len(p) {
+		return n, nil
+	}
+	m, err := r.readObject(p[n:], off+int64(n))
+	return n + m, err
+}
+
+// readObject reads from the object itself, r.mu being held.
+func (r *s3Reader) readObject(p []byte, off int64) (int, error) {
+	if r.body != nil && off >= r.pos && off-r.pos <= maxReadAhead {
+		if _, err := io.CopyN(io.Discard, r.body, off-r.pos); err != nil {
+			r.closeBody()
+		} else {
+			r.pos = off
+		}
+	}
+	if r.body == nil || r.pos != off {
+		if err := r.openAt(off); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := io.ReadFull(r.body, p)
+	r.pos += int64(n)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		// A body kept open across reads may outlive the request timeout, the
+		// rest is read with a new request
+		var m int
+		if err = r.openAt(r.pos); err == nil {
+			m, err = io.ReadFull(r.body, p[n:])
+			r.pos += int64(m)
+		}
+		n += m
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err != nil {
+		r.closeBody()
+	}
+	return n, err
+}
+
+func (r *s3Reader) openAt(off int64) error {
+	r.closeBody()
+
+	header := http.Header{"Range": {"bytes=" + strconv.FormatInt(off, 10) + "-"}}
+	resp, err := r.storage.do(http.MethodGet, r.storage.key(r.name), nil, header, nil, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		resp.Body.Close()
+		return io.EOF
+	}
+	if err := checkStatus(resp, "get "+r.name); err != nil {
+		resp.Body.Close()
+		return err
+	}
+
+	// Servers ignoring the range send the whole object
+	if resp.StatusCode != http.StatusPartialContent {
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			resp.Body.Close()
+			return io.EOF
+		}
+	}
+	r.body, r.pos = resp.Body, off
+	return nil
+}
+
+func (r *s3Reader) closeBody() {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+}
+
+func (r *s3Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closeBody()
+	return nil
+}
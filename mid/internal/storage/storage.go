@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/config"
+)
+
+// Backends cache entries can be stored in
+const (
+	BackendFS     = "fs"     // files under CACHE_DIR
+	BackendMemory = "memory" // process memory, lost on restart
+	BackendS3     = "s3"     // objects in an S3-compatible bucket
+)
+
+// Storage stores the cache entries of a node as blobs under slash-separated
+// names. Reading or renaming a blob that does not exist fails with an error
+// matching fs.ErrNotExist.
+type Storage interface {
+	// Create starts writing the blob name. It replaces the previous one
+	// atomically once committed and is invisible until then.
+	Create(name string) (Writer, error)
+	// Open opens the blob name and returns its size.
+	Open(name string) (Reader, int64, error)
+	// Size returns the size of the blob name.
+	Size(name string) (int64, error)
+	// Patch overwrites the start of the committed blob name with head, which
+	// readers opening the blob afterwards see. Patches are atomic: a crash
+	// leaves either the blob or the patched one.
+	Patch(name string, head []byte) error
+	// Rename moves the blob from to to, replacing any blob named to.
+	Rename(from, to string) error
+	// Delete removes the blob name. Missing blobs are not an error.
+	Delete(name string) error
+	// Walk calls fn with the name of every committed blob.
+	Walk(fn func(name string)) error
+}
+
+// Writer writes a blob that becomes visible on Commit.
+type Writer interface {
+	io.WriterAt
+	// OpenPartial opens what has been written so far, so it can be read while
+	// the blob is still being written. It fails once the writer is done.
+	OpenPartial() (Reader, error)
+	Commit() error
+	Abort()
+}
+
+// Reader reads a blob. Its methods may be called concurrently.
+type Reader interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// New returns the storage backend selected by cfg.
+func New(cfg *config.Config) (Storage, error) {
+	switch cfg.StorageBackend {
+	case BackendFS, "":
+		return NewFS(cfg.CacheDir)
+	case BackendMemory:
+		return NewMemory(), nil
+	case BackendS3:
+		return NewS3(S3Options{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			Prefix:    cfg.S3Prefix,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			SpoolDir:  cfg.CacheDir,
+
+			ConnectTimeout: cfg.S3ConnectTimeoutDuration,
+			Timeout:        cfg.S3TimeoutDuration,
+		})
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+}
@@ -0,0 +1,20 @@
+package subscriber
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/AmirAghaee/go-cdn-stack/pkg/messaging"
+)
+
+// subscribeJobs calls process with every job of type J published to subject.
+func subscribeJobs[J any](broker messaging.MessageBrokerInterface, subject string, process func(J)) error {
+	return broker.Subscribe(subject, func(msg string) {
+		var job J
+		if err := json.Unmarshal([]byte(msg), &job); err != nil {
+			log.Printf("❌ failed to unmarshal %s job: %v", subject, err)
+			return
+		}
+		process(job)
+	})
+}
@@ -0,0 +1,41 @@
+package subscriber
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/domain"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/repository"
+	"github.com/AmirAghaee/go-cdn-stack/pkg/messaging"
+)
+
+type MidSubscriberInterface interface {
+	Register() error
+}
+
+// MidSubscriber keeps track of the mids from the health they publish, this
+// mid included.
+type MidSubscriber struct {
+	broker     messaging.MessageBrokerInterface
+	repository repository.MidRepositoryInterface
+}
+
+func NewMidSubscriber(broker messaging.MessageBrokerInterface, repository repository.MidRepositoryInterface) MidSubscriberInterface {
+	return &MidSubscriber{
+		broker:     broker,
+		repository: repository,
+	}
+}
+
+func (s *MidSubscriber) Register() error {
+	return s.broker.Subscribe("cdn.mid.health", func(msg string) {
+		var health domain.HealthStatus
+		if err := json.Unmarshal([]byte(msg), &health); err != nil {
+			log.Printf("❌ failed to unmarshal mid health: %v", err)
+			return
+		}
+		if health.Status == "ok" && health.Instance != "" {
+			s.repository.Seen(health.Instance)
+		}
+	})
+}
@@ -0,0 +1,26 @@
+package subscriber
+
+import (
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/service"
+	"github.com/AmirAghaee/go-cdn-stack/pkg/messaging"
+)
+
+type PrefetchSubscriberInterface interface {
+	Register() error
+}
+
+type PrefetchSubscriber struct {
+	broker  messaging.MessageBrokerInterface
+	service service.PrefetchServiceInterface
+}
+
+func NewPrefetchSubscriber(broker messaging.MessageBrokerInterface, service service.PrefetchServiceInterface) PrefetchSubscriberInterface {
+	return &PrefetchSubscriber{
+		broker:  broker,
+		service: service,
+	}
+}
+
+func (s *PrefetchSubscriber) Register() error {
+	return subscribeJobs(s.broker, "cdn.prefetch", s.service.Process)
+}
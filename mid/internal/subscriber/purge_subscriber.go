@@ -0,0 +1,26 @@
+package subscriber
+
+import (
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/service"
+	"github.com/AmirAghaee/go-cdn-stack/pkg/messaging"
+)
+
+type PurgeSubscriberInterface interface {
+	Register() error
+}
+
+type PurgeSubscriber struct {
+	broker  messaging.MessageBrokerInterface
+	service service.PurgeServiceInterface
+}
+
+func NewPurgeSubscriber(broker messaging.MessageBrokerInterface, service service.PurgeServiceInterface) PurgeSubscriberInterface {
+	return &PurgeSubscriber{
+		broker:  broker,
+		service: service,
+	}
+}
+
+func (s *PurgeSubscriber) Register() error {
+	return subscribeJobs(s.broker, "cdn.purge", s.service.Process)
+}
@@ -3,12 +3,14 @@ package main
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/AmirAghaee/go-cdn-stack/mid/internal/client"
 	"github.com/AmirAghaee/go-cdn-stack/mid/internal/config"
 	"github.com/AmirAghaee/go-cdn-stack/mid/internal/handler/http"
 	"github.com/AmirAghaee/go-cdn-stack/mid/internal/repository"
 	"github.com/AmirAghaee/go-cdn-stack/mid/internal/service"
+	"github.com/AmirAghaee/go-cdn-stack/mid/internal/storage"
 	"github.com/AmirAghaee/go-cdn-stack/mid/internal/subscriber"
 	"github.com/AmirAghaee/go-cdn-stack/pkg/messaging"
 
@@ -28,7 +30,7 @@ func main() {
 	}
 
 	// setup health publisher
-	healthService := service.NewHealthService(natsBroker, cfg.AppName, cfg.AppCacheURL, AppVersion)
+	healthService := service.NewHealthService(natsBroker, cfg.AppName, cfg.AppAdvertiseURL, AppVersion)
 	stopChan := make(chan struct{})
 	go healthService.Start(stopChan)
 	defer close(stopChan)
@@ -36,13 +38,27 @@ func main() {
 	// setup clients
 	controlPanelClient := client.NewControlPanelClient(cfg.ControlPanelURL, cfg.JWTSecret)
 
+	// setup cache storage
+	cacheStorage, err := storage.New(cfg)
+	if err != nil {
+		log.Fatalf("cache storage: %v", err)
+	}
+
 	// setup repository
 	cdnRepository := repository.NewCdnRepository()
-	cacheItemRepository := repository.NewCacheItemRepository(cfg)
+	cacheItemRepository := repository.NewCacheItemRepository(cfg, cacheStorage)
+	edgeRepository := repository.NewEdgeRepository()
+	midRepository := repository.NewMidRepository(cfg.MidNodeList, 30*time.Second) // three health messages
+	purgeRepository := repository.NewPurgeRepository()
+	prefetchRepository := repository.NewPrefetchRepository()
 
 	// setup services
 	cdnSnapshotService := service.NewCdnSnapshotService(controlPanelClient, cdnRepository)
-	cacheService := service.NewCacheService(cfg, cdnRepository, cacheItemRepository)
+	upstreamPool := service.NewUpstreamPool(cfg)
+	cacheService := service.NewCacheService(cfg, cdnRepository, cacheItemRepository, upstreamPool)
+	purgeService := service.NewPurgeService(natsBroker, cdnRepository, cacheItemRepository, edgeRepository, purgeRepository, cfg.AppName, cfg.AppCacheURL)
+	prefetchService := service.NewPrefetchService(cfg, natsBroker, cdnRepository, midRepository, edgeRepository, prefetchRepository, cfg.AppName, cfg.AppCacheURL)
+	adminService := service.NewAdminService(cfg, cdnRepository, cacheItemRepository)
 
 	// first time sync with control panel
 	if err := cdnSnapshotService.ProcessSnapshot(); err != nil {
@@ -54,12 +70,24 @@ func main() {
 	if err := cdnSnapshotSub.Register(); err != nil {
 		log.Fatalf("failed to register cdn snapshot subscriber: %v", err)
 	}
+	purgeSub := subscriber.NewPurgeSubscriber(natsBroker, purgeService)
+	if err := purgeSub.Register(); err != nil {
+		log.Fatalf("failed to register purge subscriber: %v", err)
+	}
+	prefetchSub := subscriber.NewPrefetchSubscriber(natsBroker, prefetchService)
+	if err := prefetchSub.Register(); err != nil {
+		log.Fatalf("failed to register prefetch subscriber: %v", err)
+	}
+	midSub := subscriber.NewMidSubscriber(natsBroker, midRepository)
+	if err := midSub.Register(); err != nil {
+		log.Fatalf("failed to register mid subscriber: %v", err)
+	}
 
 	// Load existing cache and start cleaner
 	cacheItemRepository.LoadFromDisk()
 	cacheItemRepository.StartCleaner()
 
-	go startInternalPort(cfg, cdnRepository)
+	go startInternalPort(cfg, cdnRepository, edgeRepository, midRepository, purgeService, prefetchService, adminService)
 
 	r := gin.Default()
 
@@ -92,15 +120,15 @@ func main() {
 	_ = r.Run(cfg.AppCacheURL)
 }
 
-func startInternalPort(cfg *config.Config, cdnRepository repository.CdnRepositoryInterface) {
-	edgeRepository := repository.NewEdgeRepository()
-	edgeService := service.NewEdgeService(edgeRepository, cdnRepository)
+func startInternalPort(cfg *config.Config, cdnRepository repository.CdnRepositoryInterface, edgeRepository repository.EdgeRepositoryInterface, midRepository repository.MidRepositoryInterface, purgeService service.PurgeServiceInterface, prefetchService service.PrefetchServiceInterface, adminService service.AdminServiceInterface) {
+	edgeService := service.NewEdgeService(cfg, edgeRepository, cdnRepository, midRepository, purgeService, prefetchService)
 
 	r := gin.Default()
 
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	http.RegisterInternalRoutes(r, edgeService)
+	http.RegisterAdminRoutes(r, adminService)
 
 	fmt.Printf("Internal Mid API running on %s\n", cfg.AppInternalURL)
 	if err := r.Run(cfg.AppInternalURL); err != nil {